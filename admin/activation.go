@@ -0,0 +1,34 @@
+package admin
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// SD_LISTEN_FDS_START is the first file descriptor passed by systemd.
+const SD_LISTEN_FDS_START = 3
+
+// ActivationListener returns the listener passed by systemd socket
+// activation, or nil when the process was not socket activated. The
+// activation variables are removed, so the exec hooks don't inherit them.
+func ActivationListener() (net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+	syscall.CloseOnExec(SD_LISTEN_FDS_START)
+	file := os.NewFile(uintptr(SD_LISTEN_FDS_START), "admin")
+	defer file.Close()
+	return net.FileListener(file)
+}
@@ -0,0 +1,214 @@
+// Package admin serves the status API of the service registration, and the
+// pause, resume and pinning operations.
+package admin
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/config"
+	"github.com/hortonworks/cloudbreak-service-registration/metrics"
+	"github.com/hortonworks/cloudbreak-service-registration/reconciler"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+// Viewer is implemented by the running service registration.
+type Viewer interface {
+	View() []reconciler.ViewEntry
+	Drift() []reconciler.DriftEntry
+	History(componentName string, host string, since time.Time) []reconciler.ComponentHistory
+	UnreachableAgents() map[string]time.Time
+	Failures(limit int) []reconciler.ServiceFailures
+	Components() []topology.HostComponent
+	Hosts() map[string]topology.Host
+	SetPaused(paused bool)
+	IsPaused() bool
+	Pins() []config.Pin
+	Pin(pin config.Pin) error
+	Unpin(serviceID string) bool
+}
+
+type PauseStatus struct {
+	Paused bool `json:"paused"`
+}
+
+func NewHandler(viewer Viewer) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cluster := r.URL.Query().Get("cluster")
+		var view = make([]reconciler.ViewEntry, 0)
+		for _, entry := range viewer.View() {
+			if len(cluster) == 0 || entry.Cluster == cluster {
+				view = append(view, entry)
+			}
+		}
+		writeJSON(w, view)
+	})
+	mux.HandleFunc("/v1/drift", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cluster := r.URL.Query().Get("cluster")
+		var drift = make([]reconciler.DriftEntry, 0)
+		for _, entry := range viewer.Drift() {
+			if len(cluster) == 0 || entry.Cluster == cluster {
+				drift = append(drift, entry)
+			}
+		}
+		writeJSON(w, drift)
+	})
+	mux.HandleFunc("/v1/history", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		var since time.Time
+		if value := query.Get("since"); len(value) > 0 {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				http.Error(w, "Invalid since duration: "+value, http.StatusBadRequest)
+				return
+			}
+			since = time.Now().Add(-d)
+		}
+		writeJSON(w, viewer.History(query.Get("component"), query.Get("host"), since))
+	})
+	mux.HandleFunc("/v1/agents/unreachable", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, viewer.UnreachableAgents())
+	})
+	mux.HandleFunc("/v1/failures", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var limit int
+		if value := r.URL.Query().Get("limit"); len(value) > 0 {
+			var err error
+			if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+				http.Error(w, "Invalid limit: "+value, http.StatusBadRequest)
+				return
+			}
+		}
+		writeJSON(w, viewer.Failures(limit))
+	})
+	mux.HandleFunc("/v1/topology", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		hosts := topology.Export(viewer.Components(), viewer.Hosts())
+		switch format := r.URL.Query().Get("format"); format {
+		case "", "json":
+			w.Header().Set("Content-Type", "application/json")
+			topology.WriteJSON(w, hosts)
+		case "dot":
+			w.Header().Set("Content-Type", "text/vnd.graphviz")
+			topology.WriteDOT(w, hosts)
+		default:
+			http.Error(w, "Unknown format: "+format, http.StatusBadRequest)
+		}
+	})
+	mux.HandleFunc("/v1/pause", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+		case "POST":
+			viewer.SetPaused(true)
+		case "DELETE":
+			viewer.SetPaused(false)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, PauseStatus{Paused: viewer.IsPaused()})
+	})
+	mux.HandleFunc("/v1/pins", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+		case "PUT":
+			var pin config.Pin
+			if err := json.NewDecoder(r.Body).Decode(&pin); err != nil {
+				http.Error(w, "Invalid pin: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := viewer.Pin(pin); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, viewer.Pins())
+	})
+	mux.HandleFunc("/v1/pins/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !viewer.Unpin(strings.TrimPrefix(r.URL.Path, "/v1/pins/")) {
+			http.Error(w, "Service is not pinned", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, viewer.Pins())
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.Default.WritePrometheus(w)
+	})
+	return mux
+}
+
+func NewServer(address string, viewer Viewer) *http.Server {
+	return &http.Server{
+		Addr:         address,
+		Handler:      NewHandler(viewer),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+}
+
+// Serve serves the admin API in the background, on the listener when it is
+// set, e.g. by socket activation, otherwise on the server's address. The API
+// is served over TLS when the server has a TLS config.
+func Serve(server *http.Server, listener net.Listener) {
+	go func() {
+		var err error
+		if listener != nil {
+			log.Println("Admin API listening on: " + listener.Addr().String())
+			if server.TLSConfig != nil {
+				listener = tls.NewListener(listener, server.TLSConfig)
+			}
+			err = server.Serve(listener)
+		} else if server.TLSConfig != nil {
+			log.Println("Admin API listening with TLS on: " + server.Addr)
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			log.Println("Admin API listening on: " + server.Addr)
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Println("Admin API stopped: " + err.Error())
+		}
+	}()
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,54 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/hortonworks/cloudbreak-service-registration/config"
+)
+
+// RequireAuth rejects the requests without the configured bearer token or
+// basic auth credentials.
+func RequireAuth(handler http.Handler, auth config.APIAuth) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAuthorized(r, auth) {
+			if len(auth.Username) > 0 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="service-registration"`)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func isAuthorized(r *http.Request, auth config.APIAuth) bool {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token := readSecret(auth.Token, auth.TokenFile)
+		return len(token) > 0 && equals(strings.TrimPrefix(header, "Bearer "), token)
+	}
+	if username, password, ok := r.BasicAuth(); ok && len(auth.Username) > 0 {
+		expected := readSecret(auth.Password, auth.PasswordFile)
+		return equals(username, auth.Username) && len(expected) > 0 && equals(password, expected)
+	}
+	return false
+}
+
+func readSecret(value string, path string) string {
+	if len(path) == 0 {
+		return value
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Println("Cannot read the API secret: " + err.Error())
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+func equals(actual string, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(actual), []byte(expected)) == 1
+}
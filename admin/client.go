@@ -0,0 +1,87 @@
+package admin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/config"
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+)
+
+const CLIENT_TIMEOUT = 10 * time.Second
+
+// Client calls the admin API of a running service registration, with the
+// credentials of the API auth. With TLS the server certificate is trusted
+// besides the system roots and is also presented as the client certificate.
+type Client struct {
+	HTTP    *http.Client
+	BaseURL string
+	Auth    config.APIAuth
+}
+
+// NewClient creates a client of the admin API listening on the address, the
+// address without a host means the local host.
+func NewClient(address string, auth config.APIAuth, tlsConfig TLSConfig) (*Client, error) {
+	if strings.HasPrefix(address, ":") {
+		address = "localhost" + address
+	}
+	client := &Client{HTTP: &http.Client{Timeout: CLIENT_TIMEOUT}, BaseURL: "http://" + address, Auth: auth}
+	if !tlsConfig.IsEnabled() {
+		return client, nil
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	certContent, err := ioutil.ReadFile(tlsConfig.CertFile)
+	if err != nil {
+		return nil, err
+	}
+	roots.AppendCertsFromPEM(certContent)
+	clientTLS := &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	if len(tlsConfig.ClientCAFile) > 0 {
+		certificate, err := tls.LoadX509KeyPair(tlsConfig.CertFile, tlsConfig.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		clientTLS.Certificates = []tls.Certificate{certificate}
+	}
+	client.HTTP.Transport = &http.Transport{TLSClientConfig: clientTLS}
+	client.BaseURL = "https://" + address
+	return client, nil
+}
+
+// SetPaused pauses or resumes the reconciliation and returns whether it is
+// paused afterwards.
+func (c *Client) SetPaused(paused bool) (bool, error) {
+	method := "DELETE"
+	if paused {
+		method = "POST"
+	}
+	req, _ := http.NewRequest(method, c.BaseURL+"/v1/pause", nil)
+	if token := readSecret(c.Auth.Token, c.Auth.TokenFile); len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if len(c.Auth.Username) > 0 {
+		req.SetBasicAuth(c.Auth.Username, readSecret(c.Auth.Password, c.Auth.PasswordFile))
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer httpclient.CloseBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return false, errors.New("Admin API responded with status " + strconv.Itoa(resp.StatusCode))
+	}
+	var status PauseStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return false, err
+	}
+	return status.Paused, nil
+}
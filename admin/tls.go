@@ -0,0 +1,49 @@
+package admin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+// TLSConfig holds the paths of the server certificate and key. The client
+// certificates are only required when the client CA is set.
+type TLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+func (c TLSConfig) IsEnabled() bool {
+	return len(c.CertFile) > 0 || len(c.KeyFile) > 0
+}
+
+// EnableTLS makes the server serve over TLS.
+func EnableTLS(server *http.Server, tlsConfig TLSConfig) error {
+	if len(tlsConfig.CertFile) == 0 || len(tlsConfig.KeyFile) == 0 {
+		return errors.New("Admin API TLS requires both a certificate and a key")
+	}
+	certificate, err := tls.LoadX509KeyPair(tlsConfig.CertFile, tlsConfig.KeyFile)
+	if err != nil {
+		return err
+	}
+	server.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if len(tlsConfig.ClientCAFile) > 0 {
+		caContent, err := ioutil.ReadFile(tlsConfig.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caContent) {
+			return errors.New("No certificate found in the client CA file: " + tlsConfig.ClientCAFile)
+		}
+		server.TLSConfig.ClientCAs = clientCAs
+		server.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return nil
+}
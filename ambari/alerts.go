@@ -0,0 +1,71 @@
+package ambari
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+const (
+	PORT_ALERT = "PORT"
+	WEB_ALERT  = "WEB"
+)
+
+type AlertDefinitionsResponse struct {
+	Items []struct {
+		AlertDefinition struct {
+			ComponentName string `json:"component_name"`
+			ServiceName   string `json:"service_name"`
+			Enabled       bool   `json:"enabled"`
+			Source        struct {
+				Type        string          `json:"type"`
+				DefaultPort float64         `json:"default_port"`
+				URI         json.RawMessage `json:"uri"`
+			} `json:"source"`
+		} `json:"AlertDefinition"`
+	} `json:"items"`
+}
+
+// GetAlertChecks translates the enabled port and web alert definitions of the
+// cluster into checks per component. The ports are the default ports of the
+// definitions, the configuration properties of their URIs are not resolved.
+// A port alert is preferred over a web alert of the same component.
+func (c *Client) GetAlertChecks(ctx context.Context, clusterName string) (map[string]topology.CheckInfo, error) {
+	req := c.newGETRequest(ctx, "/clusters/"+clusterName+"/alert_definitions?fields=AlertDefinition/component_name,AlertDefinition/service_name,AlertDefinition/enabled,AlertDefinition/source&AlertDefinition/source/type.in(PORT,WEB)")
+	resp, err := c.do(req, "alert_definitions")
+	if err != nil {
+		return nil, err
+	}
+	defer httpclient.CloseBody(resp)
+	var aresp AlertDefinitionsResponse
+	if err := decode(json.NewDecoder(resp.Body), &aresp); err != nil {
+		return nil, err
+	}
+	var checks = make(map[string]topology.CheckInfo)
+	for _, item := range aresp.Items {
+		definition := item.AlertDefinition
+		if !definition.Enabled || len(definition.ComponentName) == 0 {
+			continue
+		}
+		port := int64(definition.Source.DefaultPort)
+		if definition.Source.Type == WEB_ALERT {
+			var uri struct {
+				DefaultPort float64 `json:"default_port"`
+			}
+			json.Unmarshal(definition.Source.URI, &uri)
+			port = int64(uri.DefaultPort)
+		}
+		if port <= 0 {
+			continue
+		}
+		if existing, ok := checks[definition.ComponentName]; ok && existing.Type == PORT_ALERT {
+			continue
+		}
+		checks[definition.ComponentName] = topology.CheckInfo{Type: definition.Source.Type, Port: port}
+	}
+	log.Printf("Found alert based checks in %s: %d", clusterName, len(checks))
+	return checks, nil
+}
@@ -0,0 +1,317 @@
+package ambari
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+	"github.com/hortonworks/cloudbreak-service-registration/metrics"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+const (
+	DEFAULT_PAGE_SIZE    = 500
+	SERVER_PORT          = 8080
+	SERVER_COMPONENT     = "AMBARI_SERVER"
+	AGENT_COMPONENT      = "AMBARI_AGENT"
+	SERVER_ALIAS         = "ambari"
+	MAX_REQUEST_ATTEMPTS = 3
+	RETRY_DELAY          = time.Second
+)
+
+// IsAmbariComponent reports whether the component is the Ambari server or an
+// agent, which are registered on every included host.
+func IsAmbariComponent(componentName string) bool {
+	return componentName == SERVER_COMPONENT || componentName == AGENT_COMPONENT
+}
+
+// Client reads the hosts and the host components from the Ambari REST API.
+// BaseURL points to the API root, e.g. http://ambari-server:8080/api/v1, it is
+// replaced by the negotiated root of the server at ServerURL.
+type Client struct {
+	HTTP      *http.Client
+	BaseURL   string
+	ServerURL string
+	Username  string
+	Password  string
+	PageSize  int
+
+	lock       sync.Mutex
+	negotiated bool
+	dialect    dialect
+}
+
+func NewClient(httpClient *http.Client, address string, username string, password string) *Client {
+	serverURL := "http://" + address + ":" + strconv.Itoa(SERVER_PORT)
+	return &Client{
+		HTTP:      httpClient,
+		BaseURL:   serverURL + "/api/v1",
+		ServerURL: serverURL,
+		Username:  username,
+		Password:  password,
+		PageSize:  DEFAULT_PAGE_SIZE,
+	}
+}
+
+func (c *Client) newGETRequest(ctx context.Context, path string) *http.Request {
+	c.lock.Lock()
+	baseURL := c.BaseURL
+	c.lock.Unlock()
+	req, _ := http.NewRequest("GET", baseURL+path, nil)
+	req = req.WithContext(ctx)
+	req.Header.Add("X-Requested-By", "ambari")
+	req.SetBasicAuth(c.Username, c.Password)
+	return req
+}
+
+// do sends a GET request, repeating it on transport and server errors. The
+// non-2xx responses are returned as a StatusError.
+func (c *Client) do(req *http.Request, endpoint string) (*http.Response, error) {
+	var resp *http.Response
+	var err error
+	for attempt := 1; attempt <= MAX_REQUEST_ATTEMPTS; attempt++ {
+		resp, err = c.doOnce(req, endpoint)
+		if err == nil || !isRetryable(err) || attempt == MAX_REQUEST_ATTEMPTS {
+			break
+		}
+		log.Printf("Retrying the Ambari %s request: %s", endpoint, err.Error())
+		select {
+		case <-req.Context().Done():
+			return nil, err
+		case <-time.After(RETRY_DELAY):
+		}
+	}
+	return resp, err
+}
+
+func (c *Client) doOnce(req *http.Request, endpoint string) (*http.Response, error) {
+	start := time.Now()
+	resp, err := c.HTTP.Do(req)
+	if err == nil && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		err = newStatusError(resp, endpoint)
+		httpclient.CloseBody(resp)
+		resp = nil
+	}
+	metrics.Default.ObserveSince(metrics.AMBARI_REQUEST_DURATION, metrics.Labels{"endpoint": endpoint, "status": getErrorStatus(err)}, start)
+	if category := getErrorCategory(err); len(category) > 0 {
+		metrics.CountError(category)
+	}
+	return resp, err
+}
+
+// getPages reads a collection resource page by page, so large clusters are
+// never loaded in a single response. The decodePage function returns the number
+// of items found on the page.
+func (c *Client) getPages(ctx context.Context, endpoint string, path string, decodePage func(*json.Decoder) (int, error)) error {
+	pageSize := c.PageSize
+	if pageSize <= 0 {
+		pageSize = DEFAULT_PAGE_SIZE
+	}
+	for from := 0; ; from += pageSize {
+		req := c.newGETRequest(ctx, fmt.Sprintf("%s&page_size=%d&from=%d", path, pageSize, from))
+		resp, err := c.do(req, endpoint)
+		if err != nil {
+			return err
+		}
+		count, err := decodePage(json.NewDecoder(resp.Body))
+		httpclient.CloseBody(resp)
+		if err != nil {
+			return err
+		}
+		if count < pageSize {
+			return nil
+		}
+	}
+}
+
+// Ping requests the clusters once with the credentials of the client and
+// returns the time of the server from the Date header of the response, zero
+// when the header is missing.
+func (c *Client) Ping(ctx context.Context) (time.Time, error) {
+	resp, err := c.doOnce(c.newGETRequest(ctx, "/clusters"), "clusters")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer httpclient.CloseBody(resp)
+	serverTime, _ := http.ParseTime(resp.Header.Get("Date"))
+	return serverTime, nil
+}
+
+func (c *Client) GetClusterNames(ctx context.Context) ([]string, error) {
+	req := c.newGETRequest(ctx, "/clusters")
+	var clusterNames = make([]string, 0)
+	resp, err := c.do(req, "clusters")
+	if err != nil {
+		return nil, err
+	}
+	defer httpclient.CloseBody(resp)
+	var cresp ClusterResponse
+	decoder := json.NewDecoder(resp.Body)
+	if err = decode(decoder, &cresp); err != nil {
+		return nil, err
+	}
+	for _, item := range cresp.Items {
+		if len(item.Cluster.Name) > 0 {
+			clusterNames = append(clusterNames, item.Cluster.Name)
+		}
+	}
+	if len(clusterNames) > 0 {
+		log.Println("Found clusters: " + strings.Join(clusterNames, ", "))
+	} else {
+		log.Println("Cluster not found, yet")
+	}
+	return clusterNames, nil
+}
+
+func (c *Client) GetHosts(ctx context.Context) (map[string]topology.Host, error) {
+	var hosts = make(map[string]topology.Host)
+	path := "/hosts?fields=" + c.getDialect().HostFields + "&sortBy=Hosts/host_name.asc"
+
+	err := c.getPages(ctx, "hosts", path, func(decoder *json.Decoder) (int, error) {
+		var hresp HostsResponse
+		if err := decode(decoder, &hresp); err != nil {
+			return 0, err
+		}
+		for _, item := range hresp.Items {
+			// the fingerprint covers what the components depend on. The
+			// heartbeat time would change it on every check, a lost heartbeat
+			// changes the host state and a restarted agent the registration
+			// time instead.
+			desiredConfigs, _ := json.Marshal(item.Host.DesiredConfigs)
+			attributes := topology.NewAttributes(map[string]string{
+				RACK_ATTRIBUTE:             item.Host.Rack,
+				OS_TYPE_ATTRIBUTE:          item.Host.OSType,
+				OS_ARCH_ATTRIBUTE:          item.Host.OSArch,
+				PUBLIC_HOST_NAME_ATTRIBUTE: item.Host.PublicHostName,
+			})
+			hosts[item.Host.HostName] = topology.Host{
+				IP:         item.Host.IP,
+				Rack:       item.Host.Rack,
+				OSType:     item.Host.OSType,
+				State:      item.Host.HostState,
+				Attributes: attributes,
+				Fingerprint: fmt.Sprintf("%s|%s|%s|%s|%s|%s|%d", item.Host.IP, item.Host.Rack, item.Host.HostState,
+					item.Host.HostStatus, desiredConfigs, attributes, item.Host.LastRegistration),
+			}
+		}
+		return len(hresp.Items), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) > 0 {
+		log.Printf("Found hosts: %d", len(hosts))
+	} else {
+		log.Println("There are not hosts yet")
+	}
+	return hosts, nil
+}
+
+func (c *Client) GetHostComponents(ctx context.Context, clusterName string) ([]topology.HostComponent, error) {
+	hostComponents, err := c.queryHostComponents(ctx, clusterName, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(hostComponents) > 0 {
+		log.Printf("Generated host components: %d", len(hostComponents))
+	} else {
+		log.Println("No host components found yet")
+	}
+	return hostComponents, nil
+}
+
+func (c *Client) GetComponentsOfHost(ctx context.Context, clusterName string, hostname string) ([]topology.HostComponent, error) {
+	return c.queryHostComponents(ctx, clusterName, "&HostRoles/host_name="+hostname)
+}
+
+// queryHostComponents reads the components of every host in one paged
+// request from the cluster's host_components endpoint, narrowed by the
+// optional predicate.
+func (c *Client) queryHostComponents(ctx context.Context, clusterName string, predicate string) ([]topology.HostComponent, error) {
+	var hostComponents = make([]topology.HostComponent, 0)
+	path := "/clusters/" + clusterName + "/host_components?fields=" + c.getDialect().HostComponentFields +
+		predicate + "&sortBy=HostRoles/host_name.asc,HostRoles/component_name.asc"
+	err := c.getPages(ctx, "host_components", path, func(decoder *json.Decoder) (int, error) {
+		var hresp ClusterHostComponentsResponse
+		if err := decode(decoder, &hresp); err != nil {
+			return 0, err
+		}
+		for _, item := range hresp.Items {
+			hostComponents = append(hostComponents, topology.HostComponent{
+				HostComponent:     item.HostRole.ComponentName,
+				Service:           item.HostRole.GetService(),
+				Hostname:          item.HostRole.Hostname,
+				State:             item.HostRole.State,
+				Maintenance:       isMaintenanceOn(item.HostRole.Maintenance),
+				MaintenanceReason: getMaintenanceReason(item.HostRole.Maintenance),
+			})
+		}
+		return len(hresp.Items), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hostComponents, nil
+}
+
+func isMaintenanceOn(maintenance string) bool {
+	return len(getMaintenanceReason(maintenance)) > 0
+}
+
+// getMaintenanceReason tells where the maintenance mode of a component comes
+// from: set on the component itself, or implied by its host or service.
+// Ambari doesn't record who turned it on.
+func getMaintenanceReason(maintenance string) string {
+	switch maintenance {
+	case "ON":
+		return "component"
+	case "IMPLIED_FROM_HOST":
+		return "host"
+	case "IMPLIED_FROM_SERVICE":
+		return "service"
+	case "IMPLIED_FROM_SERVICE_AND_HOST":
+		return "service-and-host"
+	}
+	return ""
+}
+
+func (c *Client) GetRootHostComponents(ctx context.Context) ([]topology.HostComponent, error) {
+	var hostComponents = make([]topology.HostComponent, 0)
+	req := c.newGETRequest(ctx, "/services/?fields=components/hostComponents/RootServiceHostComponents/service_name,components/hostComponents/RootServiceHostComponents/component_state")
+	resp, err := c.do(req, "root_services")
+	if err != nil {
+		return nil, err
+	}
+	defer httpclient.CloseBody(resp)
+	var hresp RootHostComponentsResponse
+	decoder := json.NewDecoder(resp.Body)
+	if err = decode(decoder, &hresp); err != nil {
+		return nil, err
+	}
+	if len(hresp.Items) > 0 {
+		for _, item := range hresp.Items {
+			for _, component := range item.Components {
+				for _, hostComponent := range component.HostComponents {
+					hc := topology.HostComponent{
+						HostComponent: hostComponent.RootServiceHostComponents.Name,
+						Service:       hostComponent.RootServiceHostComponents.ServiceName,
+						Hostname:      hostComponent.RootServiceHostComponents.Hostname,
+						State:         hostComponent.RootServiceHostComponents.State,
+					}
+					hostComponents = append(hostComponents, hc)
+				}
+			}
+		}
+		log.Printf("Generated root host components: %d", len(hostComponents))
+	} else {
+		log.Println("No root host components found yet")
+	}
+	return hostComponents, nil
+}
@@ -0,0 +1,77 @@
+package ambari
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+)
+
+const (
+	BENCHMARK_CLUSTER    = "c1"
+	BENCHMARK_HOSTS      = 1000
+	BENCHMARK_COMPONENTS = 10
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// newPagedServer serves the host components of a large cluster from pages
+// rendered up front, so the benchmark measures the client only.
+func newPagedServer(b *testing.B, pageSize int) *httptest.Server {
+	var items = make([]interface{}, 0, BENCHMARK_HOSTS*BENCHMARK_COMPONENTS)
+	for h := 0; h < BENCHMARK_HOSTS; h++ {
+		for c := 0; c < BENCHMARK_COMPONENTS; c++ {
+			items = append(items, map[string]interface{}{"HostRoles": map[string]string{
+				"component_name":    fmt.Sprintf("COMPONENT%d", c),
+				"service_name":      fmt.Sprintf("SERVICE%d", c%3),
+				"host_name":         fmt.Sprintf("host%04d.example.com", h),
+				"state":             "STARTED",
+				"maintenance_state": "OFF",
+			}})
+		}
+	}
+	var pages = make(map[int][]byte)
+	for from := 0; from <= len(items); from += pageSize {
+		to := from + pageSize
+		if to > len(items) {
+			to = len(items)
+		}
+		page, err := json.Marshal(map[string]interface{}{"items": items[from:to]})
+		if err != nil {
+			b.Fatal(err)
+		}
+		pages[from] = page
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		from, _ := strconv.Atoi(r.URL.Query().Get("from"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(pages[from])
+	}))
+}
+
+func BenchmarkGetHostComponents(b *testing.B) {
+	server := newPagedServer(b, DEFAULT_PAGE_SIZE)
+	defer server.Close()
+	client := NewClient(http.DefaultClient, "", "admin", "admin")
+	client.BaseURL = server.URL + "/api/v1"
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		components, err := client.GetHostComponents(context.Background(), BENCHMARK_CLUSTER)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(components) != BENCHMARK_HOSTS*BENCHMARK_COMPONENTS {
+			b.Fatalf("Expected %d components, got: %d", BENCHMARK_HOSTS*BENCHMARK_COMPONENTS, len(components))
+		}
+	}
+}
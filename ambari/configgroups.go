@@ -0,0 +1,59 @@
+package ambari
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+)
+
+// The host attributes read from Ambari, usable as host tags and meta.
+const (
+	RACK_ATTRIBUTE             = "rack_info"
+	OS_TYPE_ATTRIBUTE          = "os_type"
+	OS_ARCH_ATTRIBUTE          = "os_arch"
+	PUBLIC_HOST_NAME_ATTRIBUTE = "public_host_name"
+	CONFIG_GROUP_ATTRIBUTE     = "config_group"
+)
+
+type ConfigGroupsResponse struct {
+	Items []struct {
+		ConfigGroup struct {
+			GroupName string `json:"group_name"`
+			Hosts     []struct {
+				HostName string `json:"host_name"`
+			} `json:"hosts"`
+		} `json:"ConfigGroup"`
+	} `json:"items"`
+}
+
+// GetHostConfigGroups returns the config groups of the cluster per host. The
+// names of the groups of a host are sorted and joined by commas.
+func (c *Client) GetHostConfigGroups(ctx context.Context, clusterName string) (map[string]string, error) {
+	req := c.newGETRequest(ctx, "/clusters/"+clusterName+"/config_groups?fields=ConfigGroup/group_name,ConfigGroup/hosts")
+	resp, err := c.do(req, "config_groups")
+	if err != nil {
+		return nil, err
+	}
+	defer httpclient.CloseBody(resp)
+	var cresp ConfigGroupsResponse
+	if err := decode(json.NewDecoder(resp.Body), &cresp); err != nil {
+		return nil, err
+	}
+	var groups = make(map[string][]string)
+	for _, item := range cresp.Items {
+		for _, host := range item.ConfigGroup.Hosts {
+			groups[host.HostName] = append(groups[host.HostName], item.ConfigGroup.GroupName)
+		}
+	}
+	var hostGroups = make(map[string]string, len(groups))
+	for hostname, names := range groups {
+		sort.Strings(names)
+		hostGroups[hostname] = strings.Join(names, ",")
+	}
+	log.Printf("Found config groups in %s: %d", clusterName, len(cresp.Items))
+	return hostGroups, nil
+}
@@ -0,0 +1,93 @@
+package ambari
+
+import (
+	"errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+const FILE_POLL_INTERVAL = 5 * time.Second
+
+// Credentials is the Ambari pillar written by Salt.
+type Credentials struct {
+	Config struct {
+		Address  string `yaml:"server"`
+		Username string `yaml:"username"`
+		Password string `yaml:"password"`
+	} `yaml:"ambari"`
+}
+
+func WaitFile(path string) {
+	found := false
+	for !found {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			log.Println("File not found at location: " + path)
+			time.Sleep(FILE_POLL_INTERVAL)
+		} else {
+			log.Println("Found file at location: " + path)
+			found = true
+		}
+	}
+}
+
+func ReadCredentials(path string) *Credentials {
+	var credentials *Credentials = nil
+	for credentials == nil {
+		content, _ := ioutil.ReadFile(path)
+		var temp Credentials
+		err := yaml.Unmarshal(content, &temp)
+		if err != nil {
+			log.Println("Cannot parse file: " + path)
+			os.Exit(1)
+		}
+		if len(temp.Config.Username) > 0 && len(temp.Config.Password) > 0 {
+			credentials = &temp
+			log.Println("Ambari credentials found")
+		} else {
+			log.Println("Ambari credentials are empty, waiting..")
+			time.Sleep(FILE_POLL_INTERVAL)
+		}
+	}
+	return credentials
+}
+
+// LoadCredentials reads the pillar once, unlike ReadCredentials which waits
+// until the credentials are written.
+func LoadCredentials(path string) (*Credentials, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var credentials Credentials
+	if err := yaml.Unmarshal(content, &credentials); err != nil {
+		return nil, errors.New("Cannot parse file: " + path)
+	}
+	if len(credentials.Config.Username) == 0 || len(credentials.Config.Password) == 0 {
+		return nil, errors.New("Ambari credentials are empty in file: " + path)
+	}
+	return &credentials, nil
+}
+
+func ReadServer(path string) *Credentials {
+	var credentials *Credentials = nil
+	for credentials == nil {
+		content, _ := ioutil.ReadFile(path)
+		var temp Credentials
+		err := yaml.Unmarshal(content, &temp)
+		if err != nil {
+			log.Println("Cannot parse file: " + path)
+			os.Exit(1)
+		}
+		if len(temp.Config.Address) > 0 {
+			credentials = &temp
+			log.Println("Ambari server found")
+		} else {
+			log.Println("Ambari server is empty waiting..")
+			time.Sleep(FILE_POLL_INTERVAL)
+		}
+	}
+	return credentials
+}
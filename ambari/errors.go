@@ -0,0 +1,120 @@
+package ambari
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hortonworks/cloudbreak-service-registration/metrics"
+)
+
+var (
+	ErrUnauthorized = errors.New("Unauthorized")
+	ErrNotFound     = errors.New("Not found")
+	ErrServerError  = errors.New("Server error")
+	ErrUnexpected   = errors.New("Unexpected status")
+)
+
+// StatusError is returned for the non-2xx Ambari responses instead of
+// decoding the error page. Kind is one of the Err values, so the errors can be
+// checked with errors.Is.
+type StatusError struct {
+	Kind       error
+	StatusCode int
+	Endpoint   string
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	message := e.Kind.Error() + " (" + strconv.Itoa(e.StatusCode) + ") from the Ambari " + e.Endpoint + " endpoint"
+	if len(e.Message) > 0 {
+		message += ": " + e.Message
+	}
+	return message
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Kind
+}
+
+// newStatusError reads the message of the JSON error responses of Ambari, the
+// HTML error pages are not included.
+func newStatusError(resp *http.Response, endpoint string) *StatusError {
+	var kind error
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		kind = ErrUnauthorized
+	case resp.StatusCode == http.StatusNotFound:
+		kind = ErrNotFound
+	case resp.StatusCode >= 500:
+		kind = ErrServerError
+	default:
+		kind = ErrUnexpected
+	}
+	var body struct {
+		Message string `json:"message"`
+	}
+	if strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&body)
+	}
+	return &StatusError{Kind: kind, StatusCode: resp.StatusCode, Endpoint: endpoint, Message: body.Message}
+}
+
+// getErrorStatus is the status label of the request metric.
+func getErrorStatus(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, ErrUnauthorized):
+		return "unauthorized"
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	case errors.Is(err, ErrServerError):
+		return "server_error"
+	case errors.Is(err, ErrUnexpected):
+		return "unexpected"
+	}
+	return "transport_error"
+}
+
+// getErrorCategory classifies the failed requests for the error counter, the
+// missing resources are not failures of the service registration.
+func getErrorCategory(err error) string {
+	var netErr net.Error
+	switch {
+	case err == nil || errors.Is(err, ErrNotFound) || errors.Is(err, context.Canceled):
+		return ""
+	case errors.Is(err, ErrUnauthorized):
+		return metrics.ERROR_AMBARI_AUTH
+	case errors.Is(err, ErrServerError) || errors.Is(err, ErrUnexpected):
+		return metrics.ERROR_AMBARI_SERVER
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		return metrics.ERROR_AMBARI_TIMEOUT
+	}
+	return metrics.ERROR_AMBARI_UNREACHABLE
+}
+
+// isRetryable reports whether a failed request may succeed when repeated,
+// authorization and missing resource errors won't.
+func isRetryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Kind == ErrServerError
+	}
+	return true
+}
+
+// decode counts the responses which cannot be decoded, e.g. error pages
+// served with a success status by a proxy.
+func decode(decoder *json.Decoder, v interface{}) error {
+	if err := decoder.Decode(v); err != nil {
+		metrics.CountError(metrics.ERROR_DECODE)
+		return err
+	}
+	return nil
+}
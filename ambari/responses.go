@@ -0,0 +1,65 @@
+package ambari
+
+type ClusterResponse struct {
+	Items []struct {
+		Cluster struct {
+			Name string `json:"cluster_name"`
+		} `json:"Clusters"`
+	} `json:"items"`
+}
+
+type HostsResponse struct {
+	Items []struct {
+		Host struct {
+			HostName         string                 `json:"host_name"`
+			IP               string                 `json:"ip"`
+			Rack             string                 `json:"rack_info"`
+			OSType           string                 `json:"os_type"`
+			OSArch           string                 `json:"os_arch"`
+			PublicHostName   string                 `json:"public_host_name"`
+			HostState        string                 `json:"host_state"`
+			HostStatus       string                 `json:"host_status"`
+			DesiredConfigs   map[string]interface{} `json:"desired_configs"`
+			LastRegistration int64                  `json:"last_registration_time"`
+		} `json:"Hosts"`
+	} `json:"items"`
+}
+
+type ClusterHostComponentsResponse struct {
+	Items []struct {
+		HostRole HostRole `json:"HostRoles"`
+	} `json:"items"`
+}
+
+type HostRole struct {
+	ComponentName string `json:"component_name"`
+	ServiceName   string `json:"service_name"`
+	ServiceType   string `json:"service_type"`
+	Hostname      string `json:"host_name"`
+	State         string `json:"state"`
+	Maintenance   string `json:"maintenance_state"`
+}
+
+// GetService returns the service type on the Ambari versions having service
+// instances, and the service name otherwise.
+func (h HostRole) GetService() string {
+	if len(h.ServiceType) > 0 {
+		return h.ServiceType
+	}
+	return h.ServiceName
+}
+
+type RootHostComponentsResponse struct {
+	Items []struct {
+		Components []struct {
+			HostComponents []struct {
+				RootServiceHostComponents struct {
+					Name        string `json:"component_name"`
+					ServiceName string `json:"service_name"`
+					State       string `json:"component_state"`
+					Hostname    string `json:"host_name"`
+				} `json:"RootServiceHostComponents"`
+			} `json:"hostComponents"`
+		} `json:"components"`
+	} `json:"items"`
+}
@@ -0,0 +1,242 @@
+package ambari
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+const DEFAULT_HOST_FULL_REFRESH_CYCLES = 10
+
+// Source lists the root host components and the host components of every
+// cluster managed by an Ambari server. It remembers the components per
+// cluster and host, so only the components of the hosts whose fingerprint
+// changed since the previous listing are fetched again. The fingerprint covers
+// the host state and status, the desired configs and the agent registration,
+// not the component states, so a component changing state on an unchanged
+// host is read on the next full refresh, every FullRefreshCycles listings. A
+// cluster which cannot be read is listed with its last known components. With AlertChecks the components get the checks of their alert
+// definitions, and with ConfigGroups the config groups of their hosts as host
+// attribute, both read again on every full refresh.
+type Source struct {
+	Client            *Client
+	FullRefreshCycles int
+	AlertChecks       bool
+	ConfigGroups      bool
+
+	clusters map[string]*clusterState
+	hosts    map[string]topology.Host
+	lock     sync.Mutex
+}
+
+type clusterState struct {
+	hostFingerprints           map[string]string
+	hostComponents             map[string][]topology.HostComponent
+	cyclesSinceFullHostRefresh int
+	alertChecks                map[string]topology.CheckInfo
+	configGroups               map[string]string
+}
+
+func NewSource(client *Client) *Source {
+	return &Source{Client: client, FullRefreshCycles: DEFAULT_HOST_FULL_REFRESH_CYCLES}
+}
+
+func (s *Source) ListComponents(ctx context.Context) ([]topology.HostComponent, error) {
+	var components = make([]topology.HostComponent, 0)
+	if err := s.Client.Negotiate(ctx); err != nil {
+		log.Println(err.Error() + ", using the defaults")
+	}
+
+	var wg sync.WaitGroup
+	var hosts map[string]topology.Host
+	var clusterNames []string
+	var rootComponents []topology.HostComponent
+	var hostsErr, rootErr, clusterErr error
+
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		hosts, hostsErr = s.Client.GetHosts(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		rootComponents, rootErr = s.Client.GetRootHostComponents(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		clusterNames, clusterErr = s.Client.GetClusterNames(ctx)
+	}()
+	wg.Wait()
+
+	if hostsErr != nil {
+		return nil, errors.New("Failed to get the host list from Ambari: " + hostsErr.Error())
+	}
+	if rootErr != nil {
+		return nil, errors.New("Failed to get the root host components from Ambari: " + rootErr.Error())
+	}
+	s.lock.Lock()
+	s.hosts = hosts
+	s.lock.Unlock()
+	if clusterErr != nil {
+		return nil, errors.New("Failed to get the clusters from Ambari: " + clusterErr.Error())
+	}
+	components = append(components, topology.SetHostInfo(rootComponents, hosts)...)
+
+	fingerprints := getFingerprints(hosts)
+	var clusters = make(map[string]*clusterState)
+	for _, clusterName := range clusterNames {
+		cluster := s.clusters[clusterName]
+		if cluster == nil {
+			cluster = &clusterState{}
+		}
+		hostComponents, err := s.getChangedHostComponents(ctx, clusterName, cluster, hosts, fingerprints)
+		if errors.Is(err, ErrNotFound) {
+			log.Println("Cluster " + clusterName + " was removed from Ambari")
+			continue
+		}
+		if err != nil {
+			if cluster.hostComponents == nil {
+				return nil, errors.New("Failed to get the host components of " + clusterName + " from Ambari: " + err.Error())
+			}
+			log.Println("Failed to get the host components of " + clusterName + " from Ambari, using the last known ones: " + err.Error())
+			hostComponents = cluster.getHostComponents()
+		}
+		if s.AlertChecks {
+			s.refreshAlertChecks(ctx, clusterName, cluster)
+		}
+		if s.ConfigGroups {
+			s.refreshConfigGroups(ctx, clusterName, cluster)
+		}
+		for i := range hostComponents {
+			hostComponents[i].Cluster = clusterName
+			hostComponents[i].Check = cluster.alertChecks[hostComponents[i].HostComponent]
+			if groups, ok := cluster.configGroups[hostComponents[i].Hostname]; ok {
+				hostComponents[i].HostAttributes = hostComponents[i].HostAttributes.With(CONFIG_GROUP_ATTRIBUTE, groups)
+			}
+		}
+		components = append(components, hostComponents...)
+		clusters[clusterName] = cluster
+	}
+	s.clusters = clusters
+	return components, nil
+}
+
+func (s *Source) Hosts() map[string]topology.Host {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.hosts
+}
+
+func (s *Source) refreshAlertChecks(ctx context.Context, clusterName string, cluster *clusterState) {
+	if cluster.alertChecks != nil && cluster.cyclesSinceFullHostRefresh > 0 {
+		return
+	}
+	checks, err := s.Client.GetAlertChecks(ctx, clusterName)
+	if err != nil {
+		log.Println("Failed to get the alert definitions of " + clusterName + " from Ambari: " + err.Error())
+		return
+	}
+	cluster.alertChecks = checks
+}
+
+func (s *Source) refreshConfigGroups(ctx context.Context, clusterName string, cluster *clusterState) {
+	if cluster.configGroups != nil && cluster.cyclesSinceFullHostRefresh > 0 {
+		return
+	}
+	groups, err := s.Client.GetHostConfigGroups(ctx, clusterName)
+	if err != nil {
+		log.Println("Failed to get the config groups of " + clusterName + " from Ambari: " + err.Error())
+		return
+	}
+	cluster.configGroups = groups
+}
+
+func getFingerprints(hosts map[string]topology.Host) map[string]string {
+	var fingerprints = make(map[string]string, len(hosts))
+	for hostname, host := range hosts {
+		fingerprints[hostname] = host.Fingerprint
+	}
+	return fingerprints
+}
+
+// getChangedHostComponents only fetches the components of the hosts whose
+// fingerprint changed since the previous check and reuses the cached components
+// of the others. Every few cycles all the hosts are refreshed.
+func (s *Source) getChangedHostComponents(ctx context.Context, clusterName string, cluster *clusterState,
+	hosts map[string]topology.Host, fingerprints map[string]string) ([]topology.HostComponent, error) {
+	changedHosts := cluster.getChangedHosts(fingerprints)
+	if cluster.needsFullHostRefresh(s.FullRefreshCycles) || len(changedHosts) > len(hosts)/2 {
+		hostComponents, err := s.Client.GetHostComponents(ctx, clusterName)
+		if err != nil {
+			return nil, err
+		}
+		hostComponents = topology.SetHostInfo(hostComponents, hosts)
+		cluster.setHostComponents(hostComponents, fingerprints, true)
+		return hostComponents, nil
+	}
+
+	log.Printf("Refreshing the components of %d changed hosts in %s", len(changedHosts), clusterName)
+	var hostComponents = make([]topology.HostComponent, 0)
+	for _, hostname := range changedHosts {
+		components, err := s.Client.GetComponentsOfHost(ctx, clusterName, hostname)
+		if err != nil {
+			return nil, err
+		}
+		hostComponents = append(hostComponents, topology.SetHostInfo(components, hosts)...)
+	}
+	cluster.setHostComponents(hostComponents, fingerprints, false)
+	return cluster.getHostComponents(), nil
+}
+
+func (c *clusterState) getChangedHosts(fingerprints map[string]string) []string {
+	var changedHosts = make([]string, 0)
+	for hostname, fingerprint := range fingerprints {
+		if previous, ok := c.hostFingerprints[hostname]; !ok || previous != fingerprint {
+			changedHosts = append(changedHosts, hostname)
+		}
+	}
+	return changedHosts
+}
+
+func (c *clusterState) needsFullHostRefresh(refreshCycles int) bool {
+	if refreshCycles <= 0 {
+		refreshCycles = DEFAULT_HOST_FULL_REFRESH_CYCLES
+	}
+	return c.hostComponents == nil || c.cyclesSinceFullHostRefresh+1 >= refreshCycles
+}
+
+// setHostComponents stores the components per host. On a partial refresh only
+// the hosts of the given components are replaced and the removed hosts dropped.
+func (c *clusterState) setHostComponents(components []topology.HostComponent, fingerprints map[string]string, full bool) {
+	if full || c.hostComponents == nil {
+		c.hostComponents = make(map[string][]topology.HostComponent)
+		c.cyclesSinceFullHostRefresh = 0
+	} else {
+		c.cyclesSinceFullHostRefresh++
+		for _, hostname := range c.getChangedHosts(fingerprints) {
+			delete(c.hostComponents, hostname)
+		}
+		for hostname := range c.hostComponents {
+			if _, ok := fingerprints[hostname]; !ok {
+				delete(c.hostComponents, hostname)
+			}
+		}
+	}
+	for _, component := range components {
+		c.hostComponents[component.Hostname] = append(c.hostComponents[component.Hostname], component)
+	}
+	c.hostFingerprints = fingerprints
+}
+
+// getHostComponents returns a copy of the cached components, so setting the
+// cluster on them doesn't modify the cache.
+func (c *clusterState) getHostComponents() []topology.HostComponent {
+	var components = make([]topology.HostComponent, 0)
+	for _, hostComponents := range c.hostComponents {
+		components = append(components, hostComponents...)
+	}
+	return components
+}
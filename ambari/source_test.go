@@ -0,0 +1,177 @@
+package ambari_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hortonworks/cloudbreak-service-registration/ambari"
+	"github.com/hortonworks/cloudbreak-service-registration/testutil"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+// countingTransport records the host_components requests of the client.
+type countingTransport struct {
+	lock     sync.Mutex
+	requests []string
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(req.URL.Path, "/host_components") {
+		t.lock.Lock()
+		t.requests = append(t.requests, req.URL.Query().Get("HostRoles/host_name"))
+		t.lock.Unlock()
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func (t *countingTransport) reset() []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	requests := t.requests
+	t.requests = nil
+	return requests
+}
+
+func TestListComponentsSkipsUnchangedHosts(t *testing.T) {
+	fa := testutil.NewFakeAmbari("c1")
+	defer fa.Close()
+	var components = make([]topology.HostComponent, 0)
+	for _, hostname := range []string{"h1.example.com", "h2.example.com", "h3.example.com"} {
+		fa.SetHost(hostname, topology.Host{IP: "10.0.0.1", Fingerprint: "HEALTHY"})
+		components = append(components, topology.HostComponent{Hostname: hostname, HostComponent: "DATANODE", Service: "HDFS", State: "STARTED", Cluster: "c1"})
+	}
+	fa.SetComponents(components)
+
+	transport := &countingTransport{}
+	client := ambari.NewClient(&http.Client{Transport: transport}, "", "admin", "admin")
+	client.BaseURL = fa.URL()
+	client.ServerURL = fa.ServerURL()
+	source := ambari.NewSource(client)
+	if _, err := source.ListComponents(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if requests := transport.reset(); len(requests) == 0 {
+		t.Fatal("The first listing didn't fetch the host components")
+	}
+
+	// only the heartbeats of the agents changed
+	listed, err := source.ListComponents(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests := transport.reset(); len(requests) > 0 {
+		t.Errorf("Unchanged hosts were refreshed: %v", requests)
+	}
+	if len(listed) != len(components) {
+		t.Errorf("Expected %d cached components, got: %d", len(components), len(listed))
+	}
+
+	fa.SetHost("h2.example.com", topology.Host{IP: "10.0.0.1", Fingerprint: "HEARTBEAT_LOST"})
+	if _, err := source.ListComponents(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if requests := transport.reset(); len(requests) != 1 || requests[0] != "h2.example.com" {
+		t.Errorf("Expected a refresh of h2.example.com only, got: %v", requests)
+	}
+}
+
+func TestListComponentsReadsComponentStateChangesOnTheFullRefresh(t *testing.T) {
+	fa := testutil.NewFakeAmbari("c1")
+	defer fa.Close()
+	fa.SetHost("h1.example.com", topology.Host{IP: "10.0.0.1", Fingerprint: "HEALTHY"})
+	fa.SetHost("h2.example.com", topology.Host{IP: "10.0.0.2", Fingerprint: "HEALTHY"})
+	datanode := func(hostname string, state string) topology.HostComponent {
+		return topology.HostComponent{Hostname: hostname, HostComponent: "DATANODE", Service: "HDFS", State: state, Cluster: "c1"}
+	}
+	fa.SetComponents([]topology.HostComponent{datanode("h1.example.com", "STARTED"), datanode("h2.example.com", "STARTED")})
+
+	transport := &countingTransport{}
+	client := ambari.NewClient(&http.Client{Transport: transport}, "", "admin", "admin")
+	client.BaseURL = fa.URL()
+	client.ServerURL = fa.ServerURL()
+	source := ambari.NewSource(client)
+	source.FullRefreshCycles = 2
+	if _, err := source.ListComponents(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	transport.reset()
+	getState := func(listed []topology.HostComponent) string {
+		for _, c := range listed {
+			if c.Hostname == "h1.example.com" {
+				return c.State
+			}
+		}
+		return ""
+	}
+
+	// only the state of a component changed, the host itself is unchanged
+	fa.SetComponents([]topology.HostComponent{datanode("h1.example.com", "INSTALLED"), datanode("h2.example.com", "STARTED")})
+	listed, err := source.ListComponents(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests := transport.reset(); len(requests) > 0 {
+		t.Errorf("Unchanged hosts were refreshed: %v", requests)
+	}
+	if state := getState(listed); state != "STARTED" {
+		t.Errorf("Expected the cached state STARTED before the full refresh, got: %s", state)
+	}
+
+	listed, err = source.ListComponents(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests := transport.reset(); len(requests) != 1 || requests[0] != "" {
+		t.Errorf("Expected a single query of every host, got: %v", requests)
+	}
+	if state := getState(listed); state != "INSTALLED" {
+		t.Errorf("Expected the new state INSTALLED after the full refresh, got: %s", state)
+	}
+}
+
+func TestNegotiateFallsBackToTheV1API(t *testing.T) {
+	fa := testutil.NewFakeAmbari("c1")
+	defer fa.Close()
+	fa.SetHost("h1.example.com", topology.Host{IP: "10.0.0.1"})
+	fa.SetComponents([]topology.HostComponent{{Hostname: "h1.example.com", HostComponent: "DATANODE", Service: "HDFS", State: "STARTED", Cluster: "c1"}})
+
+	client := ambari.NewClient(http.DefaultClient, "", "admin", "admin")
+	client.ServerURL = fa.ServerURL()
+	if err := client.Negotiate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if client.BaseURL != fa.ServerURL()+"/api/v1" {
+		t.Errorf("Expected the v1 API to be used, got: %s", client.BaseURL)
+	}
+	listed, err := ambari.NewSource(client).ListComponents(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].Service != "HDFS" {
+		t.Errorf("Expected the DATANODE of HDFS, got: %v", listed)
+	}
+}
+
+func TestNegotiateUsesTheServiceTypeOfAmbari3(t *testing.T) {
+	fa := testutil.NewFakeAmbari("c1")
+	defer fa.Close()
+	fa.SetVersion("3.0.0.0", "/api/v2")
+	fa.SetHost("h1.example.com", topology.Host{IP: "10.0.0.1"})
+	fa.SetComponents([]topology.HostComponent{{Hostname: "h1.example.com", HostComponent: "DATANODE", Service: "HDFS", State: "STARTED", Cluster: "c1"}})
+
+	client := ambari.NewClient(http.DefaultClient, "", "admin", "admin")
+	client.ServerURL = fa.ServerURL()
+	listed, err := ambari.NewSource(client).ListComponents(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.BaseURL != fa.ServerURL()+"/api/v2" {
+		t.Errorf("Expected the v2 API to be used, got: %s", client.BaseURL)
+	}
+	if len(listed) != 1 || listed[0].Service != "HDFS" {
+		t.Errorf("Expected the service type HDFS instead of the service instance, got: %v", listed)
+	}
+}
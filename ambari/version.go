@@ -0,0 +1,109 @@
+package ambari
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+)
+
+// API_ROOTS are probed in order, the first one answering the version request
+// is used.
+var API_ROOTS = []string{"/api/v2", "/api/v1"}
+
+// dialect holds the request fields which differ between the Ambari versions.
+// From 3.0 the service_name of a host component is the name of the service
+// instance, the service itself is the service_type.
+type dialect struct {
+	Version             string
+	HostComponentFields string
+	HostFields          string
+}
+
+var defaultDialect = dialect{
+	HostComponentFields: "HostRoles/component_name,HostRoles/service_name,HostRoles/host_name,HostRoles/state,HostRoles/maintenance_state",
+	HostFields: "Hosts/ip,Hosts/rack_info,Hosts/os_type,Hosts/os_arch,Hosts/public_host_name,Hosts/host_state,Hosts/host_status," +
+		"Hosts/desired_configs,Hosts/last_registration_time",
+}
+
+type versionResponse struct {
+	Component struct {
+		Version string `json:"component_version"`
+	} `json:"RootServiceComponents"`
+}
+
+func getDialect(version string) dialect {
+	d := defaultDialect
+	d.Version = version
+	if major, _ := parseVersion(version); major >= 3 {
+		d.HostComponentFields += ",HostRoles/service_type"
+	}
+	return d
+}
+
+// parseVersion returns the major and minor version of a version like 2.7.3.0.
+func parseVersion(version string) (int, int) {
+	parts := strings.Split(version, ".")
+	major, _ := strconv.Atoi(parts[0])
+	minor := 0
+	if len(parts) > 1 {
+		minor, _ = strconv.Atoi(parts[1])
+	}
+	return major, minor
+}
+
+// Negotiate detects the API root and the version of the Ambari server at
+// ServerURL and adapts the requests to them. The defaults are kept until the
+// negotiation succeeds, and nothing is probed without a ServerURL.
+func (c *Client) Negotiate(ctx context.Context) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.negotiated || len(c.ServerURL) == 0 {
+		return nil
+	}
+	var lastErr error
+	for _, root := range API_ROOTS {
+		version, err := c.getVersion(ctx, c.ServerURL+root)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		c.BaseURL = c.ServerURL + root
+		c.dialect = getDialect(version)
+		c.negotiated = true
+		log.Printf("Using the Ambari API at %s, server version: %s", c.BaseURL, version)
+		return nil
+	}
+	return errors.New("Failed to detect the Ambari API version: " + lastErr.Error())
+}
+
+func (c *Client) getVersion(ctx context.Context, baseURL string) (string, error) {
+	req, _ := http.NewRequest("GET", baseURL+"/services/AMBARI/components/"+SERVER_COMPONENT+"?fields=RootServiceComponents/component_version", nil)
+	req = req.WithContext(ctx)
+	req.Header.Add("X-Requested-By", "ambari")
+	req.SetBasicAuth(c.Username, c.Password)
+	resp, err := c.do(req, "version")
+	if err != nil {
+		return "", err
+	}
+	defer httpclient.CloseBody(resp)
+	var vresp versionResponse
+	if err := decode(json.NewDecoder(resp.Body), &vresp); err != nil {
+		return "", err
+	}
+	return vresp.Component.Version, nil
+}
+
+func (c *Client) getDialect() dialect {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.negotiated {
+		return defaultDialect
+	}
+	return c.dialect
+}
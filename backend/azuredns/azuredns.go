@@ -0,0 +1,166 @@
+// Package azuredns publishes the services as A and SRV records of an Azure
+// Private DNS zone, authenticated by the managed identity of the VM.
+package azuredns
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hortonworks/cloudbreak-service-registration/backend"
+)
+
+const (
+	DEFAULT_TTL          = 60
+	DEFAULT_OWNER_ID     = "service-registration"
+	DEFAULT_WORKERS      = 4
+	HERITAGE_METADATA    = "heritage"
+	OWNER_METADATA       = "owner"
+	HERITAGE             = "service-registration"
+	ZONE_APEX_RECORD_SET = "@"
+)
+
+// Backend manages the records of the services in a private zone. The managed
+// record sets are marked by their metadata, only those are changed or
+// deleted, and a record set created by others is left alone.
+type Backend struct {
+	Client  *Client
+	TTL     int64
+	OwnerID string
+}
+
+func New(client *Client) *Backend {
+	return &Backend{Client: client, TTL: DEFAULT_TTL, OwnerID: DEFAULT_OWNER_ID}
+}
+
+func (b *Backend) Name() string {
+	return "azure-dns"
+}
+
+type change struct {
+	recordType string
+	name       string
+	recordSet  *RecordSet
+}
+
+func (b *Backend) Publish(ctx context.Context, instances []backend.Instance) error {
+	existing, err := b.Client.ListRecordSets(ctx)
+	if err != nil {
+		return err
+	}
+	changes := b.getChanges(backend.GetDNSRecords(instances, b.Client.Zone, b.TTL), existing)
+	if len(changes) == 0 {
+		log.Println("Azure DNS records are up to date")
+		return nil
+	}
+	log.Printf("Changing Azure DNS record sets: %d", len(changes))
+
+	var wg sync.WaitGroup
+	var errorChannel = make(chan error, len(changes))
+	var workers = make(chan struct{}, DEFAULT_WORKERS)
+	for _, c := range changes {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(c change) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			var err error
+			if c.recordSet != nil {
+				err = b.Client.PutRecordSet(ctx, c.recordType, c.name, *c.recordSet)
+			} else {
+				err = b.Client.DeleteRecordSet(ctx, c.recordType, c.name)
+			}
+			if err != nil {
+				errorChannel <- errors.New("Failed to change the " + c.recordType + " record set " + c.name + ": " + err.Error())
+			}
+		}(c)
+	}
+	wg.Wait()
+	close(errorChannel)
+	for e := range errorChannel {
+		return e
+	}
+	return nil
+}
+
+func (b *Backend) isOwned(recordSet RecordSet) bool {
+	return recordSet.Properties.Metadata[HERITAGE_METADATA] == HERITAGE && recordSet.Properties.Metadata[OWNER_METADATA] == b.OwnerID
+}
+
+func (b *Backend) getChanges(records []backend.Record, existing []RecordSet) []change {
+	var current = make(map[string]RecordSet)
+	for _, recordSet := range existing {
+		current[strings.ToLower(recordSet.Name)+"|"+recordSet.GetType()] = recordSet
+	}
+	var desired = make(map[string]bool)
+	var changes = make([]change, 0)
+	for _, record := range records {
+		name := b.getRelativeName(record.Name)
+		key := name + "|" + record.Type
+		desired[key] = true
+		recordSet := b.newRecordSet(record)
+		if previous, ok := current[key]; ok {
+			if !b.isOwned(previous) {
+				log.Printf("Azure DNS record set %s %s is not owned by %s, skipping it", record.Type, name, b.OwnerID)
+				continue
+			}
+			if (backend.Record{TTL: previous.Properties.TTL, Values: getValues(previous)}).Equals(record) {
+				continue
+			}
+		}
+		changes = append(changes, change{recordType: record.Type, name: name, recordSet: &recordSet})
+	}
+	for key, recordSet := range current {
+		if !desired[key] && b.isOwned(recordSet) {
+			changes = append(changes, change{recordType: recordSet.GetType(), name: recordSet.Name})
+		}
+	}
+	return changes
+}
+
+// getRelativeName returns the record set name relative to the zone.
+func (b *Backend) getRelativeName(name string) string {
+	zone := backend.Fqdn(b.Client.Zone)
+	if name == zone {
+		return ZONE_APEX_RECORD_SET
+	}
+	return strings.TrimSuffix(name, "."+zone)
+}
+
+func (b *Backend) newRecordSet(record backend.Record) RecordSet {
+	properties := RecordProperties{
+		TTL:      b.TTL,
+		Metadata: map[string]string{HERITAGE_METADATA: HERITAGE, OWNER_METADATA: b.OwnerID},
+	}
+	for _, value := range record.Values {
+		switch record.Type {
+		case "A":
+			properties.ARecords = append(properties.ARecords, ARecord{IPv4Address: value})
+		case "SRV":
+			fields := strings.Fields(value)
+			if len(fields) != 4 {
+				continue
+			}
+			priority, _ := strconv.ParseInt(fields[0], 10, 64)
+			weight, _ := strconv.ParseInt(fields[1], 10, 64)
+			port, _ := strconv.ParseInt(fields[2], 10, 64)
+			properties.SRVRecords = append(properties.SRVRecords, SRVRecord{Priority: priority, Weight: weight, Port: port, Target: fields[3]})
+		}
+	}
+	return RecordSet{Properties: properties}
+}
+
+func getValues(recordSet RecordSet) []string {
+	var values = make([]string, 0)
+	for _, record := range recordSet.Properties.ARecords {
+		values = append(values, record.IPv4Address)
+	}
+	for _, record := range recordSet.Properties.SRVRecords {
+		values = append(values, strconv.FormatInt(record.Priority, 10)+" "+strconv.FormatInt(record.Weight, 10)+" "+
+			strconv.FormatInt(record.Port, 10)+" "+backend.Fqdn(record.Target))
+	}
+	return values
+}
@@ -0,0 +1,145 @@
+package azuredns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+)
+
+const (
+	DEFAULT_BASE_URL = "https://management.azure.com"
+	API_VERSION      = "2018-09-01"
+	RECORD_TYPE_PATH = "Microsoft.Network/privateDnsZones/"
+)
+
+type RecordSet struct {
+	Name       string           `json:"name,omitempty"`
+	Type       string           `json:"type,omitempty"`
+	Properties RecordProperties `json:"properties"`
+}
+
+// GetType returns the record type, e.g. A of Microsoft.Network/privateDnsZones/A.
+func (r RecordSet) GetType() string {
+	return strings.TrimPrefix(r.Type, RECORD_TYPE_PATH)
+}
+
+type RecordProperties struct {
+	TTL        int64             `json:"ttl"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	ARecords   []ARecord         `json:"aRecords,omitempty"`
+	SRVRecords []SRVRecord       `json:"srvRecords,omitempty"`
+	TXTRecords []TXTRecord       `json:"txtRecords,omitempty"`
+}
+
+type ARecord struct {
+	IPv4Address string `json:"ipv4Address"`
+}
+
+type SRVRecord struct {
+	Priority int64  `json:"priority"`
+	Weight   int64  `json:"weight"`
+	Port     int64  `json:"port"`
+	Target   string `json:"target"`
+}
+
+type TXTRecord struct {
+	Value []string `json:"value"`
+}
+
+// Client calls the Azure Resource Manager API of a private DNS zone.
+type Client struct {
+	HTTP           *http.Client
+	BaseURL        string
+	SubscriptionID string
+	ResourceGroup  string
+	Zone           string
+	Tokens         *TokenProvider
+}
+
+func NewClient(httpClient *http.Client, subscriptionID string, resourceGroup string, zone string, tokens *TokenProvider) *Client {
+	return &Client{
+		HTTP:           httpClient,
+		BaseURL:        DEFAULT_BASE_URL,
+		SubscriptionID: subscriptionID,
+		ResourceGroup:  resourceGroup,
+		Zone:           zone,
+		Tokens:         tokens,
+	}
+}
+
+// ListRecordSets returns every record set of the zone.
+func (c *Client) ListRecordSets(ctx context.Context) ([]RecordSet, error) {
+	var recordSets = make([]RecordSet, 0)
+	url := c.getZoneURL() + "/ALL?api-version=" + API_VERSION
+	for len(url) > 0 {
+		body, err := c.do(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Value    []RecordSet `json:"value"`
+			NextLink string      `json:"nextLink"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+		recordSets = append(recordSets, page.Value...)
+		url = page.NextLink
+	}
+	return recordSets, nil
+}
+
+func (c *Client) PutRecordSet(ctx context.Context, recordType string, name string, recordSet RecordSet) error {
+	body, _ := json.Marshal(RecordSet{Properties: recordSet.Properties})
+	_, err := c.do(ctx, "PUT", c.getRecordURL(recordType, name), body)
+	return err
+}
+
+func (c *Client) DeleteRecordSet(ctx context.Context, recordType string, name string) error {
+	_, err := c.do(ctx, "DELETE", c.getRecordURL(recordType, name), nil)
+	return err
+}
+
+func (c *Client) getZoneURL() string {
+	return c.BaseURL + "/subscriptions/" + c.SubscriptionID + "/resourceGroups/" + c.ResourceGroup +
+		"/providers/Microsoft.Network/privateDnsZones/" + c.Zone
+}
+
+func (c *Client) getRecordURL(recordType string, name string) string {
+	return c.getZoneURL() + "/" + recordType + "/" + name + "?api-version=" + API_VERSION
+}
+
+func (c *Client) do(ctx context.Context, method string, url string, body []byte) ([]byte, error) {
+	token, err := c.Tokens.Get()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpclient.CloseBody(resp)
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 && !(method == "DELETE" && resp.StatusCode == http.StatusNotFound) {
+		return nil, errors.New("Azure DNS responded with status " + resp.Status + ": " + string(respBody))
+	}
+	return respBody, nil
+}
@@ -0,0 +1,71 @@
+package azuredns
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+)
+
+const (
+	IDENTITY_URL       = "http://169.254.169.254/metadata/identity/oauth2/token"
+	IDENTITY_API       = "2018-02-01"
+	MANAGEMENT_URL     = "https://management.azure.com/"
+	TOKEN_EXPIRY_DELTA = 5 * time.Minute
+)
+
+// TokenProvider gets the access tokens of the managed identity of the VM from
+// the instance metadata service. ClientID selects a user assigned identity,
+// otherwise the system assigned identity is used. The token is cached until
+// shortly before it expires.
+type TokenProvider struct {
+	HTTP        *http.Client
+	IdentityURL string
+	ClientID    string
+
+	lock    sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func NewTokenProvider(httpClient *http.Client, clientID string) *TokenProvider {
+	return &TokenProvider{HTTP: httpClient, IdentityURL: IDENTITY_URL, ClientID: clientID}
+}
+
+func (p *TokenProvider) Get() (string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.token) > 0 && time.Now().Add(TOKEN_EXPIRY_DELTA).Before(p.expires) {
+		return p.token, nil
+	}
+	query := url.Values{"api-version": {IDENTITY_API}, "resource": {MANAGEMENT_URL}}
+	if len(p.ClientID) > 0 {
+		query.Set("client_id", p.ClientID)
+	}
+	req, _ := http.NewRequest("GET", p.IdentityURL+"?"+query.Encode(), nil)
+	req.Header.Set("Metadata", "true")
+	resp, err := p.HTTP.Do(req)
+	if err != nil {
+		return "", errors.New("Cannot get the managed identity token: " + err.Error())
+	}
+	defer httpclient.CloseBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New("Cannot get the managed identity token, status: " + resp.Status)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	expiresOn, _ := strconv.ParseInt(token.ExpiresOn, 10, 64)
+	p.token = token.AccessToken
+	p.expires = time.Unix(expiresOn, 0)
+	return p.token, nil
+}
@@ -0,0 +1,86 @@
+// Package backend publishes the registered services to other registries than
+// Consul, e.g. DNS zones. A backend gets the complete set of the desired
+// instances after the changes of a cycle and makes its registry match it.
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Instance is a registered instance of a service. Healthy is set for the
+// started components which are not in maintenance.
+type Instance struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	Address string            `json:"address"`
+	Port    int64             `json:"port"`
+	Tags    []string          `json:"tags,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+	Cluster string            `json:"cluster,omitempty"`
+	State   string            `json:"state"`
+	Healthy bool              `json:"healthy"`
+}
+
+type Backend interface {
+	Name() string
+	Publish(ctx context.Context, instances []Instance) error
+}
+
+// PublishAll publishes the instances to every backend concurrently. The
+// failed backends are logged and returned in the error.
+func PublishAll(ctx context.Context, backends []Backend, instances []Instance) error {
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var failed = make([]string, 0)
+	for _, b := range backends {
+		wg.Add(1)
+		go func(b Backend) {
+			defer wg.Done()
+			if err := b.Publish(ctx, instances); err != nil {
+				log.Println("Failed to publish the services to " + b.Name() + ": " + err.Error())
+				lock.Lock()
+				failed = append(failed, b.Name())
+				lock.Unlock()
+			}
+		}(b)
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return errors.New("Failed to publish the services to: " + strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// CloseAll closes the backends holding a session, e.g. so the registrations
+// bound to it are removed when the service registration stops.
+func CloseAll(backends []Backend) {
+	for _, b := range backends {
+		if closer, ok := b.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Println("Failed to close the " + b.Name() + " backend: " + err.Error())
+			}
+		}
+	}
+}
+
+// GroupByName returns the healthy instances per service name, sorted by ID
+// so the generated records are stable.
+func GroupByName(instances []Instance) map[string][]Instance {
+	var groups = make(map[string][]Instance)
+	for _, instance := range instances {
+		if instance.Healthy && len(instance.Address) > 0 {
+			groups[instance.Name] = append(groups[instance.Name], instance)
+		}
+	}
+	for _, group := range groups {
+		sort.Slice(group, func(i, j int) bool { return group[i].ID < group[j].ID })
+	}
+	return groups
+}
@@ -0,0 +1,124 @@
+package clouddns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+)
+
+const DEFAULT_BASE_URL = "https://dns.googleapis.com/dns/v1"
+
+type ResourceRecordSet struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	TTL     int64    `json:"ttl"`
+	RRDatas []string `json:"rrdatas"`
+}
+
+// Change is applied atomically, the deletions must match the existing
+// record sets exactly.
+type Change struct {
+	Additions []ResourceRecordSet `json:"additions,omitempty"`
+	Deletions []ResourceRecordSet `json:"deletions,omitempty"`
+}
+
+// Client calls the Cloud DNS API of a managed zone.
+type Client struct {
+	HTTP    *http.Client
+	BaseURL string
+	Project string
+	Zone    string
+	Tokens  *TokenProvider
+}
+
+func NewClient(httpClient *http.Client, project string, zone string, tokens *TokenProvider) *Client {
+	return &Client{HTTP: httpClient, BaseURL: DEFAULT_BASE_URL, Project: project, Zone: zone, Tokens: tokens}
+}
+
+// GetDNSName returns the domain of the managed zone.
+func (c *Client) GetDNSName(ctx context.Context) (string, error) {
+	body, err := c.do(ctx, "GET", c.getZoneURL(), nil)
+	if err != nil {
+		return "", err
+	}
+	var zone struct {
+		DNSName string `json:"dnsName"`
+	}
+	if err := json.Unmarshal(body, &zone); err != nil {
+		return "", err
+	}
+	return zone.DNSName, nil
+}
+
+// ListRecordSets returns every record set of the managed zone.
+func (c *Client) ListRecordSets(ctx context.Context) ([]ResourceRecordSet, error) {
+	var recordSets = make([]ResourceRecordSet, 0)
+	pageToken := ""
+	for {
+		path := c.getZoneURL() + "/rrsets"
+		if len(pageToken) > 0 {
+			path += "?pageToken=" + url.QueryEscape(pageToken)
+		}
+		body, err := c.do(ctx, "GET", path, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			RRSets        []ResourceRecordSet `json:"rrsets"`
+			NextPageToken string              `json:"nextPageToken"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+		recordSets = append(recordSets, page.RRSets...)
+		if len(page.NextPageToken) == 0 {
+			return recordSets, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+func (c *Client) CreateChange(ctx context.Context, change Change) error {
+	body, _ := json.Marshal(change)
+	_, err := c.do(ctx, "POST", c.getZoneURL()+"/changes", body)
+	return err
+}
+
+func (c *Client) getZoneURL() string {
+	return c.BaseURL + "/projects/" + c.Project + "/managedZones/" + c.Zone
+}
+
+func (c *Client) do(ctx context.Context, method string, url string, body []byte) ([]byte, error) {
+	token, err := c.Tokens.Get()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpclient.CloseBody(resp)
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, errors.New("Cloud DNS responded with status " + resp.Status + ": " + string(respBody))
+	}
+	return respBody, nil
+}
@@ -0,0 +1,90 @@
+// Package clouddns publishes the services as A and SRV records of a Google
+// Cloud DNS private managed zone.
+package clouddns
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/hortonworks/cloudbreak-service-registration/backend"
+)
+
+const (
+	DEFAULT_TTL           = 60
+	DEFAULT_OWNER_ID      = "service-registration"
+	MAX_CHANGES_PER_BATCH = 500
+	OWNERSHIP_PREFIX      = "heritage=service-registration,owner="
+)
+
+// Backend manages the records of the services in a managed zone. Every name
+// it manages has a TXT record holding the owner ID, as described at
+// backend.DiffOwnedRecords. The Domain is read from the zone when it is not
+// set.
+type Backend struct {
+	Client  *Client
+	Domain  string
+	TTL     int64
+	OwnerID string
+}
+
+func New(client *Client) *Backend {
+	return &Backend{Client: client, TTL: DEFAULT_TTL, OwnerID: DEFAULT_OWNER_ID}
+}
+
+func (b *Backend) Name() string {
+	return "cloud-dns"
+}
+
+func (b *Backend) Publish(ctx context.Context, instances []backend.Instance) error {
+	if len(b.Domain) == 0 {
+		domain, err := b.Client.GetDNSName(ctx)
+		if err != nil {
+			return err
+		}
+		b.Domain = domain
+	}
+	existing, err := b.Client.ListRecordSets(ctx)
+	if err != nil {
+		return err
+	}
+	var current = make([]backend.Record, 0, len(existing))
+	for _, recordSet := range existing {
+		current = append(current, backend.Record{Name: strings.ToLower(recordSet.Name), Type: recordSet.Type, TTL: recordSet.TTL, Values: recordSet.RRDatas})
+	}
+	changes := backend.DiffOwnedRecords(backend.GetDNSRecords(instances, b.Domain, b.TTL), current, nil, `"`+OWNERSHIP_PREFIX+b.OwnerID+`"`)
+	if len(changes) == 0 {
+		log.Println("Cloud DNS records are up to date")
+		return nil
+	}
+	log.Printf("Changing Cloud DNS record sets: %d", len(changes))
+	for from := 0; from < len(changes); from += MAX_CHANGES_PER_BATCH {
+		to := from + MAX_CHANGES_PER_BATCH
+		if to > len(changes) {
+			to = len(changes)
+		}
+		if err := b.Client.CreateChange(ctx, newChange(changes[from:to])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newChange(changes []backend.RecordChange) Change {
+	var change Change
+	for _, c := range changes {
+		if c.Delete {
+			change.Deletions = append(change.Deletions, newRecordSet(c.Record))
+			continue
+		}
+		if c.Previous != nil {
+			change.Deletions = append(change.Deletions, newRecordSet(*c.Previous))
+		}
+		change.Additions = append(change.Additions, newRecordSet(c.Record))
+	}
+	return change
+}
+
+func newRecordSet(record backend.Record) ResourceRecordSet {
+	return ResourceRecordSet{Name: record.Name, Type: record.Type, TTL: record.TTL, RRDatas: record.Values}
+}
@@ -0,0 +1,139 @@
+package clouddns
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+)
+
+const (
+	METADATA_TOKEN_URL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	DEFAULT_TOKEN_URL  = "https://oauth2.googleapis.com/token"
+	SCOPE              = "https://www.googleapis.com/auth/ndev.clouddns.readwrite"
+	JWT_GRANT_TYPE     = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	TOKEN_LIFETIME     = time.Hour
+	TOKEN_EXPIRY_DELTA = 5 * time.Minute
+)
+
+// serviceAccountKey is the JSON key file of a service account.
+type serviceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// TokenProvider gets the access tokens of a service account, with its JSON
+// key file when KeyFile is set, otherwise from the metadata server as the
+// service account of the instance. The token is cached until shortly before
+// it expires.
+type TokenProvider struct {
+	HTTP             *http.Client
+	KeyFile          string
+	MetadataTokenURL string
+
+	lock    sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func NewTokenProvider(httpClient *http.Client, keyFile string) *TokenProvider {
+	return &TokenProvider{HTTP: httpClient, KeyFile: keyFile, MetadataTokenURL: METADATA_TOKEN_URL}
+}
+
+func (p *TokenProvider) Get() (string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.token) > 0 && time.Now().Add(TOKEN_EXPIRY_DELTA).Before(p.expires) {
+		return p.token, nil
+	}
+	var req *http.Request
+	if len(p.KeyFile) > 0 {
+		var err error
+		if req, err = p.newKeyFileRequest(); err != nil {
+			return "", errors.New("Cannot use the service account key: " + err.Error())
+		}
+	} else {
+		req, _ = http.NewRequest("GET", p.MetadataTokenURL, nil)
+		req.Header.Set("Metadata-Flavor", "Google")
+	}
+	resp, err := p.HTTP.Do(req)
+	if err != nil {
+		return "", errors.New("Cannot get the service account token: " + err.Error())
+	}
+	defer httpclient.CloseBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New("Cannot get the service account token, status: " + resp.Status)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	p.token = token.AccessToken
+	p.expires = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return p.token, nil
+}
+
+// newKeyFileRequest creates the token request of a JWT assertion signed by
+// the private key of the service account.
+func (p *TokenProvider) newKeyFileRequest() (*http.Request, error) {
+	content, err := ioutil.ReadFile(p.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	var key serviceAccountKey
+	if err := json.Unmarshal(content, &key); err != nil {
+		return nil, err
+	}
+	if len(key.TokenURI) == 0 {
+		key.TokenURI = DEFAULT_TOKEN_URL
+	}
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return nil, errors.New("No private key found in " + p.KeyFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("The private key is not an RSA key")
+	}
+
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   key.ClientEmail,
+		"scope": SCOPE,
+		"aud":   key.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(TOKEN_LIFETIME).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{"grant_type": {JWT_GRANT_TYPE}, "assertion": {unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)}}
+	req, _ := http.NewRequest("POST", key.TokenURI, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
@@ -0,0 +1,124 @@
+package backend
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Record is a DNS record set. The names are fully qualified with a trailing
+// dot and the values are sorted.
+type Record struct {
+	Name   string
+	Type   string
+	TTL    int64
+	Values []string
+}
+
+func (r Record) Key() string {
+	return r.Name + "|" + r.Type
+}
+
+// Equals compares the TTL and the values regardless of their order.
+func (r Record) Equals(other Record) bool {
+	if r.TTL != other.TTL || len(r.Values) != len(other.Values) {
+		return false
+	}
+	var values = make(map[string]int)
+	for _, value := range r.Values {
+		values[value]++
+	}
+	for _, value := range other.Values {
+		values[value]--
+	}
+	for _, count := range values {
+		if count != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// GetDNSRecords returns the records of the healthy instances in the domain:
+// an A record per instance named by its ID, an A record per service with the
+// addresses of its instances, and an SRV record per service pointing to the
+// instance records.
+func GetDNSRecords(instances []Instance, domain string, ttl int64) []Record {
+	domain = Fqdn(domain)
+	var records = make([]Record, 0)
+	groups := GroupByName(instances)
+	var names = make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var addresses = make([]string, 0)
+		var seen = make(map[string]bool)
+		var targets = make([]string, 0)
+		for _, instance := range groups[name] {
+			target := DNSLabel(instance.ID) + "." + domain
+			records = append(records, Record{Name: target, Type: "A", TTL: ttl, Values: []string{instance.Address}})
+			if !seen[instance.Address] {
+				addresses = append(addresses, instance.Address)
+				seen[instance.Address] = true
+			}
+			targets = append(targets, "1 1 "+strconv.FormatInt(instance.Port, 10)+" "+target)
+		}
+		sort.Strings(addresses)
+		sort.Strings(targets)
+		records = append(records,
+			Record{Name: DNSLabel(name) + "." + domain, Type: "A", TTL: ttl, Values: addresses},
+			Record{Name: "_" + DNSLabel(name) + "._tcp." + domain, Type: "SRV", TTL: ttl, Values: targets})
+	}
+	return mergeRecords(records)
+}
+
+// mergeRecords merges the records of the same name and type, e.g. when an
+// instance ID equals its service name.
+func mergeRecords(records []Record) []Record {
+	var merged = make([]Record, 0, len(records))
+	var index = make(map[string]int)
+	for _, record := range records {
+		i, ok := index[record.Key()]
+		if !ok {
+			index[record.Key()] = len(merged)
+			merged = append(merged, record)
+			continue
+		}
+		for _, value := range record.Values {
+			if !contains(merged[i].Values, value) {
+				merged[i].Values = append(merged[i].Values, value)
+			}
+		}
+		sort.Strings(merged[i].Values)
+	}
+	return merged
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// DNSLabel lower cases the name and replaces the characters not allowed in a
+// host name with dashes. Dots are kept, so a name may span several labels.
+func DNSLabel(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '-'
+	}, strings.Trim(name, "."))
+}
+
+func Fqdn(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".") + "."
+}
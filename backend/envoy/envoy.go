@@ -0,0 +1,191 @@
+// Package envoy serves the services as the endpoints of Envoy clusters over
+// the REST transport of the endpoint discovery service (EDS), so Envoy can
+// route to the components directly.
+package envoy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/backend"
+)
+
+const (
+	DISCOVERY_PATH   = "/v3/discovery:endpoints"
+	METADATA_NS      = "envoy.lb"
+	HEALTHY          = "HEALTHY"
+	UNHEALTHY        = "UNHEALTHY"
+	SHUTDOWN_TIMEOUT = 5 * time.Second
+)
+
+// Backend serves a cluster per service name with the instances of every
+// Ambari cluster, and one per <Ambari cluster>/<service name>. The instances
+// which are not healthy are served as UNHEALTHY endpoints, so Envoy does not
+// route to them. The discovery requests are answered with 503 until the
+// first publishing, so Envoy keeps its previous endpoints after a restart.
+type Backend struct {
+	Server *http.Server
+
+	lock        sync.RWMutex
+	published   bool
+	version     string
+	assignments map[string]ClusterLoadAssignment
+	nonce       uint64
+}
+
+func New(address string) *Backend {
+	b := &Backend{assignments: make(map[string]ClusterLoadAssignment)}
+	mux := http.NewServeMux()
+	mux.HandleFunc(DISCOVERY_PATH, b.serveDiscovery)
+	b.Server = &http.Server{Addr: address, Handler: mux, ReadTimeout: 10 * time.Second, WriteTimeout: 10 * time.Second}
+	return b
+}
+
+func (b *Backend) Name() string {
+	return "envoy"
+}
+
+// Serve serves the discovery requests in the background.
+func (b *Backend) Serve() {
+	go func() {
+		log.Println("Envoy EDS listening on: " + b.Server.Addr)
+		if err := b.Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Println("Envoy EDS stopped: " + err.Error())
+		}
+	}()
+}
+
+func (b *Backend) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	return b.Server.Shutdown(ctx)
+}
+
+func (b *Backend) Publish(ctx context.Context, instances []backend.Instance) error {
+	assignments := getAssignments(instances)
+	content, _ := json.Marshal(assignments)
+	hash := sha256.Sum256(content)
+	version := hex.EncodeToString(hash[:8])
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.published = true
+	if version == b.version {
+		return nil
+	}
+	log.Printf("Serving Envoy clusters: %d, version: %s", len(assignments), version)
+	b.version = version
+	b.assignments = assignments
+	return nil
+}
+
+func (b *Backend) serveDiscovery(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var request DiscoveryRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid discovery request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(request.TypeURL) > 0 && request.TypeURL != ENDPOINT_TYPE_URL {
+		http.Error(w, "Unsupported resource type: "+request.TypeURL, http.StatusBadRequest)
+		return
+	}
+
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	if !b.published {
+		http.Error(w, "Endpoints are not discovered yet", http.StatusServiceUnavailable)
+		return
+	}
+	if request.VersionInfo == b.version {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	response := DiscoveryResponse{
+		VersionInfo: b.version,
+		Resources:   make([]ClusterLoadAssignment, 0),
+		TypeURL:     ENDPOINT_TYPE_URL,
+		Nonce:       strconv.FormatUint(atomic.AddUint64(&b.nonce, 1), 10),
+	}
+	if len(request.ResourceNames) == 0 {
+		for _, assignment := range b.assignments {
+			response.Resources = append(response.Resources, assignment)
+		}
+		sort.Slice(response.Resources, func(i, j int) bool {
+			return response.Resources[i].ClusterName < response.Resources[j].ClusterName
+		})
+	} else {
+		for _, name := range request.ResourceNames {
+			assignment, ok := b.assignments[name]
+			if !ok {
+				assignment = newAssignment(name)
+			}
+			response.Resources = append(response.Resources, assignment)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func getAssignments(instances []backend.Instance) map[string]ClusterLoadAssignment {
+	var sorted = make([]backend.Instance, 0, len(instances))
+	for _, instance := range instances {
+		if len(instance.Address) > 0 && instance.Port > 0 {
+			sorted = append(sorted, instance)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	var assignments = make(map[string]ClusterLoadAssignment)
+	add := func(name string, endpoint LbEndpoint) {
+		assignment, ok := assignments[name]
+		if !ok {
+			assignment = newAssignment(name)
+		}
+		assignment.Endpoints[0].LbEndpoints = append(assignment.Endpoints[0].LbEndpoints, endpoint)
+		assignments[name] = assignment
+	}
+	for _, instance := range sorted {
+		endpoint := newEndpoint(instance)
+		add(instance.Name, endpoint)
+		if len(instance.Cluster) > 0 {
+			add(instance.Cluster+"/"+instance.Name, endpoint)
+		}
+	}
+	return assignments
+}
+
+func newAssignment(name string) ClusterLoadAssignment {
+	return ClusterLoadAssignment{
+		Type:        ENDPOINT_TYPE_URL,
+		ClusterName: name,
+		Endpoints:   []LocalityEndpoints{{LbEndpoints: make([]LbEndpoint, 0)}},
+	}
+}
+
+func newEndpoint(instance backend.Instance) LbEndpoint {
+	endpoint := LbEndpoint{
+		Endpoint:     Endpoint{Address: Address{SocketAddress: SocketAddress{Address: instance.Address, PortValue: instance.Port}}},
+		HealthStatus: UNHEALTHY,
+	}
+	if instance.Healthy {
+		endpoint.HealthStatus = HEALTHY
+	}
+	if len(instance.Meta) > 0 {
+		endpoint.Metadata = &EndpointMetadata{FilterMetadata: map[string]map[string]string{METADATA_NS: instance.Meta}}
+	}
+	return endpoint
+}
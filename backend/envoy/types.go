@@ -0,0 +1,55 @@
+package envoy
+
+// The JSON mapping of the v3 xDS messages served over REST, only with the
+// fields used here.
+
+const ENDPOINT_TYPE_URL = "type.googleapis.com/envoy.config.endpoint.v3.ClusterLoadAssignment"
+
+type DiscoveryRequest struct {
+	VersionInfo   string   `json:"version_info"`
+	ResourceNames []string `json:"resource_names"`
+	TypeURL       string   `json:"type_url"`
+	ResponseNonce string   `json:"response_nonce"`
+}
+
+type DiscoveryResponse struct {
+	VersionInfo string                  `json:"version_info"`
+	Resources   []ClusterLoadAssignment `json:"resources"`
+	TypeURL     string                  `json:"type_url"`
+	Nonce       string                  `json:"nonce"`
+}
+
+type ClusterLoadAssignment struct {
+	Type        string              `json:"@type"`
+	ClusterName string              `json:"cluster_name"`
+	Endpoints   []LocalityEndpoints `json:"endpoints"`
+}
+
+type LocalityEndpoints struct {
+	LbEndpoints []LbEndpoint `json:"lb_endpoints"`
+}
+
+type LbEndpoint struct {
+	Endpoint     Endpoint          `json:"endpoint"`
+	HealthStatus string            `json:"health_status"`
+	Metadata     *EndpointMetadata `json:"metadata,omitempty"`
+}
+
+type Endpoint struct {
+	Address Address `json:"address"`
+}
+
+type Address struct {
+	SocketAddress SocketAddress `json:"socket_address"`
+}
+
+type SocketAddress struct {
+	Address   string `json:"address"`
+	PortValue int64  `json:"port_value"`
+}
+
+// EndpointMetadata holds the service meta under the filter_metadata of the
+// envoy.lb namespace, so it can be used by the subset load balancer.
+type EndpointMetadata struct {
+	FilterMetadata map[string]map[string]string `json:"filter_metadata"`
+}
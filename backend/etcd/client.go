@@ -0,0 +1,267 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+)
+
+const (
+	API_V2 = "v2"
+	API_V3 = "v3"
+)
+
+// Store is the key-value API of etcd used by the backend.
+type Store interface {
+	// List returns the values of the keys under the prefix.
+	List(ctx context.Context, prefix string) (map[string]string, error)
+	Put(ctx context.Context, key string, value string) error
+	Delete(ctx context.Context, key string) error
+}
+
+// Client sends the requests to the endpoints in turn until one of them
+// responds.
+type Client struct {
+	HTTP      *http.Client
+	Endpoints []string
+	Username  string
+	Password  string
+}
+
+func (c *Client) do(ctx context.Context, newRequest func(endpoint string) *http.Request) ([]byte, int, error) {
+	var lastErr error = errors.New("No etcd endpoints are configured")
+	for _, endpoint := range c.Endpoints {
+		req := newRequest(strings.TrimSuffix(endpoint, "/")).WithContext(ctx)
+		resp, err := c.HTTP.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		httpclient.CloseBody(resp)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return body, resp.StatusCode, nil
+	}
+	return nil, 0, lastErr
+}
+
+// V2Store uses the keys API of etcd v2, with basic auth when the username is
+// set.
+type V2Store struct {
+	Client
+}
+
+func (s *V2Store) List(ctx context.Context, prefix string) (map[string]string, error) {
+	body, status, err := s.do(ctx, func(endpoint string) *http.Request {
+		return s.newRequest("GET", endpoint+"/v2/keys"+prefix+"?recursive=true", nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var values = make(map[string]string)
+	if status == http.StatusNotFound {
+		return values, nil
+	}
+	if status != http.StatusOK {
+		return nil, newError(status, body)
+	}
+	var resp struct {
+		Node v2Node `json:"node"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	resp.Node.collect(values)
+	return values, nil
+}
+
+func (s *V2Store) Put(ctx context.Context, key string, value string) error {
+	body, status, err := s.do(ctx, func(endpoint string) *http.Request {
+		req := s.newRequest("PUT", endpoint+"/v2/keys"+key, strings.NewReader(url.Values{"value": {value}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req
+	})
+	if err == nil && status >= 300 {
+		err = newError(status, body)
+	}
+	return err
+}
+
+func (s *V2Store) Delete(ctx context.Context, key string) error {
+	body, status, err := s.do(ctx, func(endpoint string) *http.Request {
+		return s.newRequest("DELETE", endpoint+"/v2/keys"+key, nil)
+	})
+	if err == nil && status >= 300 && status != http.StatusNotFound {
+		err = newError(status, body)
+	}
+	return err
+}
+
+func (s *V2Store) newRequest(method string, url string, body *strings.Reader) *http.Request {
+	var req *http.Request
+	if body == nil {
+		req, _ = http.NewRequest(method, url, nil)
+	} else {
+		req, _ = http.NewRequest(method, url, body)
+	}
+	if len(s.Username) > 0 {
+		req.SetBasicAuth(s.Username, s.Password)
+	}
+	return req
+}
+
+type v2Node struct {
+	Key   string   `json:"key"`
+	Value string   `json:"value"`
+	Dir   bool     `json:"dir"`
+	Nodes []v2Node `json:"nodes"`
+}
+
+func (n v2Node) collect(values map[string]string) {
+	if !n.Dir {
+		values[n.Key] = n.Value
+	}
+	for _, child := range n.Nodes {
+		child.collect(values)
+	}
+}
+
+// V3Store uses the JSON gateway of the etcd v3 API. When the username is set
+// a token is requested and requested again when it expires.
+type V3Store struct {
+	Client
+
+	lock  sync.Mutex
+	token string
+}
+
+type v3KeyValue struct {
+	Key      string `json:"key"`
+	Value    string `json:"value,omitempty"`
+	RangeEnd string `json:"range_end,omitempty"`
+}
+
+func (s *V3Store) List(ctx context.Context, prefix string) (map[string]string, error) {
+	body, err := s.call(ctx, "/v3/kv/range", v3KeyValue{Key: encode(prefix), RangeEnd: encode(getPrefixEnd(prefix))})
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Kvs []v3KeyValue `json:"kvs"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	var values = make(map[string]string)
+	for _, kv := range resp.Kvs {
+		key, _ := base64.StdEncoding.DecodeString(kv.Key)
+		value, _ := base64.StdEncoding.DecodeString(kv.Value)
+		values[string(key)] = string(value)
+	}
+	return values, nil
+}
+
+func (s *V3Store) Put(ctx context.Context, key string, value string) error {
+	_, err := s.call(ctx, "/v3/kv/put", v3KeyValue{Key: encode(key), Value: encode(value)})
+	return err
+}
+
+func (s *V3Store) Delete(ctx context.Context, key string) error {
+	_, err := s.call(ctx, "/v3/kv/deleterange", v3KeyValue{Key: encode(key)})
+	return err
+}
+
+func (s *V3Store) call(ctx context.Context, path string, request interface{}) ([]byte, error) {
+	content, _ := json.Marshal(request)
+	for attempt := 0; ; attempt++ {
+		token, err := s.getToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		body, status, err := s.do(ctx, func(endpoint string) *http.Request {
+			req, _ := http.NewRequest("POST", endpoint+path, bytes.NewReader(content))
+			req.Header.Set("Content-Type", "application/json")
+			if len(token) > 0 {
+				req.Header.Set("Authorization", token)
+			}
+			return req
+		})
+		if err != nil {
+			return nil, err
+		}
+		if status == http.StatusUnauthorized && len(token) > 0 && attempt == 0 {
+			s.lock.Lock()
+			s.token = ""
+			s.lock.Unlock()
+			continue
+		}
+		if status != http.StatusOK {
+			return nil, newError(status, body)
+		}
+		return body, nil
+	}
+}
+
+func (s *V3Store) getToken(ctx context.Context) (string, error) {
+	if len(s.Username) == 0 {
+		return "", nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.token) > 0 {
+		return s.token, nil
+	}
+	content, _ := json.Marshal(map[string]string{"name": s.Username, "password": s.Password})
+	body, status, err := s.do(ctx, func(endpoint string) *http.Request {
+		req, _ := http.NewRequest("POST", endpoint+"/v3/auth/authenticate", bytes.NewReader(content))
+		req.Header.Set("Content-Type", "application/json")
+		return req
+	})
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", newError(status, body)
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", err
+	}
+	s.token = resp.Token
+	return s.token, nil
+}
+
+func encode(value string) string {
+	return base64.StdEncoding.EncodeToString([]byte(value))
+}
+
+// getPrefixEnd returns the range end of the keys with the prefix, the prefix
+// with its last byte incremented.
+func getPrefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return "\x00"
+}
+
+func newError(status int, body []byte) error {
+	return errors.New("etcd responded with status " + http.StatusText(status) + ": " + strings.TrimSpace(string(body)))
+}
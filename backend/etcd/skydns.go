@@ -0,0 +1,120 @@
+// Package etcd publishes the services to etcd in the key layout of SkyDNS, as
+// resolved by SkyDNS and by the etcd plugin of CoreDNS.
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/hortonworks/cloudbreak-service-registration/backend"
+)
+
+const (
+	DEFAULT_PREFIX   = "/skydns"
+	DEFAULT_TTL      = 60
+	DEFAULT_OWNER_ID = "service-registration"
+	OWNERSHIP_PREFIX = "heritage=service-registration,owner="
+)
+
+// Record is the JSON value of a SkyDNS key. The text holds the ownership
+// value, it is only returned for TXT queries.
+type Record struct {
+	Host string `json:"host"`
+	Port int64  `json:"port,omitempty"`
+	TTL  int64  `json:"ttl,omitempty"`
+	Text string `json:"text,omitempty"`
+}
+
+// Backend writes a key per healthy instance at
+// <Prefix>/<reversed domain labels>/<service>/<instance ID>, so
+// <service>.<domain> resolves to the A and SRV records of the instances and
+// <instance ID>.<service>.<domain> to the instance. Only the keys with the
+// ownership text of the OwnerID are updated and deleted.
+type Backend struct {
+	Store   Store
+	Prefix  string
+	Domain  string
+	TTL     int64
+	OwnerID string
+}
+
+func New(store Store, domain string) *Backend {
+	return &Backend{Store: store, Prefix: DEFAULT_PREFIX, Domain: domain, TTL: DEFAULT_TTL, OwnerID: DEFAULT_OWNER_ID}
+}
+
+func (b *Backend) Name() string {
+	return "etcd"
+}
+
+func (b *Backend) Publish(ctx context.Context, instances []backend.Instance) error {
+	root := b.getDomainKey()
+	existing, err := b.Store.List(ctx, root+"/")
+	if err != nil {
+		return err
+	}
+	ownership := OWNERSHIP_PREFIX + b.OwnerID
+	desired := b.getRecords(instances, root, ownership)
+
+	created, updated, deleted := 0, 0, 0
+	for key, value := range desired {
+		current, ok := existing[key]
+		if ok && current == value {
+			continue
+		}
+		if ok && !isOwned(current, ownership) {
+			log.Println("Skipping the etcd key not owned by the service registration: " + key)
+			continue
+		}
+		if err := b.Store.Put(ctx, key, value); err != nil {
+			return err
+		}
+		if ok {
+			updated++
+		} else {
+			created++
+		}
+	}
+	for key, value := range existing {
+		if _, ok := desired[key]; ok || !isOwned(value, ownership) {
+			continue
+		}
+		if err := b.Store.Delete(ctx, key); err != nil {
+			return err
+		}
+		deleted++
+	}
+	if created+updated+deleted > 0 {
+		log.Printf("SkyDNS records in etcd created: %d, updated: %d, deleted: %d", created, updated, deleted)
+	} else {
+		log.Println("SkyDNS records in etcd are up to date")
+	}
+	return nil
+}
+
+func (b *Backend) getRecords(instances []backend.Instance, root string, ownership string) map[string]string {
+	var records = make(map[string]string)
+	for name, group := range backend.GroupByName(instances) {
+		for _, instance := range group {
+			value, _ := json.Marshal(Record{Host: instance.Address, Port: instance.Port, TTL: b.TTL, Text: ownership})
+			records[root+"/"+backend.DNSLabel(name)+"/"+backend.DNSLabel(instance.ID)] = string(value)
+		}
+	}
+	return records
+}
+
+// getDomainKey returns the key of the domain, e.g. /skydns/internal/example
+// for example.internal.
+func (b *Backend) getDomainKey() string {
+	labels := strings.Split(strings.TrimSuffix(backend.Fqdn(b.Domain), "."), ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return "/" + strings.Trim(b.Prefix, "/") + "/" + strings.Join(labels, "/")
+}
+
+func isOwned(value string, ownership string) bool {
+	var record Record
+	return json.Unmarshal([]byte(value), &record) == nil && record.Text == ownership
+}
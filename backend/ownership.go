@@ -0,0 +1,79 @@
+package backend
+
+import (
+	"log"
+	"sort"
+)
+
+// RecordChange creates, replaces or deletes a record. Previous is the
+// existing record replaced by the change.
+type RecordChange struct {
+	Record   Record
+	Previous *Record
+	Delete   bool
+}
+
+// DiffOwnedRecords compares the desired records with the existing records of
+// a zone where the owned names are marked by a TXT record with the ownership
+// value. Only the records of the owned names are replaced or deleted, and the
+// desired records of the names taken by other records or reserved are
+// skipped. The ownership records are created first and deleted last, so a
+// partially applied change never leaves records without an owner.
+func DiffOwnedRecords(desired []Record, existing []Record, reserved []string, ownership string) []RecordChange {
+	var current = make(map[string]Record)
+	var owned = make(map[string]bool)
+	var taken = make(map[string]bool)
+	for _, name := range reserved {
+		taken[name] = true
+	}
+	for _, record := range existing {
+		current[record.Key()] = record
+		if record.Type == "TXT" && contains(record.Values, ownership) {
+			owned[record.Name] = true
+		} else {
+			taken[record.Name] = true
+		}
+	}
+
+	var wanted = make(map[string]bool)
+	var ownershipCreates, updates, deletes, ownershipDeletes []RecordChange
+	for _, record := range desired {
+		if taken[record.Name] && !owned[record.Name] {
+			log.Printf("DNS record %s is not owned by the service registration, skipping it", record.Name)
+			continue
+		}
+		wanted[record.Key()] = true
+		ownershipKey := record.Name + "|TXT"
+		if !owned[record.Name] && !wanted[ownershipKey] {
+			ownershipCreates = append(ownershipCreates, RecordChange{Record: Record{Name: record.Name, Type: "TXT", TTL: record.TTL, Values: []string{ownership}}})
+		}
+		wanted[ownershipKey] = true
+		previous, ok := current[record.Key()]
+		if !ok {
+			updates = append(updates, RecordChange{Record: record})
+		} else if !previous.Equals(record) {
+			updates = append(updates, RecordChange{Record: record, Previous: &previous})
+		}
+	}
+	for key, record := range current {
+		if !owned[record.Name] || wanted[key] {
+			continue
+		}
+		if record.Type == "TXT" {
+			ownershipDeletes = append(ownershipDeletes, RecordChange{Record: record, Delete: true})
+		} else {
+			deletes = append(deletes, RecordChange{Record: record, Delete: true})
+		}
+	}
+	sortChanges(deletes)
+	sortChanges(ownershipDeletes)
+	changes := append(ownershipCreates, updates...)
+	changes = append(changes, deletes...)
+	return append(changes, ownershipDeletes...)
+}
+
+func sortChanges(changes []RecordChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Record.Key() < changes[j].Record.Key()
+	})
+}
@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"reflect"
+	"testing"
+)
+
+const TEST_OWNERSHIP = `"owner=test"`
+
+func aRecord(name string, values ...string) Record {
+	return Record{Name: name, Type: "A", TTL: 60, Values: values}
+}
+
+func ownershipRecord(name string) Record {
+	return Record{Name: name, Type: "TXT", TTL: 60, Values: []string{TEST_OWNERSHIP}}
+}
+
+// describe lists the changes as action and key, in order.
+func describe(changes []RecordChange) []string {
+	var descriptions = make([]string, 0, len(changes))
+	for _, change := range changes {
+		action := "upsert"
+		if change.Delete {
+			action = "delete"
+		}
+		descriptions = append(descriptions, action+" "+change.Record.Key())
+	}
+	return descriptions
+}
+
+func TestDiffOwnedRecords(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		desired  []Record
+		existing []Record
+		reserved []string
+		expected []string
+	}{
+		{
+			name:     "new name is created after its ownership record",
+			desired:  []Record{aRecord("a.example.com.", "10.0.0.1")},
+			expected: []string{"upsert a.example.com.|TXT", "upsert a.example.com.|A"},
+		},
+		{
+			name:     "foreign name is skipped",
+			desired:  []Record{aRecord("a.example.com.", "10.0.0.1")},
+			existing: []Record{aRecord("a.example.com.", "10.0.0.9")},
+			expected: []string{},
+		},
+		{
+			name:     "reserved name is skipped",
+			desired:  []Record{aRecord("a.example.com.", "10.0.0.1")},
+			reserved: []string{"a.example.com."},
+			expected: []string{},
+		},
+		{
+			name:     "owned name is updated",
+			desired:  []Record{aRecord("a.example.com.", "10.0.0.1")},
+			existing: []Record{ownershipRecord("a.example.com."), aRecord("a.example.com.", "10.0.0.9")},
+			expected: []string{"upsert a.example.com.|A"},
+		},
+		{
+			name:     "unchanged owned name is left as it is",
+			desired:  []Record{aRecord("a.example.com.", "10.0.0.1")},
+			existing: []Record{ownershipRecord("a.example.com."), aRecord("a.example.com.", "10.0.0.1")},
+			expected: []string{},
+		},
+		{
+			name:     "removed owned name is deleted before its ownership record",
+			existing: []Record{ownershipRecord("a.example.com."), aRecord("a.example.com.", "10.0.0.1")},
+			expected: []string{"delete a.example.com.|A", "delete a.example.com.|TXT"},
+		},
+		{
+			name:    "ownership records are created first and deleted last",
+			desired: []Record{aRecord("b.example.com.", "10.0.0.2")},
+			existing: []Record{ownershipRecord("a.example.com."), aRecord("a.example.com.", "10.0.0.1"),
+				aRecord("c.example.com.", "10.0.0.3")},
+			expected: []string{"upsert b.example.com.|TXT", "upsert b.example.com.|A",
+				"delete a.example.com.|A", "delete a.example.com.|TXT"},
+		},
+		{
+			name:     "foreign records are never deleted",
+			existing: []Record{aRecord("c.example.com.", "10.0.0.3"), {Name: "d.example.com.", Type: "TXT", TTL: 60, Values: []string{`"other"`}}},
+			reserved: []string{"e.example.com."},
+			expected: []string{},
+		},
+	} {
+		changes := describe(DiffOwnedRecords(test.desired, test.existing, test.reserved, TEST_OWNERSHIP))
+		if !reflect.DeepEqual(changes, test.expected) {
+			t.Errorf("%s: expected %v, got: %v", test.name, test.expected, changes)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package route53
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+)
+
+const (
+	DEFAULT_BASE_URL     = "https://route53.amazonaws.com/2013-04-01"
+	API_NAMESPACE        = "https://route53.amazonaws.com/doc/2013-04-01/"
+	LIST_PAGE_SIZE       = 300
+	MAX_REQUEST_ATTEMPTS = 5
+	RETRY_DELAY          = time.Second
+)
+
+// RecordSet is a simple routing record set, the record sets with a set
+// identifier or an alias target are not managed.
+type RecordSet struct {
+	Name            string           `xml:"Name"`
+	Type            string           `xml:"Type"`
+	SetIdentifier   string           `xml:"SetIdentifier,omitempty"`
+	TTL             int64            `xml:"TTL,omitempty"`
+	ResourceRecords []ResourceRecord `xml:"ResourceRecords>ResourceRecord"`
+	AliasTarget     *struct{}        `xml:"AliasTarget,omitempty"`
+}
+
+type ResourceRecord struct {
+	Value string `xml:"Value"`
+}
+
+type Change struct {
+	Action    string    `xml:"Action"`
+	RecordSet RecordSet `xml:"ResourceRecordSet"`
+}
+
+type changeRequest struct {
+	XMLName xml.Name `xml:"ChangeResourceRecordSetsRequest"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Comment string   `xml:"ChangeBatch>Comment"`
+	Changes []Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+type listResponse struct {
+	RecordSets           []RecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+	IsTruncated          bool        `xml:"IsTruncated"`
+	NextRecordName       string      `xml:"NextRecordName"`
+	NextRecordType       string      `xml:"NextRecordType"`
+	NextRecordIdentifier string      `xml:"NextRecordIdentifier"`
+}
+
+type errorResponse struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+// Client calls the Route 53 API of a hosted zone with signed requests.
+type Client struct {
+	HTTP         *http.Client
+	BaseURL      string
+	HostedZoneID string
+	Credentials  *CredentialsProvider
+}
+
+func NewClient(httpClient *http.Client, hostedZoneID string) *Client {
+	return &Client{
+		HTTP:         httpClient,
+		BaseURL:      DEFAULT_BASE_URL,
+		HostedZoneID: hostedZoneID,
+		Credentials:  NewCredentialsProvider(httpClient),
+	}
+}
+
+// ListRecordSets returns every record set of the hosted zone.
+func (c *Client) ListRecordSets(ctx context.Context) ([]RecordSet, error) {
+	var recordSets = make([]RecordSet, 0)
+	query := url.Values{"maxitems": {strconv.Itoa(LIST_PAGE_SIZE)}}
+	for {
+		body, err := c.do(ctx, "GET", "/hostedzone/"+c.HostedZoneID+"/rrset?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		var page listResponse
+		if err := xml.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+		recordSets = append(recordSets, page.RecordSets...)
+		if !page.IsTruncated {
+			return recordSets, nil
+		}
+		query.Set("name", page.NextRecordName)
+		query.Set("type", page.NextRecordType)
+		if len(page.NextRecordIdentifier) > 0 {
+			query.Set("identifier", page.NextRecordIdentifier)
+		} else {
+			query.Del("identifier")
+		}
+	}
+}
+
+// ChangeRecordSets applies the changes in one atomic batch.
+func (c *Client) ChangeRecordSets(ctx context.Context, comment string, changes []Change) error {
+	body, err := xml.Marshal(changeRequest{Xmlns: API_NAMESPACE, Comment: comment, Changes: changes})
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, "POST", "/hostedzone/"+c.HostedZoneID+"/rrset/", append([]byte(xml.Header), body...))
+	return err
+}
+
+// do sends the signed request, the throttled requests are retried.
+func (c *Client) do(ctx context.Context, method string, path string, body []byte) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		respBody, retry, err := c.doOnce(ctx, method, path, body)
+		if err == nil || !retry || attempt >= MAX_REQUEST_ATTEMPTS {
+			return respBody, err
+		}
+		log.Println("Route 53 request throttled, retrying: " + err.Error())
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(RETRY_DELAY * time.Duration(attempt)):
+		}
+	}
+}
+
+func (c *Client) doOnce(ctx context.Context, method string, path string, body []byte) ([]byte, bool, error) {
+	credentials, err := c.Credentials.Get()
+	if err != nil {
+		return nil, false, err
+	}
+	req, err := http.NewRequest(method, c.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "text/xml")
+	}
+	Sign(req, body, credentials, SIGNING_REGION, SIGNING_SERVICE, time.Now())
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer httpclient.CloseBody(resp)
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiError errorResponse
+		xml.Unmarshal(respBody, &apiError)
+		retry := resp.StatusCode >= 500 || apiError.Code == "Throttling" || apiError.Code == "PriorRequestNotComplete"
+		return nil, retry, errors.New("Route 53 responded with status " + resp.Status + ": " + apiError.Code + " " + apiError.Message)
+	}
+	return respBody, false, nil
+}
@@ -0,0 +1,120 @@
+package route53
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+)
+
+const (
+	METADATA_URL             = "http://169.254.169.254/latest"
+	METADATA_TOKEN_TTL       = "21600"
+	CREDENTIALS_EXPIRY_DELTA = 5 * time.Minute
+)
+
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+// CredentialsProvider returns the credentials of the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables, or else
+// the credentials of the instance profile read from the instance metadata
+// service, which are cached until shortly before they expire.
+type CredentialsProvider struct {
+	HTTP        *http.Client
+	MetadataURL string
+
+	lock   sync.Mutex
+	cached Credentials
+}
+
+func NewCredentialsProvider(httpClient *http.Client) *CredentialsProvider {
+	return &CredentialsProvider{HTTP: httpClient, MetadataURL: METADATA_URL}
+}
+
+func (p *CredentialsProvider) Get() (Credentials, error) {
+	if accessKeyID := os.Getenv("AWS_ACCESS_KEY_ID"); len(accessKeyID) > 0 {
+		return Credentials{
+			AccessKeyID:     accessKeyID,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.cached.AccessKeyID) > 0 && time.Now().Add(CREDENTIALS_EXPIRY_DELTA).Before(p.cached.Expiration) {
+		return p.cached, nil
+	}
+	credentials, err := p.getInstanceCredentials()
+	if err != nil {
+		return Credentials{}, errors.New("Cannot get the instance profile credentials: " + err.Error())
+	}
+	p.cached = credentials
+	return credentials, nil
+}
+
+func (p *CredentialsProvider) getInstanceCredentials() (Credentials, error) {
+	req, _ := http.NewRequest("PUT", p.MetadataURL+"/api/token", nil)
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", METADATA_TOKEN_TTL)
+	token, err := p.get(req)
+	if err != nil {
+		return Credentials{}, err
+	}
+	req, _ = http.NewRequest("GET", p.MetadataURL+"/meta-data/iam/security-credentials/", nil)
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	roles, err := p.get(req)
+	if err != nil {
+		return Credentials{}, err
+	}
+	role := strings.TrimSpace(strings.Split(roles, "\n")[0])
+	if len(role) == 0 {
+		return Credentials{}, errors.New("No instance profile role found")
+	}
+	req, _ = http.NewRequest("GET", p.MetadataURL+"/meta-data/iam/security-credentials/"+role, nil)
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	body, err := p.get(req)
+	if err != nil {
+		return Credentials{}, err
+	}
+	var response struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal([]byte(body), &response); err != nil {
+		return Credentials{}, err
+	}
+	return Credentials{
+		AccessKeyID:     response.AccessKeyID,
+		SecretAccessKey: response.SecretAccessKey,
+		SessionToken:    response.Token,
+		Expiration:      response.Expiration,
+	}, nil
+}
+
+func (p *CredentialsProvider) get(req *http.Request) (string, error) {
+	resp, err := p.HTTP.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer httpclient.CloseBody(resp)
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New("Instance metadata responded with status " + resp.Status)
+	}
+	return string(body), nil
+}
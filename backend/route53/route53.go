@@ -0,0 +1,99 @@
+// Package route53 publishes the services as A and SRV records of a private
+// Route 53 hosted zone.
+package route53
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/hortonworks/cloudbreak-service-registration/backend"
+)
+
+const (
+	DEFAULT_TTL           = 60
+	DEFAULT_OWNER_ID      = "service-registration"
+	MAX_CHANGES_PER_BATCH = 500
+	OWNERSHIP_PREFIX      = "heritage=service-registration,owner="
+)
+
+// Backend manages the records of the services in a hosted zone. Every name
+// it manages has a TXT record holding the owner ID, as described at
+// backend.DiffOwnedRecords.
+type Backend struct {
+	Client  *Client
+	Domain  string
+	TTL     int64
+	OwnerID string
+}
+
+func New(client *Client, domain string) *Backend {
+	return &Backend{Client: client, Domain: domain, TTL: DEFAULT_TTL, OwnerID: DEFAULT_OWNER_ID}
+}
+
+func (b *Backend) Name() string {
+	return "route53"
+}
+
+func (b *Backend) Publish(ctx context.Context, instances []backend.Instance) error {
+	existing, err := b.Client.ListRecordSets(ctx)
+	if err != nil {
+		return err
+	}
+	changes := b.getChanges(backend.GetDNSRecords(instances, b.Domain, b.TTL), existing)
+	if len(changes) == 0 {
+		log.Println("Route 53 records are up to date")
+		return nil
+	}
+	log.Printf("Changing Route 53 record sets: %d", len(changes))
+	for from := 0; from < len(changes); from += MAX_CHANGES_PER_BATCH {
+		to := from + MAX_CHANGES_PER_BATCH
+		if to > len(changes) {
+			to = len(changes)
+		}
+		if err := b.Client.ChangeRecordSets(ctx, "Updated by "+b.OwnerID, changes[from:to]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Backend) getOwnershipValue() string {
+	return `"` + OWNERSHIP_PREFIX + b.OwnerID + `"`
+}
+
+// getChanges compares the desired records with the existing record sets. The
+// record sets with a set identifier or an alias target are never changed.
+func (b *Backend) getChanges(records []backend.Record, existing []RecordSet) []Change {
+	var current = make([]backend.Record, 0, len(existing))
+	var reserved = make([]string, 0)
+	for _, recordSet := range existing {
+		name := strings.ToLower(recordSet.Name)
+		if len(recordSet.SetIdentifier) > 0 || recordSet.AliasTarget != nil {
+			reserved = append(reserved, name)
+			continue
+		}
+		var values = make([]string, 0, len(recordSet.ResourceRecords))
+		for _, record := range recordSet.ResourceRecords {
+			values = append(values, record.Value)
+		}
+		current = append(current, backend.Record{Name: name, Type: recordSet.Type, TTL: recordSet.TTL, Values: values})
+	}
+	var changes = make([]Change, 0)
+	for _, change := range backend.DiffOwnedRecords(records, current, reserved, b.getOwnershipValue()) {
+		action := "UPSERT"
+		if change.Delete {
+			action = "DELETE"
+		}
+		changes = append(changes, Change{Action: action, RecordSet: newRecordSet(change.Record)})
+	}
+	return changes
+}
+
+func newRecordSet(record backend.Record) RecordSet {
+	var records = make([]ResourceRecord, 0, len(record.Values))
+	for _, value := range record.Values {
+		records = append(records, ResourceRecord{Value: value})
+	}
+	return RecordSet{Name: record.Name, Type: record.Type, TTL: record.TTL, ResourceRecords: records}
+}
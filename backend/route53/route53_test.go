@@ -0,0 +1,22 @@
+package route53
+
+import (
+	"testing"
+
+	"github.com/hortonworks/cloudbreak-service-registration/backend"
+)
+
+func TestGetChangesSkipsAliasAndWeightedNames(t *testing.T) {
+	b := &Backend{TTL: DEFAULT_TTL, OwnerID: DEFAULT_OWNER_ID}
+	existing := []RecordSet{
+		{Name: "alias.example.com.", Type: "A", AliasTarget: &struct{}{}},
+		{Name: "weighted.example.com.", Type: "A", SetIdentifier: "blue", TTL: 60, ResourceRecords: []ResourceRecord{{Value: "10.0.0.9"}}},
+	}
+	records := []backend.Record{
+		{Name: "alias.example.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.1"}},
+		{Name: "weighted.example.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.2"}},
+	}
+	if changes := b.getChanges(records, existing); len(changes) > 0 {
+		t.Errorf("Expected no changes of the alias and weighted names, got: %v", changes)
+	}
+}
@@ -0,0 +1,33 @@
+package route53
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// The requests and signatures of the get-vanilla and
+// get-vanilla-query-order-key-case cases of the AWS Signature Version 4 test
+// suite.
+func TestSignMatchesTheAWSTestSuite(t *testing.T) {
+	credentials := Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	for _, test := range []struct {
+		url       string
+		signature string
+	}{
+		{"https://example.amazonaws.com/", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"https://example.amazonaws.com/?Param2=value2&Param1=value1", "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+	} {
+		req, _ := http.NewRequest("GET", test.url, nil)
+		Sign(req, nil, credentials, "us-east-1", "service", now)
+		expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+			"SignedHeaders=host;x-amz-date, Signature=" + test.signature
+		if authorization := req.Header.Get("Authorization"); authorization != expected {
+			t.Errorf("Unexpected signature of %s\nexpected: %s\ngot:      %s", test.url, expected, authorization)
+		}
+		if date := req.Header.Get("X-Amz-Date"); date != "20150830T123600Z" {
+			t.Errorf("Unexpected X-Amz-Date: %s", date)
+		}
+	}
+}
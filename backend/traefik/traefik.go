@@ -0,0 +1,133 @@
+// Package traefik writes the services to a dynamic configuration file of the
+// Traefik file provider, so the HTTP components are reverse proxied by the
+// Traefik instances watching the file.
+package traefik
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/hortonworks/cloudbreak-service-registration/backend"
+	"github.com/hortonworks/cloudbreak-service-registration/config"
+	"gopkg.in/yaml.v2"
+)
+
+const DEFAULT_SCHEME = "http"
+
+type DynamicConfig struct {
+	HTTP HTTPConfig `yaml:"http"`
+}
+
+type HTTPConfig struct {
+	Routers     map[string]Router     `yaml:"routers,omitempty"`
+	Middlewares map[string]Middleware `yaml:"middlewares,omitempty"`
+	Services    map[string]Service    `yaml:"services,omitempty"`
+}
+
+type Router struct {
+	Rule        string   `yaml:"rule"`
+	Service     string   `yaml:"service"`
+	EntryPoints []string `yaml:"entryPoints,omitempty"`
+	Middlewares []string `yaml:"middlewares,omitempty"`
+}
+
+type Middleware struct {
+	StripPrefix *StripPrefix `yaml:"stripPrefix,omitempty"`
+}
+
+type StripPrefix struct {
+	Prefixes []string `yaml:"prefixes"`
+}
+
+type Service struct {
+	LoadBalancer LoadBalancer `yaml:"loadBalancer"`
+}
+
+type LoadBalancer struct {
+	Servers []Server `yaml:"servers"`
+}
+
+type Server struct {
+	URL string `yaml:"url"`
+}
+
+// Backend writes a router and a load balanced service per selected service
+// name, with the healthy instances as servers. The router matches the
+// <service>.<Domain> host when the Domain is set, otherwise the /<service>
+// path prefix which is stripped before proxying. The file is replaced
+// atomically and only when its content changes.
+type Backend struct {
+	File        string
+	Services    config.Filter
+	Domain      string
+	EntryPoints []string
+	Scheme      string
+}
+
+func New(file string, services config.Filter) *Backend {
+	return &Backend{File: file, Services: services, Scheme: DEFAULT_SCHEME}
+}
+
+func (b *Backend) Name() string {
+	return "traefik"
+}
+
+func (b *Backend) Publish(ctx context.Context, instances []backend.Instance) error {
+	content, err := yaml.Marshal(b.getConfig(instances))
+	if err != nil {
+		return err
+	}
+	if current, err := ioutil.ReadFile(b.File); err == nil && bytes.Equal(current, content) {
+		log.Println("Traefik configuration is up to date")
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(b.File), 0755); err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(b.File), "."+filepath.Base(b.File)+".tmp")
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, b.File); err != nil {
+		return err
+	}
+	log.Println("Traefik configuration written to: " + b.File)
+	return nil
+}
+
+func (b *Backend) getConfig(instances []backend.Instance) DynamicConfig {
+	var conf = DynamicConfig{HTTP: HTTPConfig{
+		Routers:     make(map[string]Router),
+		Middlewares: make(map[string]Middleware),
+		Services:    make(map[string]Service),
+	}}
+	for name, group := range backend.GroupByName(instances) {
+		if !b.Services.Matches(name) {
+			continue
+		}
+		label := backend.DNSLabel(name)
+		router := Router{Service: label, EntryPoints: b.EntryPoints}
+		if len(b.Domain) > 0 {
+			router.Rule = "Host(`" + label + "." + backend.DNSLabel(b.Domain) + "`)"
+		} else {
+			router.Rule = "PathPrefix(`/" + label + "`)"
+			router.Middlewares = []string{label + "-strip"}
+			conf.HTTP.Middlewares[label+"-strip"] = Middleware{StripPrefix: &StripPrefix{Prefixes: []string{"/" + label}}}
+		}
+		conf.HTTP.Routers[label] = router
+
+		var servers = make([]Server, 0, len(group))
+		for _, instance := range group {
+			servers = append(servers, Server{URL: b.Scheme + "://" + instance.Address + ":" + strconv.FormatInt(instance.Port, 10)})
+		}
+		sort.Slice(servers, func(i, j int) bool { return servers[i].URL < servers[j].URL })
+		conf.HTTP.Services[label] = Service{LoadBalancer: LoadBalancer{Servers: servers}}
+	}
+	return conf
+}
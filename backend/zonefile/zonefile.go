@@ -0,0 +1,118 @@
+// Package zonefile writes the services to a DNS zone file, e.g. the one
+// served by the file plugin of CoreDNS, which reloads it when its serial is
+// increased.
+package zonefile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/backend"
+)
+
+const (
+	DEFAULT_TTL = 60
+	SOA_REFRESH = 7200
+	SOA_RETRY   = 3600
+	SOA_EXPIRE  = 1209600
+)
+
+// Backend writes the records of the services to the zone file with an SOA and
+// an NS record. The file is only replaced when the records change, then the
+// serial is set to the YYYYMMDDnn of the day or to the previous serial plus
+// one if that is not greater.
+type Backend struct {
+	File       string
+	Domain     string
+	TTL        int64
+	Nameserver string
+	Hostmaster string
+}
+
+// New creates a backend of the zone with ns.<domain> as the name server and
+// hostmaster.<domain> as the responsible mailbox, which may also be given as
+// an e-mail address.
+func New(file string, domain string) *Backend {
+	domain = backend.Fqdn(domain)
+	return &Backend{File: file, Domain: domain, TTL: DEFAULT_TTL, Nameserver: "ns." + domain, Hostmaster: "hostmaster." + domain}
+}
+
+func (b *Backend) Name() string {
+	return "zone-file"
+}
+
+func (b *Backend) Publish(ctx context.Context, instances []backend.Instance) error {
+	records := b.formatRecords(backend.GetDNSRecords(instances, b.Domain, b.TTL))
+	var serial uint32
+	if current, err := ioutil.ReadFile(b.File); err == nil {
+		currentSerial, currentRecords := parseZone(current)
+		if currentRecords == records {
+			log.Println("Zone file is up to date, serial: " + strconv.FormatUint(uint64(currentSerial), 10))
+			return nil
+		}
+		serial = currentSerial
+	}
+	serial = nextSerial(serial, time.Now())
+
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "$ORIGIN %s\n", b.Domain)
+	fmt.Fprintf(&content, "@ %d IN SOA %s %s %d %d %d %d %d\n", b.TTL, backend.Fqdn(b.Nameserver), backend.Fqdn(strings.Replace(b.Hostmaster, "@", ".", 1)), serial, SOA_REFRESH, SOA_RETRY, SOA_EXPIRE, b.TTL)
+	content.WriteString(records)
+
+	if err := os.MkdirAll(filepath.Dir(b.File), 0755); err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(b.File), "."+filepath.Base(b.File)+".tmp")
+	if err := ioutil.WriteFile(tmp, content.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, b.File); err != nil {
+		return err
+	}
+	log.Printf("Zone file written to: %s, serial: %d", b.File, serial)
+	return nil
+}
+
+// formatRecords returns the NS record and the records of the services, one
+// line per value.
+func (b *Backend) formatRecords(records []backend.Record) string {
+	var lines bytes.Buffer
+	fmt.Fprintf(&lines, "@ %d IN NS %s\n", b.TTL, backend.Fqdn(b.Nameserver))
+	for _, record := range records {
+		for _, value := range record.Values {
+			fmt.Fprintf(&lines, "%s %d IN %s %s\n", record.Name, record.TTL, record.Type, value)
+		}
+	}
+	return lines.String()
+}
+
+// parseZone returns the serial and the lines after the SOA record of a zone
+// file written by the backend.
+func parseZone(content []byte) (uint32, string) {
+	lines := strings.SplitAfter(string(content), "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) >= 7 && fields[3] == "SOA" {
+			serial, _ := strconv.ParseUint(fields[6], 10, 32)
+			return uint32(serial), strings.Join(lines[i+1:], "")
+		}
+	}
+	return 0, ""
+}
+
+func nextSerial(previous uint32, now time.Time) uint32 {
+	year, month, day := now.Date()
+	serial := uint32(year*1000000 + int(month)*10000 + day*100)
+	if serial <= previous {
+		serial = previous + 1
+	}
+	return serial
+}
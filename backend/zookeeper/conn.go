@@ -0,0 +1,292 @@
+package zookeeper
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_SESSION_TIMEOUT = 10 * time.Second
+	DIAL_TIMEOUT            = 5 * time.Second
+	PASSWORD_LENGTH         = 16
+	MAX_PACKET_SIZE         = 4 << 20
+)
+
+// Conn is a ZooKeeper session over one connection at a time. The requests
+// are sent one by one and the session is kept alive by pings in between. A
+// lost connection is re-established to the next server with the same
+// session, when the session expired meanwhile a new one is created and
+// OnNewSession is called, as every ephemeral node of the old one is gone.
+type Conn struct {
+	Servers        []string
+	SessionTimeout time.Duration
+	OnNewSession   func()
+
+	lock      sync.Mutex
+	conn      net.Conn
+	server    int
+	sessionID int64
+	password  []byte
+	timeout   time.Duration
+	xid       int32
+	expired   bool
+	pinging   bool
+	closed    bool
+}
+
+func NewConn(servers []string) *Conn {
+	return &Conn{Servers: servers, SessionTimeout: DEFAULT_SESSION_TIMEOUT}
+}
+
+// SessionID returns the ID of the current session, 0 before the first
+// connection.
+func (c *Conn) SessionID() int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.sessionID
+}
+
+func (c *Conn) Create(path string, data []byte, flags int32) error {
+	var e encoder
+	e.string(path)
+	e.buffer(data)
+	e.openACL()
+	e.int32(flags)
+	_, err := c.request(OP_CREATE, e.Bytes())
+	return err
+}
+
+func (c *Conn) Delete(path string) error {
+	var e encoder
+	e.string(path)
+	e.int32(-1)
+	_, err := c.request(OP_DELETE, e.Bytes())
+	return err
+}
+
+func (c *Conn) SetData(path string, data []byte) error {
+	var e encoder
+	e.string(path)
+	e.buffer(data)
+	e.int32(-1)
+	_, err := c.request(OP_SET_DATA, e.Bytes())
+	return err
+}
+
+// Close closes the session, so its ephemeral nodes are removed right away
+// instead of after the session timeout.
+func (c *Conn) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closed = true
+	if c.conn == nil {
+		return nil
+	}
+	c.xid++
+	_, err := c.roundTrip(c.xid, OP_CLOSE_SESSION, nil)
+	c.disconnect()
+	return err
+}
+
+func (c *Conn) request(op int32, body []byte) ([]byte, error) {
+	c.lock.Lock()
+	newSession, err := c.connect()
+	if err != nil {
+		c.lock.Unlock()
+		return nil, err
+	}
+	c.xid++
+	resp, err := c.roundTrip(c.xid, op, body)
+	c.lock.Unlock()
+	if newSession && c.OnNewSession != nil {
+		c.OnNewSession()
+	}
+	return resp, err
+}
+
+// roundTrip sends the request and waits for its response, the watch events
+// and the late ping responses are skipped. The connection is dropped on IO
+// errors and on session expiry.
+func (c *Conn) roundTrip(xid int32, op int32, body []byte) ([]byte, error) {
+	var e encoder
+	e.int32(xid)
+	e.int32(op)
+	e.Write(body)
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if err := writePacket(c.conn, e.Bytes()); err != nil {
+		c.disconnect()
+		return nil, err
+	}
+	for {
+		packet, err := readPacket(c.conn)
+		if err != nil {
+			c.disconnect()
+			return nil, err
+		}
+		d := decoder{data: packet}
+		respXid := d.int32()
+		d.int64()
+		code := d.int32()
+		if d.err != nil {
+			c.disconnect()
+			return nil, d.err
+		}
+		if respXid != xid {
+			continue
+		}
+		if code == ERR_SESSION_EXPIRED {
+			c.disconnect()
+			c.sessionID, c.password, c.expired = 0, nil, true
+		}
+		if code != 0 {
+			return nil, Error(code)
+		}
+		return d.data, nil
+	}
+}
+
+// connect connects to the servers in turn unless already connected, and
+// returns whether a new session replaced an expired one.
+func (c *Conn) connect() (bool, error) {
+	if c.closed {
+		return false, errors.New("ZooKeeper session is closed")
+	}
+	if c.conn != nil {
+		return false, nil
+	}
+	if len(c.Servers) == 0 {
+		return false, errors.New("No ZooKeeper servers are configured")
+	}
+	expired := c.expired
+	var lastErr error
+	for i := 0; i < len(c.Servers); i++ {
+		server := c.Servers[c.server%len(c.Servers)]
+		conn, sessionID, password, timeout, err := c.handshake(server)
+		if err == nil && timeout <= 0 {
+			log.Println("ZooKeeper session expired, creating a new session")
+			conn.Close()
+			expired = true
+			c.sessionID, c.password = 0, nil
+			conn, sessionID, password, timeout, err = c.handshake(server)
+		}
+		if err != nil {
+			log.Println("Cannot connect to ZooKeeper server " + server + ": " + err.Error())
+			lastErr = err
+			c.server++
+			continue
+		}
+		newSession := c.sessionID != 0 && c.sessionID != sessionID
+		c.conn, c.sessionID, c.password, c.timeout = conn, sessionID, password, timeout
+		c.expired = false
+		if !c.pinging {
+			c.pinging = true
+			go c.ping()
+		}
+		log.Printf("Connected to ZooKeeper server %s, session: 0x%x", server, sessionID)
+		return expired || newSession, nil
+	}
+	return false, lastErr
+}
+
+func (c *Conn) handshake(server string) (net.Conn, int64, []byte, time.Duration, error) {
+	conn, err := net.DialTimeout("tcp", server, DIAL_TIMEOUT)
+	if err != nil {
+		return nil, 0, nil, 0, err
+	}
+	password := c.password
+	if password == nil {
+		password = make([]byte, PASSWORD_LENGTH)
+	}
+	var e encoder
+	e.int32(0)
+	e.int64(0)
+	e.int32(int32(c.SessionTimeout / time.Millisecond))
+	e.int64(c.sessionID)
+	e.buffer(password)
+	e.bool(false)
+	conn.SetDeadline(time.Now().Add(DIAL_TIMEOUT))
+	if err := writePacket(conn, e.Bytes()); err != nil {
+		conn.Close()
+		return nil, 0, nil, 0, err
+	}
+	packet, err := readPacket(conn)
+	if err != nil {
+		conn.Close()
+		return nil, 0, nil, 0, err
+	}
+	d := decoder{data: packet}
+	d.int32()
+	timeout := time.Duration(d.int32()) * time.Millisecond
+	sessionID := d.int64()
+	password = append([]byte(nil), d.buffer()...)
+	if d.err != nil {
+		conn.Close()
+		return nil, 0, nil, 0, d.err
+	}
+	return conn, sessionID, password, timeout, nil
+}
+
+// ping keeps the session alive and reconnects the lost connections until the
+// session is closed.
+func (c *Conn) ping() {
+	for {
+		c.lock.Lock()
+		interval := c.timeout / 3
+		c.lock.Unlock()
+		if interval <= 0 {
+			interval = DEFAULT_SESSION_TIMEOUT / 3
+		}
+		time.Sleep(interval)
+
+		c.lock.Lock()
+		if c.closed {
+			c.lock.Unlock()
+			return
+		}
+		newSession, err := c.connect()
+		if err == nil {
+			_, err = c.roundTrip(PING_XID, OP_PING, nil)
+		}
+		c.lock.Unlock()
+		if err != nil {
+			log.Println("ZooKeeper ping failed: " + err.Error())
+		}
+		if newSession && c.OnNewSession != nil {
+			c.OnNewSession()
+		}
+	}
+}
+
+func (c *Conn) disconnect() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+		c.server++
+	}
+}
+
+func writePacket(w io.Writer, packet []byte) error {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(packet)))
+	_, err := w.Write(append(length[:], packet...))
+	return err
+}
+
+func readPacket(r io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size > MAX_PACKET_SIZE {
+		return nil, errors.New("ZooKeeper packet is too large")
+	}
+	packet := make([]byte, size)
+	_, err := io.ReadFull(r, packet)
+	return packet, err
+}
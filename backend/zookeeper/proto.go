@@ -0,0 +1,133 @@
+package zookeeper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// The operations of the ZooKeeper client protocol used by the backend.
+const (
+	OP_CREATE        = 1
+	OP_DELETE        = 2
+	OP_SET_DATA      = 5
+	OP_PING          = 11
+	OP_CLOSE_SESSION = -11
+
+	FLAG_EPHEMERAL = 1
+
+	PERM_ALL = 31
+
+	PING_XID  = -2
+	WATCH_XID = -1
+)
+
+// The error codes of the ZooKeeper responses.
+const (
+	ERR_CONNECTION_LOSS = -4
+	ERR_NO_NODE         = -101
+	ERR_NODE_EXISTS     = -110
+	ERR_SESSION_EXPIRED = -112
+)
+
+// Error is an error code returned by ZooKeeper.
+type Error int32
+
+func (e Error) Error() string {
+	switch e {
+	case ERR_CONNECTION_LOSS:
+		return "ZooKeeper connection lost"
+	case ERR_NO_NODE:
+		return "ZooKeeper node does not exist"
+	case ERR_NODE_EXISTS:
+		return "ZooKeeper node already exists"
+	case ERR_SESSION_EXPIRED:
+		return "ZooKeeper session expired"
+	}
+	return "ZooKeeper error: " + strconv.Itoa(int(e))
+}
+
+// encoder writes the big endian jute encoding of the requests.
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) int32(v int32) {
+	binary.Write(&e.Buffer, binary.BigEndian, v)
+}
+
+func (e *encoder) int64(v int64) {
+	binary.Write(&e.Buffer, binary.BigEndian, v)
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.WriteByte(1)
+	} else {
+		e.WriteByte(0)
+	}
+}
+
+func (e *encoder) buffer(v []byte) {
+	if v == nil {
+		e.int32(-1)
+		return
+	}
+	e.int32(int32(len(v)))
+	e.Write(v)
+}
+
+func (e *encoder) string(v string) {
+	e.buffer([]byte(v))
+}
+
+// openACL is the world:anyone ACL with every permission.
+func (e *encoder) openACL() {
+	e.int32(1)
+	e.int32(PERM_ALL)
+	e.string("world")
+	e.string("anyone")
+}
+
+// decoder reads the jute encoding of the responses, the first error is kept
+// and every later read returns zero values.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.data) < n {
+		d.err = errors.New("Truncated ZooKeeper response")
+		return nil
+	}
+	v := d.data[:n]
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) int32() int32 {
+	if v := d.next(4); v != nil {
+		return int32(binary.BigEndian.Uint32(v))
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if v := d.next(8); v != nil {
+		return int64(binary.BigEndian.Uint64(v))
+	}
+	return 0
+}
+
+func (d *decoder) buffer() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
@@ -0,0 +1,184 @@
+// Package zookeeper publishes the services to ZooKeeper the way Nerve does,
+// so the Synapse instances of a SmartStack routing layer can discover them
+// without any change.
+package zookeeper
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/hortonworks/cloudbreak-service-registration/backend"
+)
+
+const DEFAULT_ROOT = "/nerve/services"
+
+// Registration is the JSON data of a Nerve registration node.
+type Registration struct {
+	Host   string            `json:"host"`
+	Port   int64             `json:"port"`
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Backend registers every healthy instance as an ephemeral node of its
+// session at <Root>/<service name>/<instance ID>, the path Synapse watches.
+// The ephemeral nodes are removed by ZooKeeper when the session ends, so the
+// nodes are created again whenever a new session replaces an expired one.
+type Backend struct {
+	Conn *Conn
+	Root string
+
+	lock      sync.Mutex
+	instances []backend.Instance
+	published map[string]node
+	parents   map[string]bool
+}
+
+type node struct {
+	data      []byte
+	sessionID int64
+}
+
+func New(conn *Conn) *Backend {
+	b := &Backend{Conn: conn, Root: DEFAULT_ROOT, published: make(map[string]node), parents: make(map[string]bool)}
+	conn.OnNewSession = b.republish
+	return b
+}
+
+func (b *Backend) Name() string {
+	return "zookeeper"
+}
+
+func (b *Backend) Publish(ctx context.Context, instances []backend.Instance) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.instances = instances
+	return b.sync()
+}
+
+// Close ends the session, so the registrations are removed right away.
+func (b *Backend) Close() error {
+	return b.Conn.Close()
+}
+
+func (b *Backend) republish() {
+	go func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		if b.instances == nil {
+			return
+		}
+		log.Println("Publishing the services to the new ZooKeeper session")
+		if err := b.sync(); err != nil {
+			log.Println("Failed to publish the services to ZooKeeper: " + err.Error())
+		}
+	}()
+}
+
+func (b *Backend) sync() error {
+	desired := b.getNodes(b.instances)
+	created, updated, deleted := 0, 0, 0
+	for path, data := range desired {
+		current, ok := b.getPublished(path)
+		if ok && string(current.data) == string(data) {
+			continue
+		}
+		if ok {
+			if err := b.Conn.SetData(path, data); err != nil && err != Error(ERR_NO_NODE) {
+				return err
+			} else if err == nil {
+				b.published[path] = node{data: data, sessionID: current.sessionID}
+				updated++
+				continue
+			}
+		}
+		if err := b.create(path, data); err != nil {
+			return err
+		}
+		b.published[path] = node{data: data, sessionID: b.Conn.SessionID()}
+		created++
+	}
+	for path := range b.published {
+		if _, ok := desired[path]; ok {
+			continue
+		}
+		if _, ok := b.getPublished(path); ok {
+			if err := b.Conn.Delete(path); err != nil && err != Error(ERR_NO_NODE) {
+				return err
+			}
+			deleted++
+		}
+		delete(b.published, path)
+	}
+	if created+updated+deleted > 0 {
+		log.Printf("ZooKeeper registrations created: %d, updated: %d, deleted: %d", created, updated, deleted)
+	} else {
+		log.Println("ZooKeeper registrations are up to date")
+	}
+	return nil
+}
+
+// getPublished returns the published node unless it was created by an
+// earlier session and was removed together with it.
+func (b *Backend) getPublished(path string) (node, bool) {
+	published, ok := b.published[path]
+	if !ok || published.sessionID != b.Conn.SessionID() {
+		return node{}, false
+	}
+	return published, true
+}
+
+// create creates the ephemeral node and its missing parents. A node left
+// behind by an earlier session, e.g. of the previous leader, is replaced.
+func (b *Backend) create(path string, data []byte) error {
+	if err := b.createParents(path); err != nil {
+		return err
+	}
+	err := b.Conn.Create(path, data, FLAG_EPHEMERAL)
+	if err == Error(ERR_NODE_EXISTS) {
+		log.Println("Replacing the ZooKeeper node of another session: " + path)
+		if err = b.Conn.Delete(path); err != nil && err != Error(ERR_NO_NODE) {
+			return err
+		}
+		err = b.Conn.Create(path, data, FLAG_EPHEMERAL)
+	}
+	return err
+}
+
+func (b *Backend) createParents(path string) error {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	parent := ""
+	for _, part := range parts[:len(parts)-1] {
+		parent += "/" + part
+		if b.parents[parent] {
+			continue
+		}
+		if err := b.Conn.Create(parent, nil, 0); err != nil && err != Error(ERR_NODE_EXISTS) {
+			return err
+		}
+		b.parents[parent] = true
+	}
+	return nil
+}
+
+func (b *Backend) getNodes(instances []backend.Instance) map[string][]byte {
+	var nodes = make(map[string][]byte)
+	root := "/" + strings.Trim(b.Root, "/")
+	if root == "/" {
+		root = ""
+	}
+	for name, group := range backend.GroupByName(instances) {
+		for _, instance := range group {
+			data, _ := json.Marshal(Registration{Host: instance.Address, Port: instance.Port, Name: instance.ID, Labels: instance.Meta})
+			nodes[root+"/"+getNodeName(name)+"/"+getNodeName(instance.ID)] = data
+		}
+	}
+	return nodes
+}
+
+func getNodeName(name string) string {
+	return strings.Replace(name, "/", "_", -1)
+}
@@ -0,0 +1,322 @@
+package zookeeper
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/backend"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// fakeServer serves the subset of the ZooKeeper client protocol used by Conn
+// from an in-memory tree. Expire ends every session like a server would after
+// the session timeout.
+type fakeServer struct {
+	listener net.Listener
+
+	lock        sync.Mutex
+	lastSession int64
+	sessions    map[int64]bool
+	nodes       map[string]fakeNode
+	conns       map[net.Conn]bool
+}
+
+type fakeNode struct {
+	data  []byte
+	owner int64
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{listener: listener, sessions: make(map[int64]bool), nodes: map[string]fakeNode{"/": {}}, conns: make(map[net.Conn]bool)}
+	t.Cleanup(func() {
+		listener.Close()
+		s.Expire()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) address() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeServer) node(path string) (fakeNode, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	node, ok := s.nodes[path]
+	return node, ok
+}
+
+// Expire ends the sessions, removes their ephemeral nodes and drops the
+// connections.
+func (s *fakeServer) Expire() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for sessionID := range s.sessions {
+		s.endSession(sessionID)
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *fakeServer) endSession(sessionID int64) {
+	delete(s.sessions, sessionID)
+	for path, node := range s.nodes {
+		if node.owner == sessionID {
+			delete(s.nodes, path)
+		}
+	}
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	packet, err := readPacket(conn)
+	if err != nil {
+		return
+	}
+	d := decoder{data: packet}
+	d.int32()
+	d.int64()
+	timeout := d.int32()
+	sessionID := d.int64()
+
+	s.lock.Lock()
+	if sessionID != 0 && !s.sessions[sessionID] {
+		// an expired session is refused with a zero timeout
+		timeout, sessionID = 0, 0
+	} else if sessionID == 0 {
+		s.lastSession++
+		sessionID = s.lastSession
+		s.sessions[sessionID] = true
+	}
+	s.conns[conn] = true
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+	}()
+
+	var e encoder
+	e.int32(0)
+	e.int32(timeout)
+	e.int64(sessionID)
+	e.buffer(make([]byte, PASSWORD_LENGTH))
+	if writePacket(conn, e.Bytes()) != nil || timeout == 0 {
+		return
+	}
+	for {
+		packet, err := readPacket(conn)
+		if err != nil {
+			return
+		}
+		d := decoder{data: packet}
+		xid := d.int32()
+		op := d.int32()
+		s.lock.Lock()
+		code, body := s.handle(sessionID, op, &d)
+		s.lock.Unlock()
+		var e encoder
+		e.int32(xid)
+		e.int64(0)
+		e.int32(code)
+		e.Write(body)
+		if writePacket(conn, e.Bytes()) != nil || op == OP_CLOSE_SESSION {
+			return
+		}
+	}
+}
+
+func (s *fakeServer) handle(sessionID int64, op int32, d *decoder) (int32, []byte) {
+	if !s.sessions[sessionID] {
+		return ERR_SESSION_EXPIRED, nil
+	}
+	switch op {
+	case OP_CREATE:
+		path := string(d.buffer())
+		data := d.buffer()
+		for acls := d.int32(); acls > 0; acls-- {
+			d.int32()
+			d.buffer()
+			d.buffer()
+		}
+		flags := d.int32()
+		if _, ok := s.nodes[path]; ok {
+			return ERR_NODE_EXISTS, nil
+		}
+		if _, ok := s.nodes[getParent(path)]; !ok {
+			return ERR_NO_NODE, nil
+		}
+		var owner int64
+		if flags&FLAG_EPHEMERAL != 0 {
+			owner = sessionID
+		}
+		s.nodes[path] = fakeNode{data: data, owner: owner}
+		var e encoder
+		e.string(path)
+		return 0, e.Bytes()
+	case OP_DELETE:
+		path := string(d.buffer())
+		if _, ok := s.nodes[path]; !ok {
+			return ERR_NO_NODE, nil
+		}
+		delete(s.nodes, path)
+	case OP_SET_DATA:
+		path := string(d.buffer())
+		node, ok := s.nodes[path]
+		if !ok {
+			return ERR_NO_NODE, nil
+		}
+		node.data = d.buffer()
+		s.nodes[path] = node
+	case OP_CLOSE_SESSION:
+		s.endSession(sessionID)
+	}
+	return 0, nil
+}
+
+func getParent(path string) string {
+	for i := len(path) - 1; i > 0; i-- {
+		if path[i] == '/' {
+			return path[:i]
+		}
+	}
+	return "/"
+}
+
+func TestEncoderDecoderRoundTrip(t *testing.T) {
+	var e encoder
+	e.int32(-7)
+	e.int64(1 << 40)
+	e.bool(true)
+	e.string("/nerve/services")
+	e.buffer(nil)
+	e.buffer([]byte{})
+
+	d := decoder{data: e.Bytes()}
+	if v := d.int32(); v != -7 {
+		t.Errorf("Expected -7, got: %d", v)
+	}
+	if v := d.int64(); v != 1<<40 {
+		t.Errorf("Expected 1<<40, got: %d", v)
+	}
+	if v := d.next(1); len(v) != 1 || v[0] != 1 {
+		t.Errorf("Expected a true byte, got: %v", v)
+	}
+	if v := string(d.buffer()); v != "/nerve/services" {
+		t.Errorf("Expected /nerve/services, got: %s", v)
+	}
+	if v := d.buffer(); v != nil {
+		t.Errorf("Expected a nil buffer, got: %v", v)
+	}
+	if v := d.buffer(); v == nil || len(v) != 0 {
+		t.Errorf("Expected an empty buffer, got: %v", v)
+	}
+	if d.err != nil || len(d.data) > 0 {
+		t.Errorf("Expected the whole data to be read without errors, left: %v, error: %v", d.data, d.err)
+	}
+
+	truncated := decoder{data: []byte{0, 0, 0, 5, 'a'}}
+	truncated.buffer()
+	if truncated.err == nil {
+		t.Error("Expected an error on a truncated buffer")
+	}
+	if v := truncated.int32(); v != 0 {
+		t.Errorf("Expected zero values after an error, got: %d", v)
+	}
+}
+
+func newTestBackend(t *testing.T, server *fakeServer) *Backend {
+	conn := NewConn([]string{server.address()})
+	conn.SessionTimeout = 300 * time.Millisecond
+	b := New(conn)
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func TestPublishCreatesEphemeralNodes(t *testing.T) {
+	server := newFakeServer(t)
+	b := newTestBackend(t, server)
+	instances := []backend.Instance{{ID: "datanode.h1", Name: "datanode", Address: "10.0.0.1", Port: 50010, Healthy: true}}
+	if err := b.Publish(context.Background(), instances); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, parent := range []string{"/nerve", "/nerve/services", "/nerve/services/datanode"} {
+		if node, ok := server.node(parent); !ok || node.owner != 0 {
+			t.Errorf("Expected the persistent parent %s, got: %v", parent, node)
+		}
+	}
+	node, ok := server.node("/nerve/services/datanode/datanode.h1")
+	if !ok {
+		t.Fatal("The registration node is not created")
+	}
+	if node.owner != b.Conn.SessionID() {
+		t.Errorf("Expected an ephemeral node of session %d, got: %d", b.Conn.SessionID(), node.owner)
+	}
+	var registration Registration
+	if err := json.Unmarshal(node.data, &registration); err != nil {
+		t.Fatal(err)
+	}
+	if registration.Host != "10.0.0.1" || registration.Port != 50010 || registration.Name != "datanode.h1" {
+		t.Errorf("Unexpected registration: %v", registration)
+	}
+
+	if err := b.Publish(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.node("/nerve/services/datanode/datanode.h1"); ok {
+		t.Error("The registration node of the removed instance is kept")
+	}
+}
+
+func TestPublishAgainAfterSessionExpiry(t *testing.T) {
+	server := newFakeServer(t)
+	b := newTestBackend(t, server)
+	instances := []backend.Instance{{ID: "datanode.h1", Name: "datanode", Address: "10.0.0.1", Port: 50010, Healthy: true}}
+	if err := b.Publish(context.Background(), instances); err != nil {
+		t.Fatal(err)
+	}
+	expiredSession := b.Conn.SessionID()
+
+	server.Expire()
+	if _, ok := server.node("/nerve/services/datanode/datanode.h1"); ok {
+		t.Fatal("The ephemeral node survived the session")
+	}
+	// the pings reconnect, get a new session and publish the nodes again
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if node, ok := server.node("/nerve/services/datanode/datanode.h1"); ok {
+			if node.owner == expiredSession {
+				t.Errorf("Expected the node to be owned by a new session, got: %d", node.owner)
+			}
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Error("The registration node was not published to the new session")
+}
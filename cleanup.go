@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/hortonworks/cloudbreak-service-registration/config"
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+	"github.com/hortonworks/cloudbreak-service-registration/registration"
+)
+
+// runCleanup deregisters the services of the clusters with the settings of
+// the config profile. Without clusters the clusters of the Ambari server are
+// cleaned up, together with the Ambari server and agents. It takes the PID
+// file lock, so it refuses to run next to the service registration, which
+// would register the services again.
+func runCleanup(profile string, consulClient *consul.Client, clusters []string) error {
+	pidFile, err := lockPidFile(getPidFilePath())
+	if err != nil {
+		return err
+	}
+	defer unlockPidFile(pidFile)
+	conf, err := config.LoadProfile(getConfigPath(), profile)
+	if err != nil {
+		return err
+	}
+	consulClient.Verbose = conf.IsVerbose()
+	if len(clusters) == 0 {
+		names, err := createAmbariClient().GetClusterNames(context.Background())
+		if err != nil {
+			return errors.New("Failed to get the clusters from Ambari, pass the clusters to clean up as arguments: " + err.Error())
+		}
+		clusters = append(names, "")
+	}
+	failures, err := registration.Cleanup(consulClient, clusters)
+	if err != nil {
+		return err
+	}
+	if len(failures) > 0 {
+		return errors.New("Failed to deregister " + strconv.Itoa(len(failures)) + " services")
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+// Package cloudbreak enriches the host components with the metadata of the
+// Cloudbreak stack they belong to.
+package cloudbreak
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+)
+
+// StackResponse is the part of the Cloudbreak stack resource used for the
+// enrichment.
+type StackResponse struct {
+	Name          string `json:"name"`
+	CloudPlatform string `json:"cloudPlatform"`
+	Cluster       struct {
+		Blueprint struct {
+			Name string `json:"name"`
+		} `json:"blueprint"`
+	} `json:"cluster"`
+	InstanceGroups []struct {
+		Group    string `json:"group"`
+		Metadata []struct {
+			DiscoveryFQDN string `json:"discoveryFQDN"`
+			PrivateIP     string `json:"privateIp"`
+		} `json:"metadata"`
+	} `json:"instanceGroups"`
+}
+
+// Client reads the stack from the Cloudbreak API. BaseURL points to the API
+// root, e.g. https://cloudbreak/cb/api/v1.
+type Client struct {
+	HTTP    *http.Client
+	BaseURL string
+	Token   string
+}
+
+func NewClient(httpClient *http.Client, baseURL string, token string) *Client {
+	return &Client{
+		HTTP:    httpClient,
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Token:   token,
+	}
+}
+
+func (c *Client) GetStack(ctx context.Context, stackID string) (*StackResponse, error) {
+	req, _ := http.NewRequest("GET", c.BaseURL+"/stacks/"+stackID, nil)
+	req = req.WithContext(ctx)
+	req.Header.Add("Accept", "application/json")
+	if len(c.Token) > 0 {
+		req.Header.Add("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpclient.CloseBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Failed to get the Cloudbreak stack, status: " + strconv.Itoa(resp.StatusCode))
+	}
+	var stack StackResponse
+	if err := json.NewDecoder(resp.Body).Decode(&stack); err != nil {
+		return nil, err
+	}
+	return &stack, nil
+}
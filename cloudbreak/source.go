@@ -0,0 +1,92 @@
+package cloudbreak
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+const DEFAULT_REFRESH_INTERVAL = 10 * time.Minute
+
+// Source decorates another source with the stack metadata. The stack is
+// fetched at most once per RefreshInterval, a failing Cloudbreak API only
+// leaves the metadata as it was, since the enrichment is optional.
+type Source struct {
+	Source          topology.Source
+	Client          *Client
+	StackID         string
+	RefreshInterval time.Duration
+
+	lock        sync.Mutex
+	stack       *StackResponse
+	refreshedAt time.Time
+}
+
+func NewSource(source topology.Source, client *Client, stackID string) *Source {
+	return &Source{
+		Source:          source,
+		Client:          client,
+		StackID:         stackID,
+		RefreshInterval: DEFAULT_REFRESH_INTERVAL,
+	}
+}
+
+func (s *Source) ListComponents(ctx context.Context) ([]topology.HostComponent, error) {
+	components, err := s.Source.ListComponents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stack := s.getStack(ctx)
+	if stack == nil {
+		return components, nil
+	}
+	groups := getInstanceGroups(stack)
+	for i := range components {
+		components[i].Stack = topology.StackInfo{
+			Name:          stack.Name,
+			Blueprint:     stack.Cluster.Blueprint.Name,
+			CloudPlatform: stack.CloudPlatform,
+			InstanceGroup: groups[strings.ToLower(components[i].Hostname)],
+		}
+	}
+	return components, nil
+}
+
+func (s *Source) Hosts() map[string]topology.Host {
+	if hostSource, ok := s.Source.(topology.HostSource); ok {
+		return hostSource.Hosts()
+	}
+	return nil
+}
+
+func (s *Source) getStack(ctx context.Context) *StackResponse {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stack != nil && time.Since(s.refreshedAt) < s.RefreshInterval {
+		return s.stack
+	}
+	stack, err := s.Client.GetStack(ctx, s.StackID)
+	if err != nil {
+		log.Println("Failed to get the stack metadata from Cloudbreak: " + err.Error())
+		return s.stack
+	}
+	s.stack = stack
+	s.refreshedAt = time.Now()
+	return s.stack
+}
+
+func getInstanceGroups(stack *StackResponse) map[string]string {
+	var groups = make(map[string]string)
+	for _, group := range stack.InstanceGroups {
+		for _, instance := range group.Metadata {
+			if len(instance.DiscoveryFQDN) > 0 {
+				groups[strings.ToLower(instance.DiscoveryFQDN)] = group.Group
+			}
+		}
+	}
+	return groups
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+// Backends configures the registries the services are published to besides
+// Consul.
+type Backends struct {
+	Route53   Route53Backend   `yaml:"route53"`
+	AzureDNS  AzureDNSBackend  `yaml:"azure_dns"`
+	CloudDNS  CloudDNSBackend  `yaml:"cloud_dns"`
+	ZooKeeper ZooKeeperBackend `yaml:"zookeeper"`
+	Envoy     EnvoyBackend     `yaml:"envoy"`
+	Traefik   TraefikBackend   `yaml:"traefik"`
+	ZoneFile  ZoneFileBackend  `yaml:"zone_file"`
+	Etcd      EtcdBackend      `yaml:"etcd"`
+}
+
+// Route53Backend publishes the services to a private hosted zone. The owner
+// ID tells apart the records of several service registrations sharing the
+// zone.
+type Route53Backend struct {
+	Enabled      bool   `yaml:"enabled"`
+	HostedZoneID string `yaml:"hosted_zone_id"`
+	Domain       string `yaml:"domain"`
+	TTL          int64  `yaml:"ttl"`
+	OwnerID      string `yaml:"owner_id"`
+}
+
+// AzureDNSBackend publishes the services to a private DNS zone with the
+// managed identity of the VM, the client ID selects a user assigned identity.
+type AzureDNSBackend struct {
+	Enabled        bool   `yaml:"enabled"`
+	SubscriptionID string `yaml:"subscription_id"`
+	ResourceGroup  string `yaml:"resource_group"`
+	Zone           string `yaml:"zone"`
+	ClientID       string `yaml:"client_id"`
+	TTL            int64  `yaml:"ttl"`
+	OwnerID        string `yaml:"owner_id"`
+}
+
+// CloudDNSBackend publishes the services to a private managed zone with the
+// service account of the key file, or of the instance when it is not set.
+// The domain is read from the zone when it is not set.
+type CloudDNSBackend struct {
+	Enabled         bool   `yaml:"enabled"`
+	Project         string `yaml:"project"`
+	Zone            string `yaml:"zone"`
+	Domain          string `yaml:"domain"`
+	CredentialsFile string `yaml:"credentials_file"`
+	TTL             int64  `yaml:"ttl"`
+	OwnerID         string `yaml:"owner_id"`
+}
+
+// ZooKeeperBackend registers the services under the root path the way Nerve
+// does, for the Synapse instances of a SmartStack routing layer.
+type ZooKeeperBackend struct {
+	Enabled        bool          `yaml:"enabled"`
+	Servers        []string      `yaml:"servers"`
+	Root           string        `yaml:"root"`
+	SessionTimeout time.Duration `yaml:"session_timeout"`
+}
+
+// EnvoyBackend serves the services to Envoy over the REST transport of the
+// endpoint discovery service on the address.
+type EnvoyBackend struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address"`
+}
+
+// TraefikBackend writes the services selected by name to a dynamic
+// configuration file watched by the Traefik file provider. The routers match
+// the <service>.<domain> hosts when the domain is set, otherwise the
+// /<service> path prefixes.
+type TraefikBackend struct {
+	Enabled     bool     `yaml:"enabled"`
+	File        string   `yaml:"file"`
+	Services    Filter   `yaml:"services"`
+	Domain      string   `yaml:"domain"`
+	EntryPoints []string `yaml:"entry_points"`
+	Scheme      string   `yaml:"scheme"`
+}
+
+// ZoneFileBackend writes the services to a zone file, e.g. for the file
+// plugin of CoreDNS. The name server defaults to ns.<domain> and the
+// hostmaster to hostmaster.<domain>.
+type ZoneFileBackend struct {
+	Enabled    bool   `yaml:"enabled"`
+	File       string `yaml:"file"`
+	Domain     string `yaml:"domain"`
+	TTL        int64  `yaml:"ttl"`
+	Nameserver string `yaml:"nameserver"`
+	Hostmaster string `yaml:"hostmaster"`
+}
+
+// EtcdBackend writes the services to etcd in the key layout of SkyDNS, with
+// the v3 JSON gateway of the endpoints by default or with the v2 keys API.
+type EtcdBackend struct {
+	Enabled    bool     `yaml:"enabled"`
+	Endpoints  []string `yaml:"endpoints"`
+	APIVersion string   `yaml:"api_version"`
+	Username   string   `yaml:"username"`
+	Password   string   `yaml:"password"`
+	Prefix     string   `yaml:"prefix"`
+	Domain     string   `yaml:"domain"`
+	TTL        int64    `yaml:"ttl"`
+	OwnerID    string   `yaml:"owner_id"`
+}
+
+func (b *Backends) validate() error {
+	if b.Route53.Enabled && (len(b.Route53.HostedZoneID) == 0 || len(b.Route53.Domain) == 0) {
+		return errors.New("Route 53 backend requires a hosted zone ID and a domain")
+	}
+	if b.AzureDNS.Enabled && (len(b.AzureDNS.SubscriptionID) == 0 || len(b.AzureDNS.ResourceGroup) == 0 || len(b.AzureDNS.Zone) == 0) {
+		return errors.New("Azure DNS backend requires a subscription ID, a resource group and a zone")
+	}
+	if b.CloudDNS.Enabled && (len(b.CloudDNS.Project) == 0 || len(b.CloudDNS.Zone) == 0) {
+		return errors.New("Cloud DNS backend requires a project and a managed zone")
+	}
+	if b.ZooKeeper.Enabled && len(b.ZooKeeper.Servers) == 0 {
+		return errors.New("ZooKeeper backend requires at least one server")
+	}
+	if b.Envoy.Enabled && len(b.Envoy.Address) == 0 {
+		return errors.New("Envoy backend requires a listen address")
+	}
+	if b.Traefik.Enabled && len(b.Traefik.File) == 0 {
+		return errors.New("Traefik backend requires a configuration file")
+	}
+	if b.ZoneFile.Enabled && (len(b.ZoneFile.File) == 0 || len(b.ZoneFile.Domain) == 0) {
+		return errors.New("Zone file backend requires a file and a domain")
+	}
+	if b.Etcd.Enabled && (len(b.Etcd.Endpoints) == 0 || len(b.Etcd.Domain) == 0) {
+		return errors.New("etcd backend requires at least one endpoint and a domain")
+	}
+	if b.Etcd.Enabled && len(b.Etcd.APIVersion) > 0 && b.Etcd.APIVersion != "v2" && b.Etcd.APIVersion != "v3" {
+		return errors.New("etcd API version must be v2 or v3")
+	}
+	if err := b.Traefik.Services.compile(); err != nil {
+		return err
+	}
+	return nil
+}
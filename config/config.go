@@ -0,0 +1,429 @@
+package config
+
+import (
+	"errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/ambari"
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+// Config is the service registration config file. The health checks of the
+// registrations run every HealthCheckInterval and describe the Ambari state of
+// the component in their Notes, not in their Output, see consul.Check.
+type Config struct {
+	ComponentUpdateIntervals map[string]time.Duration  `yaml:"component_update_intervals"`
+	StateHysteresis          map[string]int            `yaml:"state_hysteresis"`
+	Components               Filter                    `yaml:"components"`
+	Hosts                    Filter                    `yaml:"hosts"`
+	OnlyStarted              bool                      `yaml:"only_started"`
+	StateTags                map[string]string         `yaml:"state_tags"`
+	Weights                  map[string]consul.Weights `yaml:"weights"`
+	MaintenanceTag           *string                   `yaml:"maintenance_tag"`
+	ExcludeMaintenance       bool                      `yaml:"exclude_maintenance"`
+	Ports                    map[string]int64          `yaml:"ports"`
+	DefaultPort              int64                     `yaml:"default_port"`
+	HealthCheckInterval      time.Duration             `yaml:"health_check_interval"`
+	Services                 []StaticService           `yaml:"services"`
+	RegisterNodes            bool                      `yaml:"register_nodes"`
+	AlertChecks              bool                      `yaml:"alert_checks"`
+	ServiceNames             map[string]string         `yaml:"service_names"`
+	ServiceNameMapping       string                    `yaml:"service_name_mapping"`
+	Aliases                  map[string][]string       `yaml:"aliases"`
+	ServiceNameTemplate      string                    `yaml:"service_name_template"`
+	ServiceIDTemplate        string                    `yaml:"service_id_template"`
+	ServiceNameSuffix        string                    `yaml:"service_name_suffix"`
+	Tags                     []string                  `yaml:"tags"`
+	Sanitization             Sanitization              `yaml:"sanitization"`
+	Hooks                    ExecHooks                 `yaml:"hooks"`
+	Docker                   DockerSource              `yaml:"docker"`
+	APIAuth                  APIAuth                   `yaml:"api_auth"`
+	FaultInjection           FaultInjection            `yaml:"fault_injection"`
+	PollInterval             time.Duration             `yaml:"poll_interval"`
+	MinPollInterval          time.Duration             `yaml:"min_poll_interval"`
+	MaxPollInterval          time.Duration             `yaml:"max_poll_interval"`
+	Profiles                 map[string]interface{}    `yaml:"profiles"`
+	Verbose                  *bool                     `yaml:"verbose"`
+	MaintenanceWindows       []MaintenanceWindow       `yaml:"maintenance_windows"`
+	Pins                     []Pin                     `yaml:"pins"`
+	HostTags                 map[string]string         `yaml:"host_tags"`
+	HostMeta                 map[string]string         `yaml:"host_meta"`
+	Backends                 Backends                  `yaml:"backends"`
+	Notifications            Notifications             `yaml:"notifications"`
+	serviceNameTemplate      *template.Template
+	serviceIDTemplate        *template.Template
+	tagTemplates             []*template.Template
+	hostLock                 sync.RWMutex
+	ambiguousHosts           map[string]bool
+}
+
+// FaultInjection is a test mode injecting faults into the requests to Ambari
+// and Consul. It must not be enabled in production.
+type FaultInjection struct {
+	Ambari httpclient.Faults `yaml:"ambari"`
+	Consul httpclient.Faults `yaml:"consul"`
+}
+
+// APIAuth holds the credentials required by the admin and control APIs,
+// either a bearer token or a basic auth user. The secrets can be read from
+// files, e.g. rendered by a Vault agent, which are read again on every
+// request so rotated secrets are picked up.
+type APIAuth struct {
+	Token        string `yaml:"token"`
+	TokenFile    string `yaml:"token_file"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+func (a APIAuth) IsEnabled() bool {
+	return len(a.Token) > 0 || len(a.TokenFile) > 0 || len(a.Username) > 0
+}
+
+// DockerSource enables registering the labelled containers of the local
+// Docker daemon.
+type DockerSource struct {
+	Enabled     bool   `yaml:"enabled"`
+	Socket      string `yaml:"socket"`
+	LabelPrefix string `yaml:"label_prefix"`
+	HostIP      string `yaml:"host_ip"`
+}
+
+// ExecHooks lists the external commands run on the lifecycle events.
+type ExecHooks struct {
+	PreSync        []ExecHook `yaml:"pre_sync"`
+	PostRegister   []ExecHook `yaml:"post_register"`
+	PostDeregister []ExecHook `yaml:"post_deregister"`
+	SaltEvent      SaltEvent  `yaml:"salt_event"`
+}
+
+// SaltEvent fires a Salt event with salt-call when services are registered or
+// deregistered, so reactors can re-run the states depending on the service
+// discovery. The event tag is <tag>/<event>.
+type SaltEvent struct {
+	Enabled bool          `yaml:"enabled"`
+	Tag     string        `yaml:"tag"`
+	Command []string      `yaml:"command"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type ExecHook struct {
+	Command []string      `yaml:"command"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Filter selects names by glob patterns and regular expressions. Without
+// include rules everything is selected, and exclude rules take precedence over
+// include rules. The ExcludeFile lists further excluded names, one per line,
+// and is read again whenever it is modified.
+type Filter struct {
+	Include      []string `yaml:"include"`
+	Exclude      []string `yaml:"exclude"`
+	IncludeRegex []string `yaml:"include_regex"`
+	ExcludeRegex []string `yaml:"exclude_regex"`
+	ExcludeFile  string   `yaml:"exclude_file"`
+	includeRegex []*regexp.Regexp
+	excludeRegex []*regexp.Regexp
+	excludeList  *nameList
+}
+
+// Load reads and initializes the config file. A missing file results in the
+// default config.
+func Load(configPath string) (*Config, error) {
+	return LoadProfile(configPath, "")
+}
+
+// LoadProfile loads the config file and applies the named profile of it. The
+// settings of a profile override the top level settings of the file.
+func LoadProfile(configPath string, profile string) (*Config, error) {
+	var conf Config
+	if content, err := ioutil.ReadFile(configPath); err == nil {
+		if err := yaml.Unmarshal(content, &conf); err != nil {
+			return nil, errors.New("Cannot parse config file: " + configPath)
+		}
+		log.Println("Config loaded from: " + configPath)
+	} else if !os.IsNotExist(err) {
+		log.Println("Cannot read config file: " + err.Error())
+	}
+	if len(profile) > 0 {
+		if err := conf.applyProfile(profile); err != nil {
+			return nil, err
+		}
+	}
+	if err := conf.Init(); err != nil {
+		return nil, errors.New("Invalid config: " + err.Error())
+	}
+	return &conf, nil
+}
+
+func (c *Config) applyProfile(profile string) error {
+	settings, ok := c.Profiles[profile]
+	if !ok {
+		return errors.New("Config profile not found: " + profile)
+	}
+	overlay, err := yaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(overlay, c); err != nil {
+		return errors.New("Cannot parse config profile: " + profile)
+	}
+	log.Println("Config profile applied: " + profile)
+	return nil
+}
+
+// IsVerbose reports whether the per service lines of every check are logged,
+// which is the default. Profiles of large clusters may turn them off.
+func (c *Config) IsVerbose() bool {
+	return c.Verbose == nil || *c.Verbose
+}
+
+// Init compiles the templates and the filters of the config, it must be
+// called on configs which are not created by Load.
+func (c *Config) Init() error {
+	var err error
+	if len(c.ServiceNameMapping) > 0 {
+		if c.ServiceNames, err = readServiceNameMapping(c.ServiceNameMapping, c.ServiceNames); err != nil {
+			return err
+		}
+	}
+	if c.serviceNameTemplate, err = parseServiceTemplate("service name", c.ServiceNameTemplate, DEFAULT_SERVICE_NAME_TEMPLATE); err != nil {
+		return err
+	}
+	if c.serviceIDTemplate, err = parseServiceTemplate("service ID", c.ServiceIDTemplate, DEFAULT_SERVICE_ID_TEMPLATE); err != nil {
+		return err
+	}
+	for _, t := range []*template.Template{c.serviceNameTemplate, c.serviceIDTemplate} {
+		if err = rejectStateField(t); err != nil {
+			return err
+		}
+	}
+	if c.tagTemplates, err = parseTagTemplates(c.Tags); err != nil {
+		return err
+	}
+	if err = validateStaticServices(c.Services); err != nil {
+		return err
+	}
+	if err = validateHostMeta(c.HostMeta); err != nil {
+		return err
+	}
+	if err = c.Backends.validate(); err != nil {
+		return err
+	}
+	if err = c.Notifications.validate(); err != nil {
+		return err
+	}
+	for _, pin := range c.Pins {
+		if err = pin.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, hooks := range [][]ExecHook{c.Hooks.PreSync, c.Hooks.PostRegister, c.Hooks.PostDeregister} {
+		for _, hook := range hooks {
+			if len(hook.Command) == 0 {
+				return errors.New("Hooks must have a command")
+			}
+		}
+	}
+	if len(c.APIAuth.Username) > 0 && len(c.APIAuth.Password) == 0 && len(c.APIAuth.PasswordFile) == 0 {
+		return errors.New("API auth user must have a password")
+	}
+	for component, cycles := range c.StateHysteresis {
+		if cycles < 0 {
+			return errors.New("State hysteresis of " + component + " must not be negative")
+		}
+	}
+	for i := range c.MaintenanceWindows {
+		if err = c.MaintenanceWindows[i].compile(); err != nil {
+			return err
+		}
+	}
+	for _, filter := range []*Filter{&c.Components, &c.Hosts} {
+		if err = filter.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readServiceNameMapping reads a YAML file of component name to service name
+// pairs. The entries of the file override the ones defined in the config file.
+func readServiceNameMapping(mappingPath string, serviceNames map[string]string) (map[string]string, error) {
+	content, err := ioutil.ReadFile(mappingPath)
+	if err != nil {
+		return nil, err
+	}
+	var mapping map[string]string
+	if err := yaml.Unmarshal(content, &mapping); err != nil {
+		return nil, errors.New("Cannot parse service name mapping file: " + mappingPath)
+	}
+	if serviceNames == nil {
+		serviceNames = make(map[string]string)
+	}
+	for component, serviceName := range mapping {
+		serviceNames[component] = serviceName
+	}
+	log.Printf("Service name mapping loaded from: %s, entries: %d", mappingPath, len(mapping))
+	return serviceNames, nil
+}
+
+// GetComponentUpdateInterval returns the minimum time between two updates of
+// the registrations of the component. An exact match wins over patterns,
+// otherwise the longest matching pattern is used. Zero means the registrations
+// are updated like the components without an interval. An interval below the
+// poll interval makes the service checks that frequent, the other components
+// are still updated at the poll interval.
+func (c *Config) GetComponentUpdateInterval(componentName string) time.Duration {
+	if interval, ok := c.ComponentUpdateIntervals[componentName]; ok {
+		return interval
+	}
+	var interval time.Duration
+	matched := ""
+	for pattern, i := range c.ComponentUpdateIntervals {
+		if ok, _ := path.Match(pattern, componentName); ok && len(pattern) > len(matched) {
+			interval = i
+			matched = pattern
+		}
+	}
+	return interval
+}
+
+// GetStateHysteresis returns the number of consecutive service checks a new
+// state of the component has to be observed in before its registration is
+// updated, matched like the update intervals. Zero and one mean no hysteresis.
+func (c *Config) GetStateHysteresis(componentName string) int {
+	if cycles, ok := c.StateHysteresis[componentName]; ok {
+		return cycles
+	}
+	var cycles int
+	matched := ""
+	for pattern, n := range c.StateHysteresis {
+		if ok, _ := path.Match(pattern, componentName); ok && len(pattern) > len(matched) {
+			cycles = n
+			matched = pattern
+		}
+	}
+	return cycles
+}
+
+// GetFastestUpdateInterval returns the shortest component update interval,
+// zero means no component interval.
+func (c *Config) GetFastestUpdateInterval() time.Duration {
+	var fastest time.Duration
+	for _, interval := range c.ComponentUpdateIntervals {
+		if interval > 0 && (fastest == 0 || interval < fastest) {
+			fastest = interval
+		}
+	}
+	return fastest
+}
+
+func (f *Filter) compile() error {
+	f.includeRegex = make([]*regexp.Regexp, 0, len(f.IncludeRegex))
+	f.excludeRegex = make([]*regexp.Regexp, 0, len(f.ExcludeRegex))
+	for _, expr := range f.IncludeRegex {
+		r, err := regexp.Compile(expr)
+		if err != nil {
+			return err
+		}
+		f.includeRegex = append(f.includeRegex, r)
+	}
+	for _, expr := range f.ExcludeRegex {
+		r, err := regexp.Compile(expr)
+		if err != nil {
+			return err
+		}
+		f.excludeRegex = append(f.excludeRegex, r)
+	}
+	if len(f.ExcludeFile) > 0 {
+		f.excludeList = newNameList(f.ExcludeFile)
+	}
+	return nil
+}
+
+func (f Filter) Matches(name string) bool {
+	return f.MatchesAny(name)
+}
+
+// MatchesAny matches an object known by several names, e.g. a host by its
+// name and address. It is excluded when any of its names is excluded and
+// included when any of them is included.
+func (f Filter) MatchesAny(names ...string) bool {
+	for _, name := range names {
+		if matchesAny(f.Exclude, f.excludeRegex, name) || f.excludeList.contains(name) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 && len(f.includeRegex) == 0 {
+		return true
+	}
+	for _, name := range names {
+		if matchesAny(f.Include, f.includeRegex, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsHostIncluded matches the host filter against the hostname and the IP
+// address of the host.
+func (c *Config) IsHostIncluded(hostname string, ip string) bool {
+	if len(ip) == 0 {
+		return c.Hosts.MatchesAny(hostname)
+	}
+	return c.Hosts.MatchesAny(hostname, ip)
+}
+
+func matchesAny(patterns []string, expressions []*regexp.Regexp, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	for _, expr := range expressions {
+		if expr.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterComponents drops the components of the excluded hosts and the ones
+// excluded by the state and maintenance settings or by the component filter.
+// The Ambari server and agents are only subject to the host filter.
+func (c *Config) FilterComponents(components []topology.HostComponent) []topology.HostComponent {
+	var filtered = make([]topology.HostComponent, 0, len(components))
+	for _, component := range components {
+		if !c.IsHostIncluded(component.Hostname, component.IP) {
+			continue
+		}
+		if ambari.IsAmbariComponent(component.HostComponent) {
+			filtered = append(filtered, component)
+			continue
+		}
+		if c.OnlyStarted && strings.ToUpper(component.State) != "STARTED" {
+			continue
+		}
+		if c.ExcludeMaintenance && component.Maintenance {
+			continue
+		}
+		if c.Components.Matches(component.HostComponent) {
+			filtered = append(filtered, component)
+		}
+	}
+	if skipped := len(components) - len(filtered); skipped > 0 {
+		log.Printf("Skipped %d components by the component, host and state filters", skipped)
+	}
+	return filtered
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+func GetDurationEnv(name string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if len(value) == 0 {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid %s value: %s, using the default: %s", name, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
+func GetIntEnv(name string, defaultValue int) int {
+	value := os.Getenv(name)
+	if len(value) == 0 {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i <= 0 {
+		log.Printf("Invalid %s value: %s, using the default: %d", name, value, defaultValue)
+		return defaultValue
+	}
+	return i
+}
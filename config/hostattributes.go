@@ -0,0 +1,53 @@
+package config
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+var metaKeyPattern = regexp.MustCompile("^[A-Za-z0-9_-]{1,128}$")
+
+// validateHostMeta checks the mapped meta keys against the format accepted by
+// Consul.
+func validateHostMeta(hostMeta map[string]string) error {
+	for attribute, key := range hostMeta {
+		if !metaKeyPattern.MatchString(key) {
+			return errors.New("Invalid meta key for host attribute " + attribute + ": " + key)
+		}
+	}
+	return nil
+}
+
+// UsesHostAttribute returns whether the attribute is mapped to a tag or meta.
+func (c *Config) UsesHostAttribute(attribute string) bool {
+	_, tag := c.HostTags[attribute]
+	_, meta := c.HostMeta[attribute]
+	return tag || meta
+}
+
+// getHostTags maps the host attributes of the component, e.g. rack_info or
+// config_group, to <prefix>:<value> tags by the HostTags prefixes.
+func (c *Config) getHostTags(component topology.HostComponent) []string {
+	var tags = make([]string, 0, len(c.HostTags))
+	for attribute, prefix := range c.HostTags {
+		if value := component.HostAttributes.Get(attribute); len(value) > 0 {
+			tags = append(tags, prefix+":"+value)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// GetHostMeta returns the meta of the mapped host attributes of the component.
+func (c *Config) GetHostMeta(component topology.HostComponent) map[string]string {
+	var meta = make(map[string]string, len(c.HostMeta))
+	for attribute, key := range c.HostMeta {
+		if value := component.HostAttributes.Get(attribute); len(value) > 0 {
+			meta[key] = value
+		}
+	}
+	return meta
+}
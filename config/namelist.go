@@ -0,0 +1,69 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// nameList is a file of names, one per line, with # comments. It is read
+// again whenever it is modified, and a missing file is an empty list.
+type nameList struct {
+	path     string
+	lock     sync.Mutex
+	modified time.Time
+	names    map[string]bool
+}
+
+func newNameList(path string) *nameList {
+	return &nameList{path: path}
+}
+
+func (l *nameList) contains(name string) bool {
+	if l == nil {
+		return false
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.reload()
+	return l.names[name]
+}
+
+func (l *nameList) reload() {
+	info, err := os.Stat(l.path)
+	if os.IsNotExist(err) {
+		l.names = nil
+		return
+	}
+	if err != nil {
+		log.Println("Cannot read the name list: " + err.Error())
+		return
+	}
+	if l.names != nil && !info.ModTime().After(l.modified) {
+		return
+	}
+	content, err := ioutil.ReadFile(l.path)
+	if err != nil {
+		log.Println("Cannot read the name list: " + err.Error())
+		return
+	}
+	var names = make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if len(line) > 0 {
+			names[line] = true
+		}
+	}
+	log.Printf("Name list loaded from: %s, entries: %d", l.path, len(names))
+	l.names = names
+	l.modified = info.ModTime()
+}
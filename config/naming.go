@@ -0,0 +1,417 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/hortonworks/cloudbreak-service-registration/ambari"
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+const (
+	DEFAULT_MAINTENANCE_TAG       = "maintenance"
+	DEFAULT_SERVICE_TAG_TEMPLATE  = "{{.State}}"
+	DEFAULT_PARENT_TAG_TEMPLATE   = "{{with .Service}}service:{{.}}{{end}}"
+	DEFAULT_SERVICE_NAME_TEMPLATE = "{{.ServiceName}}"
+	DEFAULT_SERVICE_ID_TEMPLATE   = `{{.ServiceName}}.{{replace .ShortHostname "_" "-" 1}}`
+	MAX_SERVICE_ID_LENGTH         = 128
+	DEFAULT_WEIGHTS_KEY           = "DEFAULT"
+)
+
+var templateFuncs = template.FuncMap{
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"replace": strings.Replace,
+}
+
+// ExpandAliases adds a copy of every component for each of its configured
+// aliases, so the component gets registered under all of those names too.
+// The Ambari server is always aliased as ambari.
+func (c *Config) ExpandAliases(components []topology.HostComponent) []topology.HostComponent {
+	var expanded = make([]topology.HostComponent, 0, len(components))
+	for _, component := range components {
+		expanded = append(expanded, component)
+		for _, alias := range c.getAliases(component.HostComponent) {
+			aliased := component
+			aliased.Alias = alias
+			expanded = append(expanded, aliased)
+		}
+	}
+	return expanded
+}
+
+func (c *Config) getAliases(componentName string) []string {
+	aliases := c.Aliases[componentName]
+	if componentName != ambari.SERVER_COMPONENT {
+		return aliases
+	}
+	for _, alias := range aliases {
+		if alias == ambari.SERVER_ALIAS {
+			return aliases
+		}
+	}
+	return append([]string{ambari.SERVER_ALIAS}, aliases...)
+}
+
+// GetStateTag maps the Ambari state of the component to the tag used in its
+// registration. Components in maintenance get the maintenance tag, unless it
+// is configured to be empty.
+func (c *Config) GetStateTag(component topology.HostComponent) string {
+	if component.Maintenance {
+		if c.MaintenanceTag == nil {
+			return DEFAULT_MAINTENANCE_TAG
+		} else if len(*c.MaintenanceTag) > 0 {
+			return *c.MaintenanceTag
+		}
+	}
+	if tag, ok := c.StateTags[strings.ToUpper(component.State)]; ok {
+		return tag
+	}
+	return strings.ToLower(component.State)
+}
+
+// GetWeights returns the weights configured for the Ambari state of the
+// component, or nil when weights are not configured. The DEFAULT entry is used
+// for the states without weights.
+func (c *Config) GetWeights(component topology.HostComponent) *consul.Weights {
+	if len(c.Weights) == 0 || len(component.Static) > 0 {
+		return nil
+	}
+	if weights, ok := c.Weights[strings.ToUpper(component.State)]; ok {
+		return &weights
+	}
+	if weights, ok := c.Weights[DEFAULT_WEIGHTS_KEY]; ok {
+		return &weights
+	}
+	return &consul.Weights{Passing: 1, Warning: 1}
+}
+
+// ServiceTemplateData is passed to the service name, ID and tag templates.
+// Component is the Ambari component name, Service is the Ambari service the
+// component belongs to, ServiceName is the mapped and DNS ready name of the
+// component and ShortHostname is the hostname up to the first dot, or the whole
+// hostname with dashes instead of dots if other hosts share its short name. Stack,
+// Blueprint, CloudPlatform and InstanceGroup are only set with the Cloudbreak
+// enrichment. State is only available to the tag templates, the service name
+// and ID must not change with the state.
+type ServiceTemplateData struct {
+	Component     string
+	Service       string
+	ServiceName   string
+	Hostname      string
+	ShortHostname string
+	IP            string
+	State         string
+	Cluster       string
+	Rack          string
+	Stack         string
+	Blueprint     string
+	CloudPlatform string
+	InstanceGroup string
+}
+
+// Sanitization describes how component names are turned into DNS ready
+// service names. Without configuration names are lowercased and underscores
+// are replaced with dashes.
+type Sanitization struct {
+	Lowercase     *bool             `yaml:"lowercase"`
+	Replacements  map[string]string `yaml:"replacements"`
+	StripSuffixes []string          `yaml:"strip_suffixes"`
+	MaxLength     int               `yaml:"max_length"`
+}
+
+func (s Sanitization) Sanitize(name string) string {
+	if s.Lowercase == nil || *s.Lowercase {
+		name = strings.ToLower(name)
+	}
+	for _, suffix := range s.StripSuffixes {
+		if strings.HasSuffix(name, suffix) && len(name) > len(suffix) {
+			name = strings.TrimSuffix(name, suffix)
+			break
+		}
+	}
+	replacements := s.Replacements
+	if replacements == nil {
+		replacements = map[string]string{"_": "-"}
+	}
+	var olds = make([]string, 0, len(replacements))
+	for old := range replacements {
+		olds = append(olds, old)
+	}
+	sort.Strings(olds)
+	for _, old := range olds {
+		name = strings.Replace(name, old, replacements[old], -1)
+	}
+	if s.MaxLength > 0 && len(name) > s.MaxLength {
+		name = strings.TrimRight(name[0:s.MaxLength], "-.")
+	}
+	return name
+}
+
+// AppendSuffix appends the environment or tenant suffix to the sanitized name,
+// shortening the name if needed so the suffix always fits in the max length.
+func (s Sanitization) AppendSuffix(name string, suffix string) string {
+	if len(suffix) == 0 {
+		return name
+	}
+	suffix = s.Sanitize(suffix)
+	if s.MaxLength > 0 && len(name)+len(suffix) > s.MaxLength && s.MaxLength > len(suffix) {
+		name = strings.TrimRight(name[0:s.MaxLength-len(suffix)], "-.")
+	}
+	return name + suffix
+}
+
+func parseServiceTemplate(name string, text string, defaultText string) (*template.Template, error) {
+	if len(text) == 0 {
+		text = defaultText
+	}
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// rejectStateField fails on the service name and ID templates using the State,
+// since a new service ID on every state change would deregister and register
+// the service again.
+func rejectStateField(t *template.Template) error {
+	if usesField(t.Tree.Root, "State") {
+		return errors.New("The " + t.Name() + " template must not use the State, the service ID would change with the state")
+	}
+	return nil
+}
+
+// usesField returns whether the template node refers to the field of the
+// template data.
+func usesField(node parse.Node, field string) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if usesField(child, field) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return usesField(n.Pipe, field)
+	case *parse.IfNode:
+		return usesField(&n.BranchNode, field)
+	case *parse.RangeNode:
+		return usesField(&n.BranchNode, field)
+	case *parse.WithNode:
+		return usesField(&n.BranchNode, field)
+	case *parse.BranchNode:
+		return usesField(n.Pipe, field) || usesField(n.List, field) || usesField(n.ElseList, field)
+	case *parse.TemplateNode:
+		return usesField(n.Pipe, field)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, command := range n.Cmds {
+			if usesField(command, field) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if usesField(arg, field) {
+				return true
+			}
+		}
+	case *parse.ChainNode:
+		return usesField(n.Node, field) || containsIdent(n.Field, field)
+	case *parse.FieldNode:
+		return containsIdent(n.Ident, field)
+	case *parse.VariableNode:
+		return containsIdent(n.Ident, field)
+	}
+	return false
+}
+
+func containsIdent(idents []string, ident string) bool {
+	for _, i := range idents {
+		if i == ident {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) newServiceTemplateData(component topology.HostComponent) ServiceTemplateData {
+	componentName := component.HostComponent
+	if len(component.Alias) > 0 {
+		componentName = component.Alias
+	} else if serviceName, ok := c.ServiceNames[componentName]; ok {
+		componentName = serviceName
+	}
+	shortHostname := c.getShortHostname(component.Hostname)
+	return ServiceTemplateData{
+		Component:     component.HostComponent,
+		Service:       component.Service,
+		ServiceName:   c.Sanitization.Sanitize(componentName),
+		Hostname:      component.Hostname,
+		ShortHostname: shortHostname,
+		IP:            component.IP,
+		State:         c.GetStateTag(component),
+		Cluster:       component.Cluster,
+		Rack:          component.Rack,
+		Stack:         component.Stack.Name,
+		Blueprint:     component.Stack.Blueprint,
+		CloudPlatform: component.Stack.CloudPlatform,
+		InstanceGroup: component.Stack.InstanceGroup,
+	}
+}
+
+func (c *Config) GetServiceName(component topology.HostComponent) string {
+	if static := c.GetStaticService(component); static != nil {
+		return static.Name
+	}
+	data := c.newServiceTemplateData(component)
+	name := data.ServiceName
+	if c.serviceNameTemplate != nil {
+		name = c.Sanitization.Sanitize(executeServiceTemplate(c.serviceNameTemplate, data, data.ServiceName))
+	}
+	return c.Sanitization.AppendSuffix(name, c.ServiceNameSuffix)
+}
+
+func (c *Config) GetServiceID(component topology.HostComponent) string {
+	data := c.newServiceTemplateData(component)
+	data.ServiceName = c.GetServiceName(component)
+	defaultID := data.ServiceName + "." + strings.Replace(data.ShortHostname, "_", "-", 1)
+	if c.serviceIDTemplate == nil {
+		return sanitizeServiceID(defaultID)
+	}
+	return sanitizeServiceID(executeServiceTemplate(c.serviceIDTemplate, data, defaultID))
+}
+
+// sanitizeServiceID replaces the characters which are not safe in the agent
+// API paths and DNS, and shortens the too long IDs keeping them unique with a
+// hash of the whole ID.
+func sanitizeServiceID(id string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' || r == '.' || r == ':' {
+			return r
+		}
+		return '-'
+	}, id)
+	if len(sanitized) <= MAX_SERVICE_ID_LENGTH {
+		return sanitized
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(id))
+	suffix := fmt.Sprintf("-%08x", hash.Sum32())
+	return sanitized[0:MAX_SERVICE_ID_LENGTH-len(suffix)] + suffix
+}
+
+// SetHostnames records the hosts of the current components, so the hosts
+// sharing a short name get distinct service IDs.
+func (c *Config) SetHostnames(components []topology.HostComponent) {
+	var hostnames = make(map[string]map[string]bool)
+	for _, component := range components {
+		if len(component.Hostname) == 0 {
+			continue
+		}
+		short := shortHostname(component.Hostname)
+		if hostnames[short] == nil {
+			hostnames[short] = make(map[string]bool)
+		}
+		hostnames[short][strings.ToLower(component.Hostname)] = true
+	}
+	var ambiguous = make(map[string]bool)
+	for short, hosts := range hostnames {
+		if len(hosts) > 1 {
+			log.Printf("Hosts share the short name %s, using their full hostnames in the service IDs", short)
+			ambiguous[short] = true
+		}
+	}
+	c.hostLock.Lock()
+	c.ambiguousHosts = ambiguous
+	c.hostLock.Unlock()
+}
+
+func (c *Config) getShortHostname(hostname string) string {
+	short := shortHostname(hostname)
+	c.hostLock.RLock()
+	defer c.hostLock.RUnlock()
+	if c.ambiguousHosts[short] {
+		return strings.Replace(hostname, ".", "-", -1)
+	}
+	return short
+}
+
+func shortHostname(hostname string) string {
+	if i := strings.Index(hostname, "."); i >= 0 {
+		return hostname[0:i]
+	}
+	return hostname
+}
+
+func executeServiceTemplate(t *template.Template, data ServiceTemplateData, defaultValue string) string {
+	var result bytes.Buffer
+	if err := t.Execute(&result, data); err != nil {
+		log.Printf("Cannot generate %s for %s, using: '%s', error: %s", t.Name(), data.Component, defaultValue, err.Error())
+		return defaultValue
+	}
+	if result.Len() == 0 {
+		return defaultValue
+	}
+	return result.String()
+}
+
+func parseTagTemplates(texts []string) ([]*template.Template, error) {
+	if len(texts) == 0 {
+		texts = []string{DEFAULT_SERVICE_TAG_TEMPLATE, DEFAULT_PARENT_TAG_TEMPLATE}
+	}
+	var templates = make([]*template.Template, 0, len(texts))
+	for _, text := range texts {
+		t, err := parseServiceTemplate("service tag", text, text)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+// GetServiceTags renders the configured tag templates. Empty tags are dropped
+// and the ownership tag is always added, since that is how the registrations
+// of the service registration are recognized. Cluster components get the
+// cluster tag too, and every component the tags of its host attributes.
+func (c *Config) GetServiceTags(component topology.HostComponent) []string {
+	data := c.newServiceTemplateData(component)
+	data.ServiceName = c.GetServiceName(component)
+	templates := c.tagTemplates
+	if templates == nil {
+		templates, _ = parseTagTemplates(nil)
+	}
+	var tags = make([]string, 0, len(templates)+1)
+	var seen = make(map[string]bool)
+	for _, t := range templates {
+		tag := executeServiceTemplate(t, data, "")
+		if len(tag) > 0 && !seen[tag] {
+			tags = append(tags, tag)
+			seen[tag] = true
+		}
+	}
+	for _, tag := range c.getHostTags(component) {
+		if !seen[tag] {
+			tags = append(tags, tag)
+			seen[tag] = true
+		}
+	}
+	if !seen[consul.OWNERSHIP_TAG] {
+		tags = append(tags, consul.OWNERSHIP_TAG)
+	}
+	if clusterTag := consul.ClusterTag(component.Cluster); len(component.Cluster) > 0 && !seen[clusterTag] {
+		tags = append(tags, clusterTag)
+	}
+	return tags
+}
@@ -0,0 +1,29 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestInitRejectsTheStateInTheServiceID(t *testing.T) {
+	for _, test := range []struct {
+		nameTemplate string
+		idTemplate   string
+		valid        bool
+	}{
+		{"", "", true},
+		{"", "{{.ServiceName}}-{{.Hostname}}", true},
+		{"", "{{.ServiceName}}-{{.State}}", false},
+		{"", `{{.ServiceName}}{{if eq .State "started"}}-up{{end}}`, false},
+		{"", "{{.ServiceName}}{{with .Hostname}}-{{$.State}}{{end}}", false},
+		{"{{.ServiceName}}-{{lower .State}}", "", false},
+	} {
+		config := &Config{ServiceNameTemplate: test.nameTemplate, ServiceIDTemplate: test.idTemplate, Tags: []string{"{{.State}}"}}
+		err := config.Init()
+		if test.valid && err != nil {
+			t.Errorf("Expected %q and %q to be accepted, got: %v", test.nameTemplate, test.idTemplate, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("Expected %q and %q to be rejected", test.nameTemplate, test.idTemplate)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_AMBARI_UNREACHABLE_ALERT = 10 * time.Minute
+	DEFAULT_CONVERGENCE_STUCK_ALERT  = 30 * time.Minute
+	DEFAULT_MASS_DEREGISTRATION      = 0.5
+)
+
+// Notifications configures the channels the persistent failures are sent to.
+// A failure is notified once it lasted for its period, and again when it is
+// resolved. The deregistration of at least the MassDeregistration ratio of
+// the registered services is notified before it happens.
+type Notifications struct {
+	AmbariUnreachable  time.Duration         `yaml:"ambari_unreachable"`
+	ConvergenceStuck   time.Duration         `yaml:"convergence_stuck"`
+	MassDeregistration float64               `yaml:"mass_deregistration"`
+	Email              EmailNotification     `yaml:"email"`
+	PagerDuty          PagerDutyNotification `yaml:"pagerduty"`
+}
+
+// EmailNotification sends the notifications through the SMTP server at
+// host:port, with STARTTLS when the server supports it. The password may be
+// read from a file instead.
+type EmailNotification struct {
+	Enabled      bool     `yaml:"enabled"`
+	Server       string   `yaml:"server"`
+	Username     string   `yaml:"username"`
+	Password     string   `yaml:"password"`
+	PasswordFile string   `yaml:"password_file"`
+	From         string   `yaml:"from"`
+	To           []string `yaml:"to"`
+}
+
+// PagerDutyNotification sends the critical notifications as PagerDuty events
+// with the routing key of an Events API v2 integration, which may be read
+// from a file instead.
+type PagerDutyNotification struct {
+	Enabled        bool   `yaml:"enabled"`
+	RoutingKey     string `yaml:"routing_key"`
+	RoutingKeyFile string `yaml:"routing_key_file"`
+}
+
+// GetAmbariUnreachable returns the period Ambari has to be unreachable for to
+// be notified.
+func (n Notifications) GetAmbariUnreachable() time.Duration {
+	if n.AmbariUnreachable > 0 {
+		return n.AmbariUnreachable
+	}
+	return DEFAULT_AMBARI_UNREACHABLE_ALERT
+}
+
+// GetConvergenceStuck returns the period a service has to be out of sync for
+// to be notified.
+func (n Notifications) GetConvergenceStuck() time.Duration {
+	if n.ConvergenceStuck > 0 {
+		return n.ConvergenceStuck
+	}
+	return DEFAULT_CONVERGENCE_STUCK_ALERT
+}
+
+// GetMassDeregistration returns the ratio of the registered services whose
+// deregistration is notified.
+func (n Notifications) GetMassDeregistration() float64 {
+	if n.MassDeregistration > 0 {
+		return n.MassDeregistration
+	}
+	return DEFAULT_MASS_DEREGISTRATION
+}
+
+// GetPassword returns the password of the SMTP user, read from the password
+// file if it is set.
+func (e EmailNotification) GetPassword() (string, error) {
+	if len(e.PasswordFile) == 0 {
+		return e.Password, nil
+	}
+	return readSecretFile(e.PasswordFile)
+}
+
+// GetRoutingKey returns the routing key, read from the routing key file if it
+// is set.
+func (p PagerDutyNotification) GetRoutingKey() (string, error) {
+	if len(p.RoutingKeyFile) == 0 {
+		return p.RoutingKey, nil
+	}
+	return readSecretFile(p.RoutingKeyFile)
+}
+
+func readSecretFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+func (n Notifications) validate() error {
+	if n.Email.Enabled && (len(n.Email.Server) == 0 || len(n.Email.From) == 0 || len(n.Email.To) == 0) {
+		return errors.New("Email notifications require a server, a sender and at least one recipient")
+	}
+	if n.PagerDuty.Enabled && len(n.PagerDuty.RoutingKey) == 0 && len(n.PagerDuty.RoutingKeyFile) == 0 {
+		return errors.New("PagerDuty notifications require a routing key")
+	}
+	if n.MassDeregistration < 0 || n.MassDeregistration > 1 {
+		return errors.New("Mass deregistration ratio must be between 0 and 1")
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package config
+
+import "errors"
+
+// Pin overrides the registration of a service, so the service checks don't
+// overwrite it while a misreported component is troubleshot. The set fields
+// replace the address, the tags and the state reported by the source. A pin
+// without overrides freezes the current registration, it is neither updated
+// nor deregistered.
+type Pin struct {
+	ServiceID string   `yaml:"service_id" json:"service_id"`
+	Address   string   `yaml:"address" json:"address,omitempty"`
+	Tags      []string `yaml:"tags" json:"tags,omitempty"`
+	State     string   `yaml:"state" json:"state,omitempty"`
+}
+
+func (p Pin) Validate() error {
+	if len(p.ServiceID) == 0 {
+		return errors.New("Pinned services must have a service ID")
+	}
+	return nil
+}
+
+func (p Pin) HasOverrides() bool {
+	return len(p.Address) > 0 || len(p.Tags) > 0 || len(p.State) > 0
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	WINDOW_PAUSE_DEREGISTRATIONS = "deregistrations"
+	WINDOW_PAUSE_ALL             = "all"
+)
+
+// MaintenanceWindow pauses the deregistrations, or all the changes with the
+// all mode, for Duration from every start matching the cron like Schedule:
+// minute, hour, day of month, month and day of week, in local time. Like in
+// cron, when both day fields are restricted a day matching either of them
+// matches, and both 0 and 7 are Sunday.
+type MaintenanceWindow struct {
+	Schedule   string        `yaml:"schedule"`
+	Duration   time.Duration `yaml:"duration"`
+	Mode       string        `yaml:"mode"`
+	fields     [5]map[int]bool
+	anyDay     bool
+	anyWeekday bool
+}
+
+var scheduleRanges = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+func (w *MaintenanceWindow) compile() error {
+	parts := strings.Fields(w.Schedule)
+	if len(parts) != 5 {
+		return errors.New("Maintenance window schedule must have 5 fields: " + w.Schedule)
+	}
+	if w.Duration <= 0 {
+		return errors.New("Maintenance window must have a duration: " + w.Schedule)
+	}
+	if len(w.Mode) == 0 {
+		w.Mode = WINDOW_PAUSE_DEREGISTRATIONS
+	} else if w.Mode != WINDOW_PAUSE_DEREGISTRATIONS && w.Mode != WINDOW_PAUSE_ALL {
+		return errors.New("Unknown maintenance window mode: " + w.Mode)
+	}
+	for i, part := range parts {
+		values, err := parseScheduleField(part, scheduleRanges[i][0], scheduleRanges[i][1])
+		if err != nil {
+			return errors.New("Invalid maintenance window schedule " + w.Schedule + ": " + err.Error())
+		}
+		w.fields[i] = values
+	}
+	if w.fields[4][7] {
+		delete(w.fields[4], 7)
+		w.fields[4][int(time.Sunday)] = true
+	}
+	w.anyDay = strings.HasPrefix(parts[2], "*")
+	w.anyWeekday = strings.HasPrefix(parts[4], "*")
+	return nil
+}
+
+// parseScheduleField parses the lists of *, values, ranges and steps, e.g.
+// 1,15 or 0-30/5.
+func parseScheduleField(field string, min int, max int) (map[int]bool, error) {
+	var values = make(map[int]bool)
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step <= 0 {
+				return nil, errors.New("Invalid step: " + item)
+			}
+			item = item[0:i]
+		}
+		from, to := min, max
+		if item != "*" {
+			bounds := strings.SplitN(item, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, errors.New("Invalid value: " + item)
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, errors.New("Invalid value: " + item)
+				}
+			}
+		}
+		if from < min || to > max || from > to {
+			return nil, errors.New("Value out of range: " + item)
+		}
+		for v := from; v <= to; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+func (w *MaintenanceWindow) matches(t time.Time) bool {
+	return w.fields[0][t.Minute()] && w.fields[1][t.Hour()] && w.fields[3][int(t.Month())] && w.matchesDay(t)
+}
+
+// matchesDay combines the day fields like cron: with both restricted either
+// one has to match, otherwise both.
+func (w *MaintenanceWindow) matchesDay(t time.Time) bool {
+	day, weekday := w.fields[2][t.Day()], w.fields[4][int(t.Weekday())]
+	if !w.anyDay && !w.anyWeekday {
+		return day || weekday
+	}
+	return day && weekday
+}
+
+// IsActive reports whether a window started within Duration before now.
+func (w *MaintenanceWindow) IsActive(now time.Time) bool {
+	start := now.Truncate(time.Minute)
+	for t := start; now.Sub(t) < w.Duration; t = t.Add(-time.Minute) {
+		if w.matches(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetActiveWindowMode returns the mode of the active maintenance windows, all
+// taking precedence, or an empty string outside the windows.
+func (c *Config) GetActiveWindowMode(now time.Time) string {
+	mode := ""
+	for i := range c.MaintenanceWindows {
+		if c.MaintenanceWindows[i].IsActive(now) {
+			if c.MaintenanceWindows[i].Mode == WINDOW_PAUSE_ALL {
+				return WINDOW_PAUSE_ALL
+			}
+			mode = WINDOW_PAUSE_DEREGISTRATIONS
+		}
+	}
+	return mode
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindowDayFields(t *testing.T) {
+	// 2026-10-01 is a Thursday, 2026-10-05 a Monday
+	thursdayFirst := time.Date(2026, 10, 1, 2, 0, 0, 0, time.Local)
+	monday := time.Date(2026, 10, 5, 2, 0, 0, 0, time.Local)
+	tuesday := time.Date(2026, 10, 6, 2, 0, 0, 0, time.Local)
+	sunday := time.Date(2026, 10, 4, 2, 0, 0, 0, time.Local)
+	for _, test := range []struct {
+		schedule string
+		at       time.Time
+		active   bool
+	}{
+		{"0 2 1 * 1", thursdayFirst, true},
+		{"0 2 1 * 1", monday, true},
+		{"0 2 1 * 1", tuesday, false},
+		{"0 2 * * 1", monday, true},
+		{"0 2 * * 1", thursdayFirst, false},
+		{"0 2 1 * *", thursdayFirst, true},
+		{"0 2 1 * *", monday, false},
+		{"0 2 */2 * 1", monday, true},
+		{"0 2 */2 * 1", tuesday, false},
+		{"0 2 * * 7", sunday, true},
+		{"0 2 * * 0", sunday, true},
+		{"0 2 * * 5-7", sunday, true},
+		{"0 2 * * 5-7", monday, false},
+		{"0 2 * * 7", monday, false},
+	} {
+		window := MaintenanceWindow{Schedule: test.schedule, Duration: time.Minute}
+		if err := window.compile(); err != nil {
+			t.Fatal(err)
+		}
+		if active := window.IsActive(test.at); active != test.active {
+			t.Errorf("Schedule %s at %s: expected active %t, got: %t", test.schedule, test.at.Format("Mon Jan 2"), test.active, active)
+		}
+	}
+}
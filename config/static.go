@@ -0,0 +1,69 @@
+package config
+
+import (
+	"errors"
+	"log"
+	"os"
+
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+const STATIC_SERVICE_STATE = "STARTED"
+
+// StaticService is a non-Ambari service declared in the config file, which is
+// registered and kept up to date together with the Ambari components.
+type StaticService struct {
+	ID      string            `yaml:"id"`
+	Name    string            `yaml:"name"`
+	Address string            `yaml:"address"`
+	Port    int64             `yaml:"port"`
+	Tags    []string          `yaml:"tags"`
+	Meta    map[string]string `yaml:"meta"`
+	Check   *consul.Check     `yaml:"check"`
+}
+
+func validateStaticServices(services []StaticService) error {
+	var ids = make(map[string]bool)
+	for i, service := range services {
+		if len(service.Name) == 0 || len(service.Address) == 0 {
+			return errors.New("Static services must have a name and an address")
+		}
+		if len(service.ID) == 0 {
+			services[i].ID = service.Name
+		}
+		if ids[services[i].ID] {
+			return errors.New("Duplicate static service ID: " + services[i].ID)
+		}
+		ids[services[i].ID] = true
+	}
+	return nil
+}
+
+func (c *Config) GetStaticComponents() []topology.HostComponent {
+	var components = make([]topology.HostComponent, 0, len(c.Services))
+	hostname, _ := os.Hostname()
+	for _, service := range c.Services {
+		components = append(components, topology.HostComponent{
+			HostComponent: service.Name,
+			Hostname:      hostname,
+			IP:            service.Address,
+			State:         STATIC_SERVICE_STATE,
+			Static:        service.ID,
+		})
+	}
+	return components
+}
+
+func (c *Config) GetStaticService(component topology.HostComponent) *StaticService {
+	if len(component.Static) == 0 {
+		return nil
+	}
+	for i := range c.Services {
+		if c.Services[i].ID == component.Static {
+			return &c.Services[i]
+		}
+	}
+	log.Println("Static service not found in the config: " + component.Static)
+	return nil
+}
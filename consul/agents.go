@@ -0,0 +1,156 @@
+package consul
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+	"github.com/hortonworks/cloudbreak-service-registration/metrics"
+)
+
+const (
+	AGENT_BACKOFF     = 30 * time.Second
+	AGENT_MAX_BACKOFF = 10 * time.Minute
+)
+
+// agentHealth remembers the agents which failed the pre-flight probe. An
+// unreachable agent is not probed again until its backoff expires, and the
+// backoff doubles on every consecutive failure.
+type agentHealth struct {
+	lock        sync.Mutex
+	unreachable map[string]unreachableAgent
+}
+
+type unreachableAgent struct {
+	since    time.Time
+	until    time.Time
+	failures uint
+}
+
+func newAgentHealth() *agentHealth {
+	return &agentHealth{unreachable: make(map[string]unreachableAgent)}
+}
+
+// checkAgents probes the agents of the services once and returns the ones
+// which can be written to. The distinct agents are probed in parallel,
+// bounded by the worker pool. A nil agentHealth skips the probes.
+func (c *Client) checkAgents(services []Service) []Service {
+	if c.agents == nil {
+		return services
+	}
+	var addresses = make([]string, 0)
+	var reachable = make(map[string]bool)
+	for _, service := range services {
+		if _, ok := reachable[service.Address]; !ok {
+			addresses = append(addresses, service.Address)
+			reachable[service.Address] = false
+		}
+	}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	var workers = c.newWorkerPool()
+	now := time.Now()
+	for _, address := range addresses {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(address string) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			ok := c.isAgentReachable(address, now)
+			lock.Lock()
+			reachable[address] = ok
+			lock.Unlock()
+		}(address)
+	}
+	wg.Wait()
+	var result = make([]Service, 0, len(services))
+	for _, service := range services {
+		if reachable[service.Address] {
+			result = append(result, service)
+		}
+	}
+	return result
+}
+
+func (c *Client) isAgentReachable(address string, now time.Time) bool {
+	h := c.agents
+	h.lock.Lock()
+	agent, known := h.unreachable[address]
+	h.lock.Unlock()
+	if known && now.Before(agent.until) {
+		return false
+	}
+
+	req, _ := http.NewRequest("GET", c.agentURL(address)+"/v1/agent/self", nil)
+	resp, err := c.do(req, "agent_self")
+	if err == nil {
+		httpclient.CloseBody(resp)
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusForbidden {
+			if known {
+				log.Println("Consul agent is reachable again on host: " + address)
+				h.lock.Lock()
+				delete(h.unreachable, address)
+				h.lock.Unlock()
+			}
+			return true
+		}
+	}
+	metrics.CountError(metrics.ERROR_CONSUL_AGENT_UNREACHABLE)
+
+	backoff := AGENT_BACKOFF
+	for i := uint(0); i < agent.failures && backoff < AGENT_MAX_BACKOFF; i++ {
+		backoff *= 2
+	}
+	if backoff > AGENT_MAX_BACKOFF {
+		backoff = AGENT_MAX_BACKOFF
+	}
+	if !known {
+		agent.since = now
+	}
+	agent.failures++
+	agent.until = now.Add(backoff)
+	log.Printf("Host %s has no reachable Consul agent, skipping its registrations for %s", address, backoff)
+	h.lock.Lock()
+	h.unreachable[address] = agent
+	h.lock.Unlock()
+	return false
+}
+
+// ProbeAgent requests the self endpoint of the agent on the host, or of the
+// local agent when the address is empty, and returns the time of the agent
+// from the Date header of the response.
+func (c *Client) ProbeAgent(address string) (time.Time, error) {
+	baseURL := c.BaseURL
+	if len(address) > 0 {
+		baseURL = c.agentURL(address)
+	}
+	req, _ := http.NewRequest("GET", baseURL+"/v1/agent/self", nil)
+	resp, err := c.do(req, "agent_self")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer httpclient.CloseBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, errors.New("Consul agent responded with status " + resp.Status)
+	}
+	agentTime, _ := http.ParseTime(resp.Header.Get("Date"))
+	return agentTime, nil
+}
+
+// UnreachableAgents returns the addresses of the agents which failed the
+// pre-flight probe, with the time since they are unreachable.
+func (c *Client) UnreachableAgents() map[string]time.Time {
+	var agents = make(map[string]time.Time)
+	if c.agents == nil {
+		return agents
+	}
+	c.agents.lock.Lock()
+	defer c.agents.lock.Unlock()
+	for address, agent := range c.agents.unreachable {
+		agents[address] = agent.since
+	}
+	return agents
+}
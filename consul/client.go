@@ -0,0 +1,215 @@
+package consul
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+	"github.com/hortonworks/cloudbreak-service-registration/metrics"
+)
+
+const (
+	DEFAULT_BASE_URL         = "http://localhost:8500"
+	DEFAULT_AGENT_PORT       = "8500"
+	DEFAULT_WORKER_POOL_SIZE = 10
+	DEFAULT_RETRY_BUDGET     = 10
+	DEFAULT_MAX_WRITES       = 500
+	DEFAULT_AGENT_TIMEOUT    = 5 * time.Second
+	RETRY_DELAY              = time.Second
+)
+
+// ServiceCache keeps the owned catalog entries between two service checks.
+// The catalog is only read again when its index changes.
+type ServiceCache interface {
+	CachedServices(consulIndex string) ([]Service, bool)
+	UpdateServices(consulIndex string, services []Service)
+}
+
+// Client reads the catalog through the local agent and registers the
+// services to the agent running on the host of the service. When
+// AgentBaseURL is set every registration goes to that agent instead. The
+// failed writes are retried until the RetryBudget of the Register or
+// Deregister call runs out. MaxWrites is the write limit of a service check,
+// the reconciler defers the writes above it to the next check. The clients
+// created by NewClient probe the agents before writing to them. Token is the
+// ACL token of the requests, AgentTokens may override it per agent. The
+// Datacenter is read from the local agent unless it is set. Without Verbose
+// the reads and writes of the single services are not logged.
+type Client struct {
+	HTTP           *http.Client
+	BaseURL        string
+	Token          string
+	AgentTokens    *AgentTokens
+	AgentPort      string
+	AgentBaseURL   string
+	WorkerPoolSize int
+	RetryBudget    int
+	MaxWrites      int
+	Verbose        bool
+	Datacenter     string
+	agents         *agentHealth
+	requestID      atomic.Value
+	datacenterLock sync.Mutex
+}
+
+func NewClient(httpClient *http.Client) *Client {
+	return &Client{
+		HTTP:           httpClient,
+		BaseURL:        DEFAULT_BASE_URL,
+		AgentPort:      DEFAULT_AGENT_PORT,
+		WorkerPoolSize: DEFAULT_WORKER_POOL_SIZE,
+		RetryBudget:    DEFAULT_RETRY_BUDGET,
+		MaxWrites:      DEFAULT_MAX_WRITES,
+		Verbose:        true,
+		agents:         newAgentHealth(),
+	}
+}
+
+// GetServices returns the catalog entries tagged with the ownership tag. The
+// cache may be nil.
+func (c *Client) GetServices(cache ServiceCache) ([]Service, error) {
+	var registered = make([]Service, 0)
+
+	req, _ := http.NewRequest("GET", c.BaseURL+"/v1/catalog/services", nil)
+	resp, err := c.do(req, "catalog_services")
+	if err != nil {
+		return nil, err
+	}
+	defer httpclient.CloseBody(resp)
+	consulIndex := resp.Header.Get("X-Consul-Index")
+	if cache != nil {
+		if cached, ok := cache.CachedServices(consulIndex); ok {
+			log.Println("Consul catalog did not change since the last service check, index: " + consulIndex)
+			return cached, nil
+		}
+	}
+	var catalog = make(map[string][]string)
+	decoder := json.NewDecoder(resp.Body)
+	if err = decode(decoder, &catalog); err != nil {
+		return nil, err
+	}
+	var services = make([]string, 0)
+	for service, tags := range catalog {
+		for _, tag := range tags {
+			if tag == OWNERSHIP_TAG {
+				services = append(services, service)
+				break
+			}
+		}
+	}
+	log.Printf("Already registered Consul services: %d, tagged with '%s': %d", len(catalog), OWNERSHIP_TAG, len(services))
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var errorChannel = make(chan error, len(services))
+	var workers = c.newWorkerPool()
+
+	for _, service := range services {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(service string) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			if c.Verbose {
+				log.Println("Get service registrations for: " + service)
+			}
+			req, _ := http.NewRequest("GET", c.BaseURL+"/v1/catalog/service/"+service+"?tag="+OWNERSHIP_TAG, nil)
+			srvResp, err := c.do(req, "catalog_service")
+			if err != nil {
+				errorChannel <- err
+				return
+			}
+			defer httpclient.CloseBody(srvResp)
+			var services []Service
+			decoder := json.NewDecoder(srvResp.Body)
+			if err = decode(decoder, &services); err != nil {
+				errorChannel <- err
+				return
+			}
+			if c.Verbose {
+				log.Printf("Retrieved service info: %v", services)
+			}
+			lock.Lock()
+			registered = append(registered, services...)
+			lock.Unlock()
+		}(service)
+	}
+
+	wg.Wait()
+	close(errorChannel)
+
+	for e := range errorChannel {
+		return nil, e
+	}
+
+	if cache != nil {
+		cache.UpdateServices(consulIndex, registered)
+	}
+	return registered, nil
+}
+
+// Register registers every service to the agent running on the service's
+// address. It returns the writes which failed.
+func (c *Client) Register(services []Service) []WriteFailure {
+	return c.write("register", services, func(service Service) *http.Request {
+		body := service.Json()
+		if c.Verbose {
+			log.Printf("Registering service: %v", body)
+		}
+		req, _ := http.NewRequest("PUT", c.agentURL(service.Address)+"/v1/agent/service/register", bytes.NewBuffer([]byte(body)))
+		req.Header.Add("Content-Type", "application/json")
+		return req
+	})
+}
+
+// Deregister removes the catalog entries through the agent they were
+// registered to. It returns the writes which failed.
+func (c *Client) Deregister(services []Service) []WriteFailure {
+	return c.write("deregister", services, func(service Service) *http.Request {
+		if c.Verbose {
+			log.Printf("Deregistering service: %s", service.ServiceID)
+		}
+		req, _ := http.NewRequest("GET", c.agentURL(service.Address)+"/v1/agent/service/deregister/"+service.ServiceID, nil)
+		return req
+	})
+}
+
+func (c *Client) do(req *http.Request, operation string) (*http.Response, error) {
+	defer metrics.Default.ObserveSince(metrics.CONSUL_REQUEST_DURATION, metrics.Labels{"operation": operation}, time.Now())
+	if id, ok := c.requestID.Load().(string); ok && len(id) > 0 {
+		req = req.WithContext(httpclient.WithRequestID(req.Context(), id))
+	}
+	if token := c.getToken(req.URL.Hostname()); len(token) > 0 {
+		req.Header.Set(TOKEN_HEADER, token)
+	}
+	return c.HTTP.Do(req)
+}
+
+// SetRequestID sets the ID sent with the requests of the current cycle, since
+// the Consul requests are not bound to the context of the cycle.
+func (c *Client) SetRequestID(id string) {
+	c.requestID.Store(id)
+}
+
+func (c *Client) agentURL(address string) string {
+	if len(c.AgentBaseURL) > 0 {
+		return c.AgentBaseURL
+	}
+	return "http://" + address + ":" + c.AgentPort
+}
+
+// newWorkerPool returns a semaphore channel that bounds the number of
+// simultaneous Consul requests. Acquire a slot by sending to the channel
+// and release it by receiving from it.
+func (c *Client) newWorkerPool() chan struct{} {
+	size := c.WorkerPoolSize
+	if size <= 0 {
+		size = DEFAULT_WORKER_POOL_SIZE
+	}
+	return make(chan struct{}, size)
+}
@@ -0,0 +1,53 @@
+package consul
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+)
+
+// DATACENTER_META_KEY marks the datacenter of the service registration which
+// registered the service. In WAN federated setups the catalog may hold owned
+// services replicated from other datacenters, which are left to the service
+// registration of their own datacenter.
+const DATACENTER_META_KEY = "registration-datacenter"
+
+// GetDatacenter returns the Datacenter, or the datacenter of the local agent
+// when it is not set. An empty result means it is unknown.
+func (c *Client) GetDatacenter() string {
+	c.datacenterLock.Lock()
+	defer c.datacenterLock.Unlock()
+	if len(c.Datacenter) > 0 {
+		return c.Datacenter
+	}
+	req, _ := http.NewRequest("GET", c.BaseURL+"/v1/agent/self", nil)
+	resp, err := c.do(req, "agent_self")
+	if err != nil {
+		log.Println("Failed to get the Consul datacenter: " + err.Error())
+		return ""
+	}
+	defer httpclient.CloseBody(resp)
+	var self struct {
+		Config struct {
+			Datacenter string `json:"Datacenter"`
+		} `json:"Config"`
+	}
+	if resp.StatusCode != http.StatusOK || decode(json.NewDecoder(resp.Body), &self) != nil {
+		log.Println("Failed to get the Consul datacenter, status: " + resp.Status)
+		return ""
+	}
+	if len(self.Config.Datacenter) > 0 {
+		log.Println("Consul datacenter: " + self.Config.Datacenter)
+		c.Datacenter = self.Config.Datacenter
+	}
+	return c.Datacenter
+}
+
+// IsForeign returns whether the service was registered from another
+// datacenter than dc.
+func IsForeign(service Service, dc string) bool {
+	registeredDC := service.ServiceMeta[DATACENTER_META_KEY]
+	return len(dc) > 0 && len(registeredDC) > 0 && registeredDC != dc
+}
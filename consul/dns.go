@@ -0,0 +1,33 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+const (
+	DEFAULT_DNS_PORT = "8600"
+	DNS_CHECK_NAME   = "consul.service.consul."
+)
+
+// CheckDNS resolves the Consul servers through the DNS interface of the agent
+// on the host. A name error is an answer too, e.g. when the servers are not
+// registered yet.
+func CheckDNS(ctx context.Context, address string, port string) error {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, net.JoinHostPort(address, port))
+		},
+	}
+	_, err := resolver.LookupHost(ctx, DNS_CHECK_NAME)
+	if dnsErr, ok := err.(*net.DNSError); ok {
+		if dnsErr.IsNotFound {
+			return nil
+		}
+		return errors.New("No DNS answer from " + net.JoinHostPort(address, port) + ": " + dnsErr.Err)
+	}
+	return err
+}
@@ -0,0 +1,26 @@
+package consul
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+)
+
+// PutKV writes the value of the key through the local agent.
+func (c *Client) PutKV(key string, value []byte) error {
+	req, _ := http.NewRequest("PUT", c.BaseURL+"/v1/kv/"+strings.TrimPrefix(key, "/"), bytes.NewBuffer(value))
+	resp, err := c.do(req, "kv_put")
+	if err != nil {
+		return err
+	}
+	defer httpclient.CloseBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return errors.New("Invalid KV put request: " + string(respBody))
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package consul
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+)
+
+const LEADER_SESSION_TTL = 30 * time.Second
+
+// LeaderElection makes sure only one instance reconciles the services when the
+// service registration runs on multiple gateway nodes. The instances compete
+// for a Consul KV lock bound to a session which is renewed in the background.
+// A nil LeaderElection means leader election is disabled and every instance
+// is the leader.
+type LeaderElection struct {
+	client    *Client
+	key       string
+	name      string
+	sessionID string
+	lock      sync.Mutex
+}
+
+func NewLeaderElection(client *Client, key string, name string) *LeaderElection {
+	if len(key) == 0 {
+		return nil
+	}
+	log.Println("Leader election enabled with key: " + key)
+	return &LeaderElection{client: client, key: strings.TrimPrefix(key, "/"), name: name}
+}
+
+func (l *LeaderElection) IsLeader() bool {
+	if l == nil {
+		return true
+	}
+	return l.acquire(l.key)
+}
+
+// acquire takes the lock of the key with the session of the election, the
+// session may hold the locks of several keys.
+func (l *LeaderElection) acquire(key string) bool {
+	sessionID, err := l.getSession()
+	if err != nil {
+		log.Println("Failed to create Consul session: " + err.Error())
+		return false
+	}
+	hostname, _ := os.Hostname()
+	req, _ := http.NewRequest("PUT", l.client.BaseURL+"/v1/kv/"+key+"?acquire="+sessionID, strings.NewReader(hostname))
+	resp, err := l.client.do(req, "lock_acquire")
+	if err != nil {
+		log.Println("Failed to acquire the leader lock: " + err.Error())
+		return false
+	}
+	defer httpclient.CloseBody(resp)
+	var acquired bool
+	if err := decode(json.NewDecoder(resp.Body), &acquired); err != nil {
+		log.Println("Failed to acquire the leader lock: " + err.Error())
+		return false
+	}
+	return acquired
+}
+
+func (l *LeaderElection) Resign() {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	sessionID := l.sessionID
+	l.sessionID = ""
+	l.lock.Unlock()
+	if len(sessionID) == 0 {
+		return
+	}
+	log.Println("Releasing the leader lock")
+	var urls = make([]string, 0, 2)
+	if len(l.key) > 0 {
+		urls = append(urls, l.client.BaseURL+"/v1/kv/"+l.key+"?release="+sessionID)
+	}
+	urls = append(urls, l.client.BaseURL+"/v1/session/destroy/"+sessionID)
+	for _, url := range urls {
+		req, _ := http.NewRequest("PUT", url, nil)
+		if resp, err := l.client.do(req, "lock_release"); err != nil {
+			log.Println("Failed to release the leader lock: " + err.Error())
+		} else {
+			httpclient.CloseBody(resp)
+		}
+	}
+}
+
+// release gives up the lock of the key, keeping the session.
+func (l *LeaderElection) release(key string) {
+	l.lock.Lock()
+	sessionID := l.sessionID
+	l.lock.Unlock()
+	if len(sessionID) == 0 {
+		return
+	}
+	req, _ := http.NewRequest("PUT", l.client.BaseURL+"/v1/kv/"+key+"?release="+sessionID, nil)
+	if resp, err := l.client.do(req, "lock_release"); err != nil {
+		log.Println("Failed to release the lock of " + key + ": " + err.Error())
+	} else {
+		httpclient.CloseBody(resp)
+	}
+}
+
+// sessionRequest is the body of the session create request.
+type sessionRequest struct {
+	Name     string `json:"Name"`
+	TTL      string `json:"TTL"`
+	Behavior string `json:"Behavior"`
+}
+
+func (l *LeaderElection) getSession() (string, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.sessionID) > 0 {
+		return l.sessionID, nil
+	}
+	body, _ := json.Marshal(sessionRequest{Name: l.name, TTL: LEADER_SESSION_TTL.String(), Behavior: "release"})
+	req, _ := http.NewRequest("PUT", l.client.BaseURL+"/v1/session/create", bytes.NewBuffer(body))
+	resp, err := l.client.do(req, "session_create")
+	if err != nil {
+		return "", err
+	}
+	defer httpclient.CloseBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return "", errors.New("Invalid session create request: " + string(respBody))
+	}
+	var session struct {
+		ID string `json:"ID"`
+	}
+	if err := decode(json.NewDecoder(resp.Body), &session); err != nil {
+		return "", err
+	}
+	log.Println("Created Consul session: " + session.ID)
+	l.sessionID = session.ID
+	go l.renew(session.ID)
+	return session.ID, nil
+}
+
+func (l *LeaderElection) renew(sessionID string) {
+	for {
+		time.Sleep(LEADER_SESSION_TTL / 2)
+		l.lock.Lock()
+		current := l.sessionID
+		l.lock.Unlock()
+		if current != sessionID {
+			return
+		}
+		req, _ := http.NewRequest("PUT", l.client.BaseURL+"/v1/session/renew/"+sessionID, nil)
+		resp, err := l.client.do(req, "session_renew")
+		if err != nil {
+			log.Println("Failed to renew Consul session: " + err.Error())
+			continue
+		}
+		httpclient.CloseBody(resp)
+		if resp.StatusCode == http.StatusNotFound {
+			log.Println("Consul session expired: " + sessionID)
+			l.lock.Lock()
+			if l.sessionID == sessionID {
+				l.sessionID = ""
+			}
+			l.lock.Unlock()
+			return
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package consul
+
+import (
+	"log"
+	"strings"
+	"sync"
+)
+
+// NO_CLUSTER_LOCK is the lock of the services which don't belong to a
+// cluster, e.g. the Ambari server and agents.
+const NO_CLUSTER_LOCK = "_ambari"
+
+// ClusterLocks serializes the reconciliation of the clusters seen by several
+// service registrations. Every cluster has a KV lock under the prefix and
+// only the instance holding it registers and deregisters the services of the
+// cluster. The locks share one session, so they are released together when
+// the instance dies.
+type ClusterLocks struct {
+	election *LeaderElection
+	prefix   string
+	lock     sync.Mutex
+	held     map[string]bool
+}
+
+func NewClusterLocks(client *Client, prefix string, name string) *ClusterLocks {
+	if len(prefix) == 0 {
+		return nil
+	}
+	log.Println("Cluster locks enabled with prefix: " + prefix)
+	prefix = strings.Trim(prefix, "/")
+	return &ClusterLocks{
+		election: &LeaderElection{client: client, name: name},
+		prefix:   prefix,
+		held:     make(map[string]bool),
+	}
+}
+
+// Acquire takes the locks of the clusters and returns the ones held. The
+// locks of the clusters which are not listed anymore are released.
+func (c *ClusterLocks) Acquire(clusters []string) map[string]bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var held = make(map[string]bool)
+	for _, cluster := range clusters {
+		if c.election.acquire(c.getKey(cluster)) {
+			if !c.held[cluster] {
+				log.Println("Acquired the lock of cluster: " + getLockName(cluster))
+			}
+			held[cluster] = true
+		} else if c.held[cluster] {
+			log.Println("Lost the lock of cluster: " + getLockName(cluster))
+		}
+	}
+	for cluster := range c.held {
+		if !contains(clusters, cluster) {
+			c.election.release(c.getKey(cluster))
+		}
+	}
+	c.held = held
+	return held
+}
+
+// Held returns the clusters whose lock is held, the empty cluster stands for
+// the services without a cluster.
+func (c *ClusterLocks) Held() []string {
+	if c == nil {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var clusters = make([]string, 0, len(c.held))
+	for cluster := range c.held {
+		clusters = append(clusters, cluster)
+	}
+	return clusters
+}
+
+// Release gives up every lock by destroying the session.
+func (c *ClusterLocks) Release() {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for cluster := range c.held {
+		c.election.release(c.getKey(cluster))
+	}
+	c.held = make(map[string]bool)
+	c.election.Resign()
+}
+
+func (c *ClusterLocks) getKey(cluster string) string {
+	return c.prefix + "/" + getLockName(cluster)
+}
+
+func getLockName(cluster string) string {
+	if len(cluster) == 0 {
+		return NO_CLUSTER_LOCK
+	}
+	return cluster
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package consul
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+)
+
+// Node is a catalog node registration. SkipNodeUpdate is not set, so the
+// node meta of an existing node is replaced.
+type Node struct {
+	Node     string            `json:"Node"`
+	Address  string            `json:"Address"`
+	NodeMeta map[string]string `json:"NodeMeta,omitempty"`
+}
+
+func (n Node) Equals(other Node) bool {
+	if n.Node != other.Node || n.Address != other.Address || len(n.NodeMeta) != len(other.NodeMeta) {
+		return false
+	}
+	for key, value := range n.NodeMeta {
+		if otherValue, ok := other.NodeMeta[key]; !ok || otherValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// RegisterNodes registers the nodes through the catalog endpoint and returns
+// the ones registered successfully.
+func (c *Client) RegisterNodes(nodes []Node) []Node {
+	var registered = make([]Node, 0, len(nodes))
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var workers = c.newWorkerPool()
+	for _, n := range nodes {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(node Node) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			body, _ := json.Marshal(node)
+			log.Printf("Registering node: %s", body)
+			req, _ := http.NewRequest("PUT", c.BaseURL+"/v1/catalog/register", bytes.NewBuffer(body))
+			req.Header.Add("Content-Type", "application/json")
+			resp, err := c.do(req, "register_node")
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			defer httpclient.CloseBody(resp)
+			if resp.StatusCode != http.StatusOK {
+				respBody, _ := ioutil.ReadAll(resp.Body)
+				log.Println("Invalid node register request: " + string(respBody))
+				return
+			}
+			lock.Lock()
+			registered = append(registered, node)
+			lock.Unlock()
+		}(n)
+	}
+	wg.Wait()
+	return registered
+}
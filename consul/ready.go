@@ -0,0 +1,63 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+)
+
+const (
+	DEFAULT_STARTUP_TIMEOUT = 5 * time.Minute
+	STARTUP_MIN_BACKOFF     = time.Second
+	STARTUP_MAX_BACKOFF     = 30 * time.Second
+)
+
+// WaitReady waits with an exponential backoff until the local agent answers
+// and its cluster has a leader, or the timeout elapses.
+func (c *Client) WaitReady(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	backoff := STARTUP_MIN_BACKOFF
+	for {
+		leader, err := c.getLeader(ctx)
+		if err == nil && len(leader) > 0 {
+			log.Println("Consul is available, leader: " + leader)
+			return nil
+		}
+		if err == nil {
+			err = errors.New("No cluster leader elected yet")
+		}
+		log.Printf("Waiting for Consul, retrying in %s: %s", backoff, err.Error())
+		select {
+		case <-ctx.Done():
+			return errors.New("Consul is not available: " + err.Error())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > STARTUP_MAX_BACKOFF {
+			backoff = STARTUP_MAX_BACKOFF
+		}
+	}
+}
+
+func (c *Client) getLeader(ctx context.Context) (string, error) {
+	req, _ := http.NewRequest("GET", c.BaseURL+"/v1/status/leader", nil)
+	resp, err := c.do(req.WithContext(ctx), "status_leader")
+	if err != nil {
+		return "", err
+	}
+	defer httpclient.CloseBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New("Unexpected status of the leader request: " + resp.Status)
+	}
+	var leader string
+	if err := decode(json.NewDecoder(resp.Body), &leader); err != nil {
+		return "", err
+	}
+	return leader, nil
+}
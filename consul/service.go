@@ -0,0 +1,78 @@
+package consul
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// OWNERSHIP_TAG marks the registrations managed by the service registration,
+// the registrations of the cluster components are tagged with the cluster
+// tag too, so the clusters of a shared Ambari server are kept apart.
+const OWNERSHIP_TAG = "ambari"
+
+// Service is used both as the agent registration payload and as a catalog
+// entry, the Service prefixed fields are only set in the catalog responses.
+type Service struct {
+	ID             string            `json:"ID"`
+	Name           string            `json:"Name,omitempty"`
+	Address        string            `json:"Address"`
+	Port           int64             `json:"Port"`
+	Tags           []string          `json:"Tags"`
+	Meta           map[string]string `json:"Meta,omitempty"`
+	ServiceName    string            `json:"ServiceName,omitempty"`
+	ServiceID      string            `json:"ServiceID,omitempty"`
+	ServiceTags    []string          `json:"ServiceTags,omitempty"`
+	ServicePort    int64             `json:"ServicePort,omitempty"`
+	ServiceMeta    map[string]string `json:"ServiceMeta,omitempty"`
+	Weights        *Weights          `json:"Weights,omitempty"`
+	ServiceWeights *Weights          `json:"ServiceWeights,omitempty"`
+	Check          *Check            `json:"Check,omitempty"`
+}
+
+// Weights are the DNS SRV weights of the service while its checks are passing
+// and warning.
+type Weights struct {
+	Passing int `json:"Passing" yaml:"passing"`
+	Warning int `json:"Warning" yaml:"warning"`
+}
+
+// Check is the health check of a registration. Its Notes describe the Ambari
+// state of the component instead of its Output, which Consul overwrites on
+// every run of a TCP or HTTP check, so the watches and the UI have to read
+// the Notes of the check for the description.
+type Check struct {
+	TCP      string `json:"TCP,omitempty" yaml:"tcp"`
+	HTTP     string `json:"HTTP,omitempty" yaml:"http"`
+	Interval string `json:"Interval,omitempty" yaml:"interval"`
+	Timeout  string `json:"Timeout,omitempty" yaml:"timeout"`
+	Notes    string `json:"Notes,omitempty" yaml:"notes"`
+}
+
+func (s *Service) Json() string {
+	j, _ := json.Marshal(s)
+	return string(j)
+}
+
+func IsOwned(service Service) bool {
+	for _, t := range service.ServiceTags {
+		if t == OWNERSHIP_TAG {
+			return true
+		}
+	}
+	return false
+}
+
+func ClusterTag(cluster string) string {
+	return OWNERSHIP_TAG + ":" + cluster
+}
+
+// GetCluster returns the cluster from the cluster tag of the catalog entry,
+// or an empty string for the registrations not belonging to a cluster.
+func GetCluster(service Service) string {
+	for _, t := range service.ServiceTags {
+		if strings.HasPrefix(t, OWNERSHIP_TAG+":") {
+			return strings.TrimPrefix(t, OWNERSHIP_TAG+":")
+		}
+	}
+	return ""
+}
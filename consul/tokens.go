@@ -0,0 +1,69 @@
+package consul
+
+import (
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const TOKEN_HEADER = "X-Consul-Token"
+
+// AgentTokens maps the agent addresses to their ACL tokens. The token file is
+// a YAML map of address to token, it is read again whenever it is modified.
+type AgentTokens struct {
+	Path string
+
+	lock     sync.Mutex
+	modified time.Time
+	tokens   map[string]string
+}
+
+func NewAgentTokens(path string) *AgentTokens {
+	return &AgentTokens{Path: path}
+}
+
+func (t *AgentTokens) Get(address string) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.reload()
+	token, ok := t.tokens[address]
+	return token, ok
+}
+
+func (t *AgentTokens) reload() {
+	info, err := os.Stat(t.Path)
+	if err != nil {
+		log.Println("Cannot read the Consul agent tokens: " + err.Error())
+		return
+	}
+	if t.tokens != nil && !info.ModTime().After(t.modified) {
+		return
+	}
+	content, err := ioutil.ReadFile(t.Path)
+	if err != nil {
+		log.Println("Cannot read the Consul agent tokens: " + err.Error())
+		return
+	}
+	var tokens map[string]string
+	if err := yaml.Unmarshal(content, &tokens); err != nil {
+		log.Println("Cannot parse the Consul agent tokens: " + t.Path)
+		return
+	}
+	log.Printf("Consul agent tokens loaded: %d", len(tokens))
+	t.tokens = tokens
+	t.modified = info.ModTime()
+}
+
+// getToken returns the token of the agent at host, or the default token.
+func (c *Client) getToken(host string) string {
+	if token, ok := c.AgentTokens.Get(host); ok {
+		return token
+	}
+	return c.Token
+}
@@ -0,0 +1,193 @@
+package consul
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+	"github.com/hortonworks/cloudbreak-service-registration/metrics"
+)
+
+const MAX_WRITE_ATTEMPTS = 3
+
+// writeBatch bounds the writes of a Register or Deregister call. Failed
+// writes are retried while the shared retry budget lasts, and once the
+// retries to an agent are exhausted the remaining writes to the same agent
+// are skipped, so an unreachable agent cannot stall the whole cycle. The
+// failed and skipped writes are returned to the caller to be retried in a
+// later cycle.
+type writeBatch struct {
+	lock       sync.Mutex
+	budget     int
+	downAgents map[string]bool
+}
+
+// WriteFailure is a write of a Register or Deregister call which did not
+// succeed. The rejected writes were refused by the agent, sending them again
+// would not help, the others could not be written to the agent.
+type WriteFailure struct {
+	Service  Service
+	Error    string
+	Rejected bool
+}
+
+// GetRetryable returns the services of the failures which were not rejected.
+func GetRetryable(failures []WriteFailure) []Service {
+	var services = make([]Service, 0, len(failures))
+	for _, failure := range failures {
+		if !failure.Rejected {
+			services = append(services, failure.Service)
+		}
+	}
+	return services
+}
+
+func (c *Client) write(operation string, services []Service, newRequest func(Service) *http.Request) []WriteFailure {
+	reachable := c.checkAgents(services)
+	batch := &writeBatch{budget: c.RetryBudget, downAgents: make(map[string]bool)}
+	var failures = make([]WriteFailure, 0)
+	for _, service := range getUnreachable(services, reachable) {
+		failures = append(failures, WriteFailure{Service: service, Error: "Agent at " + service.Address + " is unreachable"})
+	}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	var workers = c.newWorkerPool()
+	for _, s := range reachable {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(service Service) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			if failure := c.writeOne(operation, service, batch, newRequest); failure != nil {
+				lock.Lock()
+				failures = append(failures, *failure)
+				lock.Unlock()
+			}
+		}(s)
+	}
+	wg.Wait()
+	return failures
+}
+
+// writeOne sends the request of the service and returns the failure, or nil
+// when the write succeeded. The rejected requests are not sent again.
+func (c *Client) writeOne(operation string, service Service, batch *writeBatch, newRequest func(Service) *http.Request) *WriteFailure {
+	for attempt := 0; ; attempt++ {
+		if batch.isDown(service.Address) {
+			log.Printf("Skipping the %s request of %s, the agent at %s is unreachable", operation, GetServiceID(service), service.Address)
+			return &WriteFailure{Service: service, Error: "Agent at " + service.Address + " is unreachable"}
+		}
+		err := c.send(newRequest(service), operation)
+		if err == nil {
+			return nil
+		}
+		log.Println(err.Error())
+		if !isRetryable(err) {
+			return &WriteFailure{Service: service, Error: err.Error(), Rejected: true}
+		}
+		if attempt+1 >= MAX_WRITE_ATTEMPTS || !batch.takeRetry() {
+			log.Printf("Giving up the %s requests to the agent at %s", operation, service.Address)
+			batch.markDown(service.Address)
+			return &WriteFailure{Service: service, Error: err.Error()}
+		}
+		time.Sleep(RETRY_DELAY * time.Duration(attempt+1))
+	}
+}
+
+// getUnreachable returns the services left out by the agent probes.
+func getUnreachable(services []Service, reachable []Service) []Service {
+	var unreachable = make([]Service, 0)
+	if len(services) == len(reachable) {
+		return unreachable
+	}
+	var written = make(map[string]bool, len(reachable))
+	for _, service := range reachable {
+		written[GetServiceID(service)+"@"+service.Address] = true
+	}
+	for _, service := range services {
+		if !written[GetServiceID(service)+"@"+service.Address] {
+			unreachable = append(unreachable, service)
+		}
+	}
+	return unreachable
+}
+
+// GetServiceID returns the ID of a registration, or the ServiceID of a catalog
+// entry.
+func GetServiceID(service Service) string {
+	if len(service.ServiceID) > 0 {
+		return service.ServiceID
+	}
+	return service.ID
+}
+
+type retryableError struct {
+	error
+}
+
+func isRetryable(err error) bool {
+	_, ok := err.(retryableError)
+	return ok
+}
+
+// send executes the write request. Transport errors and server errors are
+// retryable, the invalid requests are not.
+func (c *Client) send(req *http.Request, operation string) error {
+	resp, err := c.do(req, operation)
+	if err != nil {
+		metrics.CountError(metrics.ERROR_CONSUL_AGENT_UNREACHABLE)
+		return retryableError{err}
+	}
+	defer httpclient.CloseBody(resp)
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		metrics.CountError(metrics.ERROR_CONSUL_SERVER)
+		return retryableError{errors.New("Failed " + operation + " request, status: " + strconv.Itoa(resp.StatusCode) + " " + string(respBody))}
+	}
+	if len(respBody) > 0 {
+		if operation == "deregister" {
+			metrics.CountError(metrics.ERROR_DEREGISTER_REJECTED)
+		} else {
+			metrics.CountError(metrics.ERROR_REGISTER_REJECTED)
+		}
+		return errors.New("Invalid " + operation + " request: " + string(respBody))
+	}
+	return nil
+}
+
+func (b *writeBatch) takeRetry() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.budget <= 0 {
+		return false
+	}
+	b.budget--
+	return true
+}
+
+func (b *writeBatch) markDown(address string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.downAgents[address] = true
+}
+
+func (b *writeBatch) isDown(address string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.downAgents[address]
+}
+
+// decode counts the responses which cannot be decoded.
+func decode(decoder *json.Decoder, v interface{}) error {
+	if err := decoder.Decode(v); err != nil {
+		metrics.CountError(metrics.ERROR_DECODE)
+		return err
+	}
+	return nil
+}
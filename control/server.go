@@ -0,0 +1,116 @@
+// Package control serves the control API, the Control gRPC service defined in
+// control.proto, to drive and query a running service registration. The
+// service is only served over TLS, to clients presenting a certificate signed
+// by the client CA.
+//
+// control.pb.go and control_grpc.pb.go are generated from control.proto with
+// protoc-gen-go and protoc-gen-go-grpc.
+package control
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/hortonworks/cloudbreak-service-registration/admin"
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+// Controller is implemented by the running service registration.
+type Controller interface {
+	Components() []topology.HostComponent
+	Registrations() []consul.Service
+	TriggerSync()
+	SetPaused(paused bool)
+	IsPaused() bool
+}
+
+type controlServer struct {
+	UnimplementedControlServer
+	controller Controller
+}
+
+func (s *controlServer) ListComponents(ctx context.Context, request *ListComponentsRequest) (*ListComponentsResponse, error) {
+	var response = &ListComponentsResponse{}
+	for _, c := range s.controller.Components() {
+		response.Components = append(response.Components, &Component{
+			Hostname:    c.Hostname,
+			Ip:          c.IP,
+			Component:   c.HostComponent,
+			Service:     c.Service,
+			State:       c.State,
+			Cluster:     c.Cluster,
+			Rack:        c.Rack,
+			Alias:       c.Alias,
+			Static:      c.Static,
+			Maintenance: c.Maintenance,
+		})
+	}
+	return response, nil
+}
+
+func (s *controlServer) ListRegistrations(ctx context.Context, request *ListRegistrationsRequest) (*ListRegistrationsResponse, error) {
+	var response = &ListRegistrationsResponse{}
+	for _, r := range s.controller.Registrations() {
+		response.Registrations = append(response.Registrations, &Registration{
+			Id:      r.ServiceID,
+			Name:    r.ServiceName,
+			Address: r.Address,
+			Port:    r.ServicePort,
+			Tags:    r.ServiceTags,
+			Meta:    r.ServiceMeta,
+		})
+	}
+	return response, nil
+}
+
+func (s *controlServer) TriggerSync(ctx context.Context, request *TriggerSyncRequest) (*TriggerSyncResponse, error) {
+	s.controller.TriggerSync()
+	return &TriggerSyncResponse{}, nil
+}
+
+func (s *controlServer) SetPaused(ctx context.Context, request *SetPausedRequest) (*SetPausedResponse, error) {
+	s.controller.SetPaused(request.Paused)
+	return &SetPausedResponse{Paused: s.controller.IsPaused()}, nil
+}
+
+// NewHandler creates the gRPC server of the Control service. It is served
+// through net/http, which needs TLS to negotiate HTTP/2.
+func NewHandler(controller Controller) *grpc.Server {
+	server := grpc.NewServer()
+	RegisterControlServer(server, &controlServer{controller: controller})
+	return server
+}
+
+// NewServer creates the control API server, which only accepts clients
+// presenting a certificate signed by the client CA.
+func NewServer(address string, tlsConfig admin.TLSConfig, controller Controller) (*http.Server, error) {
+	if len(tlsConfig.ClientCAFile) == 0 {
+		return nil, errors.New("Control API requires a client CA")
+	}
+	server := &http.Server{
+		Addr:         address,
+		Handler:      NewHandler(controller),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	if err := admin.EnableTLS(server, tlsConfig); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// Serve serves the control API in the background.
+func Serve(server *http.Server) {
+	go func() {
+		log.Println("Control API listening on: " + server.Addr)
+		if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+			log.Println("Control API stopped: " + err.Error())
+		}
+	}()
+}
@@ -0,0 +1,84 @@
+package control
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+type fakeController struct {
+	synced int
+	paused bool
+}
+
+func (c *fakeController) Components() []topology.HostComponent {
+	return []topology.HostComponent{{Hostname: "host1", IP: "10.0.0.1", HostComponent: "DATANODE", Service: "HDFS", State: "STARTED"}}
+}
+
+func (c *fakeController) Registrations() []consul.Service {
+	return []consul.Service{{ServiceID: "datanode.host1", ServiceName: "datanode", Address: "10.0.0.1", ServiceTags: []string{"ambari"}}}
+}
+
+func (c *fakeController) TriggerSync() {
+	c.synced++
+}
+
+func (c *fakeController) SetPaused(paused bool) {
+	c.paused = paused
+}
+
+func (c *fakeController) IsPaused() bool {
+	return c.paused
+}
+
+func TestControlServiceOverTLS(t *testing.T) {
+	controller := &fakeController{}
+	server := httptest.NewUnstartedServer(NewHandler(controller))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	tlsConfig := server.Client().Transport.(*http.Transport).TLSClientConfig
+	conn, err := grpc.NewClient(server.Listener.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewControlClient(conn)
+	ctx := context.Background()
+
+	components, err := client.ListComponents(ctx, &ListComponentsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(components.Components) != 1 || components.Components[0].Component != "DATANODE" || components.Components[0].State != "STARTED" {
+		t.Errorf("Unexpected components: %v", components.Components)
+	}
+	registrations, err := client.ListRegistrations(ctx, &ListRegistrationsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(registrations.Registrations) != 1 || registrations.Registrations[0].Id != "datanode.host1" {
+		t.Errorf("Unexpected registrations: %v", registrations.Registrations)
+	}
+	if _, err := client.TriggerSync(ctx, &TriggerSyncRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if controller.synced != 1 {
+		t.Errorf("Expected a triggered sync, got: %d", controller.synced)
+	}
+	paused, err := client.SetPaused(ctx, &SetPausedRequest{Paused: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !paused.Paused || !controller.paused {
+		t.Error("Expected the reconciliation to be paused")
+	}
+}
@@ -0,0 +1,142 @@
+// Package docker lists the labelled containers of the local Docker daemon as
+// host components, so they are registered together with the Ambari
+// components.
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+const (
+	DEFAULT_SOCKET       = "/var/run/docker.sock"
+	DEFAULT_LABEL_PREFIX = "service-registration"
+	SERVICE_NAME         = "DOCKER"
+	STARTED_STATE        = "STARTED"
+	REQUEST_TIMEOUT      = 10 * time.Second
+)
+
+type container struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Labels map[string]string `json:"Labels"`
+	State  string            `json:"State"`
+	Ports  []struct {
+		PrivatePort int64  `json:"PrivatePort"`
+		PublicPort  int64  `json:"PublicPort"`
+		Type        string `json:"Type"`
+	} `json:"Ports"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress string `json:"IPAddress"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// Source lists the running containers having the <prefix>.name label. The
+// <prefix>.port label selects the container port, which is registered with
+// its published host port if there is one. Containers are registered with
+// HostIP when it is set, otherwise with their own network address.
+type Source struct {
+	HTTP        *http.Client
+	LabelPrefix string
+	HostIP      string
+	Hostname    string
+}
+
+func NewSource(socket string) *Source {
+	if len(socket) == 0 {
+		socket = DEFAULT_SOCKET
+	}
+	hostname, _ := os.Hostname()
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &Source{
+		HTTP:        &http.Client{Timeout: REQUEST_TIMEOUT, Transport: transport},
+		LabelPrefix: DEFAULT_LABEL_PREFIX,
+		Hostname:    hostname,
+	}
+}
+
+func (s *Source) ListComponents(ctx context.Context) ([]topology.HostComponent, error) {
+	req, _ := http.NewRequest("GET", "http://docker/containers/json", nil)
+	resp, err := s.HTTP.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer httpclient.CloseBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Failed to list the Docker containers, status: " + strconv.Itoa(resp.StatusCode))
+	}
+	var containers []container
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return nil, err
+	}
+
+	var components = make([]topology.HostComponent, 0)
+	for _, c := range containers {
+		name := c.Labels[s.LabelPrefix+".name"]
+		if len(name) == 0 || c.State != "running" {
+			continue
+		}
+		component := topology.HostComponent{
+			Hostname:      s.Hostname,
+			IP:            s.HostIP,
+			HostComponent: name,
+			Service:       SERVICE_NAME,
+			State:         STARTED_STATE,
+		}
+		port, published := s.getPort(c)
+		component.Port = port
+		if len(component.IP) == 0 || (port > 0 && !published) {
+			component.IP = getContainerIP(c)
+		}
+		components = append(components, component)
+	}
+	log.Printf("Found labelled Docker containers: %d", len(components))
+	return components, nil
+}
+
+// getPort returns the port of the container and whether it is published on
+// the host.
+func (s *Source) getPort(c container) (int64, bool) {
+	value := c.Labels[s.LabelPrefix+".port"]
+	if len(value) == 0 {
+		return 0, false
+	}
+	port, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Printf("Invalid port label on container %s: %s", c.ID, value)
+		return 0, false
+	}
+	for _, p := range c.Ports {
+		if p.PrivatePort == port && p.PublicPort > 0 && strings.ToLower(p.Type) == "tcp" {
+			return p.PublicPort, true
+		}
+	}
+	return port, false
+}
+
+func getContainerIP(c container) string {
+	for _, network := range c.NetworkSettings.Networks {
+		if len(network.IPAddress) > 0 {
+			return network.IPAddress
+		}
+	}
+	return ""
+}
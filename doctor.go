@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/ambari"
+	"github.com/hortonworks/cloudbreak-service-registration/config"
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+)
+
+const (
+	MAX_CLOCK_SKEW = 10 * time.Second
+	DOCTOR_TIMEOUT = 2 * time.Minute
+	DOCTOR_WORKERS = 10
+	DNS_TIMEOUT    = 3 * time.Second
+)
+
+// doctorReport prints the result of every check as it completes and
+// remembers whether any of them failed.
+type doctorReport struct {
+	failed bool
+}
+
+func (r *doctorReport) check(name string, err error, detail string) bool {
+	if err != nil {
+		r.failed = true
+		fmt.Printf("FAIL  %s: %s\n", name, err.Error())
+		return false
+	}
+	fmt.Printf("PASS  %s: %s\n", name, detail)
+	return true
+}
+
+func (r *doctorReport) checkSkew(name string, remote time.Time, local time.Time) {
+	if remote.IsZero() {
+		fmt.Printf("SKIP  %s: no Date header in the response\n", name)
+		return
+	}
+	skew := remote.Sub(local).Round(time.Second)
+	if skew > MAX_CLOCK_SKEW || -skew > MAX_CLOCK_SKEW {
+		r.check(name, fmt.Errorf("%s, more than %s", skew, MAX_CLOCK_SKEW), "")
+		return
+	}
+	r.check(name, nil, skew.String())
+}
+
+// runDoctor checks the config, the Ambari pillar and credentials, the local
+// Consul agent, then the Consul agents and their DNS interface on every
+// Ambari host, together with the clock skews. It returns whether every check
+// passed.
+func runDoctor(profile string, consulClient *consul.Client) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), DOCTOR_TIMEOUT)
+	defer cancel()
+	report := &doctorReport{}
+
+	_, err := config.LoadProfile(getConfigPath(), profile)
+	report.check("Config file", err, getConfigPath())
+
+	credentialsPath := getAmbariCredentialsPath()
+	credentials, err := ambari.LoadCredentials(credentialsPath)
+	if report.check("Ambari pillar", err, credentialsPath) {
+		client := newAmbariClient(credentials.Config.Username, credentials.Config.Password)
+		start := time.Now()
+		serverTime, err := client.Ping(ctx)
+		if report.check("Ambari authentication", err, "authenticated as "+credentials.Config.Username+" on "+client.ServerURL) {
+			report.checkSkew("Clock skew to Ambari", serverTime, start)
+			checkAgents(ctx, report, client, consulClient)
+		}
+	}
+
+	start := time.Now()
+	agentTime, err := consulClient.ProbeAgent("")
+	if report.check("Local Consul agent", err, consulClient.BaseURL) {
+		report.checkSkew("Clock skew to the local Consul agent", agentTime, start)
+	}
+	dnsCtx, dnsCancel := context.WithTimeout(ctx, DNS_TIMEOUT)
+	report.check("Local Consul DNS", consul.CheckDNS(dnsCtx, "127.0.0.1", consul.DEFAULT_DNS_PORT), "port "+consul.DEFAULT_DNS_PORT)
+	dnsCancel()
+
+	if report.failed {
+		fmt.Println("Some checks failed")
+	} else {
+		fmt.Println("Every check passed")
+	}
+	return !report.failed
+}
+
+// checkAgents probes the Consul agent and its DNS port on every Ambari host
+// in parallel, and reports the failures per host.
+func checkAgents(ctx context.Context, report *doctorReport, ambariClient *ambari.Client, consulClient *consul.Client) {
+	hosts, err := ambariClient.GetHosts(ctx)
+	if !report.check("Ambari hosts", err, fmt.Sprintf("%d hosts", len(hosts))) {
+		return
+	}
+	var hostnames = make([]string, 0, len(hosts))
+	for hostname, host := range hosts {
+		if len(host.IP) > 0 {
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	sort.Strings(hostnames)
+
+	type result struct {
+		agentErr error
+		dnsErr   error
+		skew     time.Duration
+	}
+	var wg sync.WaitGroup
+	var results = make([]result, len(hostnames))
+	var workers = make(chan struct{}, DOCTOR_WORKERS)
+	for i, hostname := range hostnames {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(i int, address string) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			start := time.Now()
+			agentTime, err := consulClient.ProbeAgent(address)
+			results[i].agentErr = err
+			if err == nil && !agentTime.IsZero() {
+				results[i].skew = agentTime.Sub(start).Round(time.Second)
+			}
+			dnsCtx, cancel := context.WithTimeout(ctx, DNS_TIMEOUT)
+			defer cancel()
+			results[i].dnsErr = consul.CheckDNS(dnsCtx, address, consul.DEFAULT_DNS_PORT)
+		}(i, hosts[hostname].IP)
+	}
+	wg.Wait()
+
+	agents, dns, skewed := 0, 0, 0
+	for i, hostname := range hostnames {
+		if results[i].agentErr == nil {
+			agents++
+		} else {
+			report.check("Consul agent on "+hostname, results[i].agentErr, "")
+		}
+		if results[i].dnsErr == nil {
+			dns++
+		} else {
+			report.check("Consul DNS on "+hostname, results[i].dnsErr, "")
+		}
+		if skew := results[i].skew; skew > MAX_CLOCK_SKEW || -skew > MAX_CLOCK_SKEW {
+			skewed++
+			report.check("Clock skew to "+hostname, fmt.Errorf("%s, more than %s", skew, MAX_CLOCK_SKEW), "")
+		}
+	}
+	if agents == len(hostnames) {
+		report.check("Consul agents", nil, fmt.Sprintf("%d of %d hosts reachable", agents, len(hostnames)))
+	}
+	if dns == len(hostnames) {
+		report.check("Consul DNS", nil, fmt.Sprintf("%d of %d hosts answering on port %s", dns, len(hostnames), consul.DEFAULT_DNS_PORT))
+	}
+	if skewed == 0 {
+		report.check("Clock skew to the agents", nil, fmt.Sprintf("within %s on every host", MAX_CLOCK_SKEW))
+	}
+}
@@ -0,0 +1,94 @@
+// Package exechook runs the external commands configured for the lifecycle
+// events of the reconciliation.
+package exechook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/config"
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+	"github.com/hortonworks/cloudbreak-service-registration/reconciler"
+)
+
+const (
+	EVENT_PRE_SYNC        = "pre-sync"
+	EVENT_POST_REGISTER   = "post-register"
+	EVENT_POST_DEREGISTER = "post-deregister"
+	DEFAULT_TIMEOUT       = 30 * time.Second
+)
+
+// Payload is written to the standard input of the command as JSON. The event
+// and the number of services are passed in the environment too.
+type Payload struct {
+	Event    string           `json:"event"`
+	Services []consul.Service `json:"services,omitempty"`
+}
+
+// New returns the reconciler hooks running the configured commands and firing
+// the Salt events. A failing command is logged and doesn't stop the
+// reconciliation.
+func New(hooks config.ExecHooks) reconciler.Hooks {
+	var result reconciler.Hooks
+	if len(hooks.PreSync) > 0 {
+		result.PreSync = func(ctx context.Context) {
+			runAll(ctx, hooks.PreSync, Payload{Event: EVENT_PRE_SYNC})
+		}
+	}
+	if len(hooks.PostRegister) > 0 || hooks.SaltEvent.Enabled {
+		result.PostRegister = func(services []consul.Service) {
+			runAll(context.Background(), hooks.PostRegister, Payload{Event: EVENT_POST_REGISTER, Services: services})
+			if hooks.SaltEvent.Enabled {
+				fireSaltEvent(hooks.SaltEvent, EVENT_POST_REGISTER, services)
+			}
+		}
+	}
+	if len(hooks.PostDeregister) > 0 || hooks.SaltEvent.Enabled {
+		result.PostDeregister = func(services []consul.Service) {
+			runAll(context.Background(), hooks.PostDeregister, Payload{Event: EVENT_POST_DEREGISTER, Services: services})
+			if hooks.SaltEvent.Enabled {
+				fireSaltEvent(hooks.SaltEvent, EVENT_POST_DEREGISTER, services)
+			}
+		}
+	}
+	return result
+}
+
+func runAll(ctx context.Context, hooks []config.ExecHook, payload Payload) {
+	for _, hook := range hooks {
+		if err := run(ctx, hook, payload); err != nil {
+			log.Printf("Failed to run the %s hook %s: %s", payload.Event, strings.Join(hook.Command, " "), err.Error())
+		}
+	}
+}
+
+func run(ctx context.Context, hook config.ExecHook, payload Payload) error {
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_TIMEOUT
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"SERVICE_REGISTRATION_EVENT="+payload.Event,
+		"SERVICE_REGISTRATION_SERVICE_COUNT="+strconv.Itoa(len(payload.Services)))
+	output, err := cmd.CombinedOutput()
+	if len(output) > 0 {
+		log.Printf("Output of the %s hook: %s", payload.Event, strings.TrimSpace(string(output)))
+	}
+	return err
+}
@@ -0,0 +1,45 @@
+package exechook
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/hortonworks/cloudbreak-service-registration/config"
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+)
+
+const DEFAULT_SALT_EVENT_TAG = "cloudbreak/service-registration"
+
+var DEFAULT_SALT_CALL_COMMAND = []string{"salt-call", "event.send"}
+
+type saltEventData struct {
+	Event    string   `json:"event"`
+	Services []string `json:"services"`
+}
+
+// fireSaltEvent sends the IDs of the changed services as the event data, the
+// complete registrations could exceed the command line limits.
+func fireSaltEvent(conf config.SaltEvent, event string, services []consul.Service) {
+	tag := conf.Tag
+	if len(tag) == 0 {
+		tag = DEFAULT_SALT_EVENT_TAG
+	}
+	command := conf.Command
+	if len(command) == 0 {
+		command = DEFAULT_SALT_CALL_COMMAND
+	}
+	var data = saltEventData{Event: event, Services: make([]string, 0, len(services))}
+	for _, service := range services {
+		data.Services = append(data.Services, consul.GetServiceID(service))
+	}
+	body, _ := json.Marshal(data)
+
+	hook := config.ExecHook{
+		Command: append(append([]string{}, command...), tag+"/"+event, string(body)),
+		Timeout: conf.Timeout,
+	}
+	if err := run(context.Background(), hook, Payload{Event: event, Services: services}); err != nil {
+		log.Printf("Failed to fire the %s Salt event: %s", event, err.Error())
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/hortonworks/cloudbreak-service-registration/ambari"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+// exportTopology lists the components once and prints the topology to the
+// standard output as JSON, or as Graphviz DOT when the format is dot.
+func exportTopology(format string) error {
+	if format != "" && format != "json" && format != "dot" {
+		return errors.New("Unknown topology format: " + format)
+	}
+	source := ambari.NewSource(createAmbariClient())
+	components, err := source.ListComponents(context.Background())
+	if err != nil {
+		return err
+	}
+	hosts := topology.Export(components, source.Hosts())
+	if format == "dot" {
+		return topology.WriteDOT(os.Stdout, hosts)
+	}
+	return topology.WriteJSON(os.Stdout, hosts)
+}
@@ -0,0 +1,64 @@
+package httpclient
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// Faults configures the faults injected into the requests of a client, to
+// verify the failure handling in staging. Each rate is the probability of the
+// fault per request.
+type Faults struct {
+	Delay         time.Duration `yaml:"delay"`
+	DelayRate     float64       `yaml:"delay_rate"`
+	ResetRate     float64       `yaml:"reset_rate"`
+	MalformedRate float64       `yaml:"malformed_rate"`
+}
+
+func (f Faults) IsEnabled() bool {
+	return f.DelayRate > 0 || f.ResetRate > 0 || f.MalformedRate > 0
+}
+
+type faultTransport struct {
+	transport http.RoundTripper
+	faults    Faults
+}
+
+// InjectFaults wraps the transport of the client with the fault injection.
+func InjectFaults(client *http.Client, name string, faults Faults) {
+	if !faults.IsEnabled() {
+		return
+	}
+	log.Printf("WARNING: injecting faults into the %s requests: %+v", name, faults)
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	client.Transport = &faultTransport{transport: transport, faults: faults}
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rand.Float64() < t.faults.DelayRate {
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(t.faults.Delay):
+		}
+	}
+	if rand.Float64() < t.faults.ResetRate {
+		return nil, errors.New("Injected fault: connection reset by peer")
+	}
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil || rand.Float64() >= t.faults.MalformedRate {
+		return resp, err
+	}
+	CloseBody(resp)
+	resp.Body = ioutil.NopCloser(bytes.NewReader([]byte("<html>injected fault")))
+	resp.ContentLength = -1
+	return resp, nil
+}
@@ -0,0 +1,40 @@
+package httpclient
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// New creates a client keeping the connections alive. HTTP/2 is negotiated
+// with the TLS servers, so the requests to them share one multiplexed
+// connection. The requests are sent with the UserAgent and the request ID of
+// their context.
+func New(timeout time.Duration, maxIdleConnsPerHost int) *http.Client {
+	transport := newTransport(timeout, maxIdleConnsPerHost)
+	return &http.Client{Timeout: timeout, Transport: &identifyingTransport{transport: transport}}
+}
+
+func newTransport(timeout time.Duration, maxIdleConnsPerHost int) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   timeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: maxIdleConnsPerHost,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: timeout,
+		ForceAttemptHTTP2:   true,
+	}
+}
+
+// CloseBody drains and closes the response body, so the underlying connection
+// can be reused by the transport.
+func CloseBody(resp *http.Response) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
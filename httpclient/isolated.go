@@ -0,0 +1,64 @@
+package httpclient
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// hostTransport keeps a separate transport per target host, so a slow target
+// can only hold its own connections. The connections of a target are bounded
+// by maxConnsPerHost, and its dials and response headers are waited for at
+// most the timeout of the target.
+type hostTransport struct {
+	lock            sync.Mutex
+	transports      map[string]*http.Transport
+	maxConnsPerHost int
+	targetTimeout   func(host string) time.Duration
+	options         []func(*http.Transport)
+}
+
+// NewIsolated creates a client like New, except every target host is called
+// through its own transport. The timeout bounds the whole request, the
+// targetTimeout of the host the connection and the response headers.
+func NewIsolated(timeout time.Duration, maxConnsPerHost int, targetTimeout func(host string) time.Duration) *http.Client {
+	transport := &hostTransport{
+		transports:      make(map[string]*http.Transport),
+		maxConnsPerHost: maxConnsPerHost,
+		targetTimeout:   targetTimeout,
+	}
+	return &http.Client{Timeout: timeout, Transport: &identifyingTransport{transport: transport}}
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.getTransport(req).RoundTrip(req)
+}
+
+func (t *hostTransport) getTransport(req *http.Request) *http.Transport {
+	key := req.URL.Scheme + "://" + req.URL.Host
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	transport, ok := t.transports[key]
+	if !ok {
+		timeout := t.targetTimeout(req.URL.Hostname())
+		transport = newTransport(timeout, t.maxConnsPerHost)
+		transport.MaxConnsPerHost = t.maxConnsPerHost
+		transport.ResponseHeaderTimeout = timeout
+		for _, option := range t.options {
+			option(transport)
+		}
+		t.transports[key] = transport
+	}
+	return transport
+}
+
+// configure applies the option to the transport of every target, also to the
+// transports created later.
+func (t *hostTransport) configure(option func(*http.Transport)) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.options = append(t.options, option)
+	for _, transport := range t.transports {
+		option(transport)
+	}
+}
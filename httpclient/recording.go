@@ -0,0 +1,144 @@
+package httpclient
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Exchange is a recorded request and its response, the recordings are JSON
+// lines of exchanges.
+type Exchange struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	RequestBody string      `json:"request_body,omitempty"`
+	StatusCode  int         `json:"status_code"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        string      `json:"body"`
+}
+
+func (e Exchange) key() string {
+	return e.Method + " " + e.URL
+}
+
+type recordingTransport struct {
+	transport http.RoundTripper
+	lock      sync.Mutex
+	file      *os.File
+}
+
+// Record appends the exchanges of the client to the file. Only the response
+// headers are recorded, so the credentials of the requests are not.
+func Record(client *http.Client, path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	client.Transport = &recordingTransport{transport: transport, file: file}
+	return nil
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	if req.Body != nil {
+		requestBody, _ = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(requestBody))
+	}
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	line, _ := json.Marshal(Exchange{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		RequestBody: string(requestBody),
+		StatusCode:  resp.StatusCode,
+		Header:      resp.Header,
+		Body:        string(body),
+	})
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.file.Write(append(line, '\n'))
+	return resp, nil
+}
+
+type replayTransport struct {
+	lock      sync.Mutex
+	exchanges map[string][]Exchange
+	last      map[string]Exchange
+}
+
+// Replay answers the requests of the client from a recording. The exchanges of
+// the same method and URL are replayed in order and the last one is repeated,
+// the unknown requests fail.
+func Replay(client *http.Client, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	transport := &replayTransport{exchanges: make(map[string][]Exchange), last: make(map[string]Exchange)}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var exchange Exchange
+		if err := json.Unmarshal(scanner.Bytes(), &exchange); err != nil {
+			return errors.New("Invalid recording " + path + ": " + err.Error())
+		}
+		transport.exchanges[exchange.key()] = append(transport.exchanges[exchange.key()], exchange)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	client.Transport = transport
+	return nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	key := req.Method + " " + req.URL.String()
+	t.lock.Lock()
+	exchange, ok := t.last[key]
+	if queue := t.exchanges[key]; len(queue) > 0 {
+		exchange, ok = queue[0], true
+		t.exchanges[key] = queue[1:]
+		t.last[key] = exchange
+	}
+	t.lock.Unlock()
+	if !ok {
+		return nil, errors.New("No recorded response for: " + key)
+	}
+	header := exchange.Header
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        http.StatusText(exchange.StatusCode),
+		StatusCode:    exchange.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(exchange.Body))),
+		ContentLength: int64(len(exchange.Body)),
+		Request:       req,
+	}, nil
+}
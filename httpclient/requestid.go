@@ -0,0 +1,49 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+// UserAgent is sent with every request of the clients created by New, it is
+// set to app/version at startup.
+var UserAgent = "cloudbreak-service-registration"
+
+type requestIDKey struct{}
+
+// NewRequestID returns a random ID correlating the requests of a cycle.
+func NewRequestID() string {
+	var id = make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// identifyingTransport sets the User-Agent and the request ID of the request
+// context on the outgoing requests.
+type identifyingTransport struct {
+	transport http.RoundTripper
+}
+
+func (t *identifyingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if len(req.Header.Get("User-Agent")) == 0 {
+		req.Header.Set("User-Agent", UserAgent)
+	}
+	if id := RequestID(req.Context()); len(id) > 0 {
+		req.Header.Set(REQUEST_ID_HEADER, id)
+	}
+	return t.transport.RoundTrip(req)
+}
@@ -0,0 +1,44 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+// TrustCABundle makes the client verify the servers with the PEM certificates
+// of the bundle besides the system roots. It applies to the clients created
+// by New and NewIsolated, and has to be called before their transport is
+// wrapped. An empty path keeps the system roots only.
+func TrustCABundle(client *http.Client, path string) error {
+	if len(path) == 0 {
+		return nil
+	}
+	roots, err := LoadCABundle(path)
+	if err != nil {
+		return err
+	}
+	tlsConfig := &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	return configureTransport(client, func(transport *http.Transport) {
+		transport.TLSClientConfig = tlsConfig.Clone()
+	})
+}
+
+// LoadCABundle returns the system roots extended with the certificates of the
+// bundle.
+func LoadCABundle(path string) (*x509.CertPool, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(content) {
+		return nil, errors.New("No certificates found in the CA bundle: " + path)
+	}
+	return roots, nil
+}
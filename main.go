@@ -1,31 +1,105 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
 	"gopkg.in/natefinch/lumberjack.v2"
-	"gopkg.in/yaml.v2"
-	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/admin"
+	"github.com/hortonworks/cloudbreak-service-registration/ambari"
+	"github.com/hortonworks/cloudbreak-service-registration/backend"
+	"github.com/hortonworks/cloudbreak-service-registration/backend/azuredns"
+	"github.com/hortonworks/cloudbreak-service-registration/backend/clouddns"
+	"github.com/hortonworks/cloudbreak-service-registration/backend/envoy"
+	"github.com/hortonworks/cloudbreak-service-registration/backend/etcd"
+	"github.com/hortonworks/cloudbreak-service-registration/backend/route53"
+	"github.com/hortonworks/cloudbreak-service-registration/backend/traefik"
+	"github.com/hortonworks/cloudbreak-service-registration/backend/zonefile"
+	"github.com/hortonworks/cloudbreak-service-registration/backend/zookeeper"
+	"github.com/hortonworks/cloudbreak-service-registration/cloudbreak"
+	"github.com/hortonworks/cloudbreak-service-registration/config"
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+	"github.com/hortonworks/cloudbreak-service-registration/control"
+	"github.com/hortonworks/cloudbreak-service-registration/docker"
+	"github.com/hortonworks/cloudbreak-service-registration/exechook"
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+	"github.com/hortonworks/cloudbreak-service-registration/notify"
+	"github.com/hortonworks/cloudbreak-service-registration/reconciler"
+	"github.com/hortonworks/cloudbreak-service-registration/registration"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
 )
 
 const (
-	ENV_AMBARI_CREDENTIALS_PATH         = "AMBARI_CREDENTIALS_PATH"
-	ENV_SERVICE_CHECK_POLL_INTERVAL     = "SERVICE_CHECK_POLL_INTERVAL"
-	ENV_AMBARI_ADDRESS                  = "AMBARI_ADDRESS"
-	DEFAULT_AMBARI_ADDRESS              = "ambari-server"
-	DEFAULT_AMBARI_CREDENTIALS_PATH     = "/srv/pillar/ambari/credentials.sls"
-	AMBARI_CONSUL_SERVICE_TAG           = "ambari"
-	DEFAULT_SERVICE_CHECK_POLL_INTERVAL = 10 * time.Second
-	REQUEST_SLEEP_TIME                  = 5 * time.Second
-	REQUEST_TIMEOUT                     = DEFAULT_SERVICE_CHECK_POLL_INTERVAL
+	ENV_AMBARI_CREDENTIALS_PATH             = "AMBARI_CREDENTIALS_PATH"
+	ENV_SERVICE_CHECK_POLL_INTERVAL         = "SERVICE_CHECK_POLL_INTERVAL"
+	ENV_AMBARI_ADDRESS                      = "AMBARI_ADDRESS"
+	ENV_DEREGISTER_ON_SHUTDOWN              = "DEREGISTER_ON_SHUTDOWN"
+	ENV_CONSUL_WORKER_POOL_SIZE             = "CONSUL_WORKER_POOL_SIZE"
+	ENV_SERVICE_CHECK_POLL_JITTER           = "SERVICE_CHECK_POLL_JITTER"
+	ENV_SERVICE_CHECK_MIN_POLL_INTERVAL     = "SERVICE_CHECK_MIN_POLL_INTERVAL"
+	ENV_SERVICE_CHECK_MAX_POLL_INTERVAL     = "SERVICE_CHECK_MAX_POLL_INTERVAL"
+	ENV_SERVICE_CHECK_MAX_BACKOFF           = "SERVICE_CHECK_MAX_BACKOFF"
+	ENV_STATE_FILE_PATH                     = "STATE_FILE_PATH"
+	ENV_RETRY_QUEUE_PATH                    = "RETRY_QUEUE_PATH"
+	ENV_OUTBOX_PATH                         = "OUTBOX_PATH"
+	ENV_LOG_FILE_PATH                       = "LOG_FILE_PATH"
+	ENV_PID_FILE_PATH                       = "PID_FILE_PATH"
+	ENV_AMBARI_PAGE_SIZE                    = "AMBARI_PAGE_SIZE"
+	ENV_AMBARI_SERVER_URL                   = "AMBARI_SERVER_URL"
+	ENV_RECORD_DIR                          = "RECORD_DIR"
+	ENV_REPLAY_DIR                          = "REPLAY_DIR"
+	ENV_LEADER_ELECTION_KEY                 = "LEADER_ELECTION_KEY"
+	ENV_HEARTBEAT_KEY                       = "HEARTBEAT_KEY"
+	ENV_CLUSTER_LOCK_PREFIX                 = "CLUSTER_LOCK_PREFIX"
+	ENV_CONFIG_PATH                         = "SERVICE_REGISTRATION_CONFIG_PATH"
+	ENV_CONFIG_PROFILE                      = "SERVICE_REGISTRATION_PROFILE"
+	PROFILE_FLAG                            = "--profile="
+	ENV_HOST_FULL_REFRESH_CYCLES            = "HOST_FULL_REFRESH_CYCLES"
+	ENV_CONTROL_API_ADDRESS                 = "CONTROL_API_ADDRESS"
+	ENV_CONTROL_API_CERT                    = "CONTROL_API_CERT"
+	ENV_CONTROL_API_KEY                     = "CONTROL_API_KEY"
+	ENV_CONTROL_API_CLIENT_CA               = "CONTROL_API_CLIENT_CA"
+	ENV_ADMIN_API_ADDRESS                   = "ADMIN_API_ADDRESS"
+	ENV_ADMIN_API_CERT                      = "ADMIN_API_CERT"
+	ENV_ADMIN_API_KEY                       = "ADMIN_API_KEY"
+	ENV_ADMIN_API_CLIENT_CA                 = "ADMIN_API_CLIENT_CA"
+	ENV_CONSUL_RETRY_BUDGET                 = "CONSUL_RETRY_BUDGET"
+	ENV_CONSUL_MAX_WRITES                   = "CONSUL_MAX_WRITES_PER_CYCLE"
+	ENV_CONSUL_STARTUP_TIMEOUT              = "CONSUL_STARTUP_TIMEOUT"
+	ENV_CONSUL_TOKEN                        = "CONSUL_HTTP_TOKEN"
+	ENV_CONSUL_DATACENTER                   = "CONSUL_DATACENTER"
+	ENV_CONSUL_AGENT_TOKENS_FILE            = "CONSUL_AGENT_TOKENS_FILE"
+	ENV_CONSUL_AGENT_TIMEOUT                = "CONSUL_AGENT_TIMEOUT"
+	ENV_AMBARI_REQUEST_TIMEOUT              = "AMBARI_REQUEST_TIMEOUT"
+	ENV_CA_BUNDLE                           = "CA_BUNDLE"
+	ENV_AMBARI_CA_BUNDLE                    = "AMBARI_CA_BUNDLE"
+	ENV_CONSUL_CA_BUNDLE                    = "CONSUL_CA_BUNDLE"
+	ENV_CLOUDBREAK_CA_BUNDLE                = "CLOUDBREAK_CA_BUNDLE"
+	ENV_BACKENDS_CA_BUNDLE                  = "BACKENDS_CA_BUNDLE"
+	ENV_AMBARI_PROXY                        = "AMBARI_PROXY"
+	ENV_CLOUDBREAK_PROXY                    = "CLOUDBREAK_PROXY"
+	ENV_NOTIFICATIONS_CA_BUNDLE             = "NOTIFICATIONS_CA_BUNDLE"
+	ENV_NOTIFICATIONS_PROXY                 = "NOTIFICATIONS_PROXY"
+	ENV_CLOUDBREAK_URL                      = "CLOUDBREAK_URL"
+	ENV_CLOUDBREAK_TOKEN                    = "CLOUDBREAK_TOKEN"
+	ENV_CLOUDBREAK_STACK_ID                 = "CLOUDBREAK_STACK_ID"
+	ENV_CLOUDBREAK_REFRESH_INTERVAL         = "CLOUDBREAK_REFRESH_INTERVAL"
+	DEFAULT_AMBARI_ADDRESS                  = "ambari-server"
+	DEFAULT_AMBARI_CREDENTIALS_PATH         = "/srv/pillar/ambari/credentials.sls"
+	DEFAULT_SERVICE_CHECK_POLL_INTERVAL     = 10 * time.Second
+	DEFAULT_SERVICE_CHECK_MIN_POLL_INTERVAL = 2 * time.Second
+	DEFAULT_SERVICE_CHECK_MAX_BACKOFF       = 5 * time.Minute
+	REQUEST_TIMEOUT                         = DEFAULT_SERVICE_CHECK_POLL_INTERVAL
+	AMBARI_MAX_IDLE_CONNS                   = 4
 )
 
 var (
@@ -34,153 +108,182 @@ var (
 	App       string
 )
 
-type Ambari struct {
-	Config struct {
-		Address  string `yaml:"server"`
-		Username string `yaml:"username"`
-		Password string `yaml:"password"`
-	} `yaml:"ambari"`
-}
-
-type ClusterResponse struct {
-	Items []struct {
-		Cluster struct {
-			Name string `json:"cluster_name"`
-		} `json:"Clusters"`
-	} `json:"items"`
-}
-
-type HostsResponse struct {
-	Items []struct {
-		Host struct {
-			HostName string `json:"host_name"`
-			IP       string `json:"ip"`
-		} `json:"Hosts"`
-	} `json:"items"`
-}
-
-type HostComponentsResponse struct {
-	Items []struct {
-		Host struct {
-			HostName string `json:"host_name"`
-		} `json:"Hosts"`
-		HostComponents []struct {
-			HostRole struct {
-				ComponentName string `json:"component_name"`
-				Hostname      string `json:"host_name"`
-				State         string `json:"state"`
-				Maintenance   string `json:"maintenance_state"`
-			} `json:"HostRoles"`
-		} `json:"host_components"`
-	} `json:"items"`
-}
-
-type RootHostComponentsResponse struct {
-	Items []struct {
-		Components []struct {
-			HostComponents []struct {
-				RootServiceHostComponents struct {
-					Name     string `json:"component_name"`
-					State    string `json:"component_state"`
-					Hostname string `json:"host_name"`
-				} `json:"RootServiceHostComponents"`
-			} `json:"hostComponents"`
-		} `json:"components"`
-	} `json:"items"`
-}
-
-type HostComponent struct {
-	Hostname      string
-	IP            string
-	HostComponent string
-	State         string
-}
-
-type ConsulService struct {
-	ID          string   `json:"ID"`
-	Name        string   `json:"Name,omitempty"`
-	Address     string   `json:"Address"`
-	Port        int64    `json:"Port"`
-	Tags        []string `json:"Tags"`
-	ServiceName string   `json:"ServiceName,omitempty"`
-	ServiceID   string   `json:"ServiceID,omitempty"`
-	ServiceTags []string `json:"ServiceTags,omitempty"`
-}
-
-func (c *ConsulService) Json() string {
-	j, _ := json.Marshal(c)
-	return string(j)
-}
-
 func main() {
+	profile := getProfile()
 	if len(os.Args) > 1 && strings.HasSuffix(os.Args[1], "version") {
 		fmt.Println("Version: " + Version + "-" + BuildTime)
 		return
 	}
 
 	setLogFile()
+	httpclient.UserAgent = App + "/" + Version
 
-	ambari := createAmbariConfig()
-	httpClient := &http.Client{Timeout: REQUEST_TIMEOUT}
-
-	var clusterName string = ""
-
-	for {
-		wait()
+	consulClient := createConsulClient()
 
-		var components = make([]HostComponent, 0)
-
-		hosts, err := getHosts(httpClient, ambari)
-		if err != nil {
-			log.Println("Failed to get the host list from Ambari: " + err.Error())
-			continue
+	if len(os.Args) > 1 && os.Args[1] == "cleanup" {
+		if err := runCleanup(profile, consulClient, os.Args[2:]); err != nil {
+			log.Println("Cleanup failed: " + err.Error())
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
 		}
+		return
+	}
 
-		if rootComponents, err := getRootHostComponents(httpClient, ambari, hosts); err != nil {
-			log.Println("Failed to get the root host components from Ambari: " + err.Error())
-			continue
-		} else {
-			for _, component := range rootComponents {
-				components = append(components, component)
-			}
+	if len(os.Args) > 1 && os.Args[1] == "topology" {
+		format := ""
+		if len(os.Args) > 2 {
+			format = os.Args[2]
 		}
+		if err := exportTopology(format); err != nil {
+			log.Println("Cannot export the topology: " + err.Error())
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
 
-		clusterFound := true
-		if len(clusterName) == 0 {
-			if clusterName, err = getClusterName(httpClient, ambari); err != nil {
-				log.Println("Cluster name cannot be determined: " + err.Error())
-				clusterFound = false
-			}
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		if !runDoctor(profile, consulClient) {
+			os.Exit(1)
 		}
-		if clusterFound {
-			hostComponents, err := getHostComponents(httpClient, ambari, clusterName, hosts)
-			if err != nil {
-				log.Println("Failed to get the host components from Ambari: " + err.Error())
-			} else {
-				for _, component := range hostComponents {
-					components = append(components, component)
-				}
-			}
+		return
+	}
+
+	if len(os.Args) > 1 && (os.Args[1] == "pause" || os.Args[1] == "resume") {
+		if err := setPaused(profile, os.Args[1] == "pause"); err != nil {
+			log.Println("Cannot " + os.Args[1] + " the service registration: " + err.Error())
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
 		}
+		return
+	}
 
-		consulServices, err := getConsulServices(httpClient)
+	pidFile, err := lockPidFile(getPidFilePath())
+	if err != nil {
+		log.Println("Cannot start the service registration: " + err.Error())
+		os.Exit(1)
+	}
+	defer unlockPidFile(pidFile)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-shutdown
+		cancel()
+	}()
+
+	conf, err := config.LoadProfile(getConfigPath(), profile)
+	if err != nil {
+		log.Println(err.Error())
+		os.Exit(1)
+	}
+	consulClient.Verbose = conf.IsVerbose()
+	ambariClient := createAmbariClient()
+	if err := setTrafficMode(ambariClient.HTTP, consulClient.HTTP); err != nil {
+		log.Println("Cannot set up the traffic recording: " + err.Error())
+		os.Exit(1)
+	}
+	httpclient.InjectFaults(ambariClient.HTTP, "Ambari", conf.FaultInjection.Ambari)
+	httpclient.InjectFaults(consulClient.HTTP, "Consul", conf.FaultInjection.Consul)
+	ambariSource := ambari.NewSource(ambariClient)
+	ambariSource.FullRefreshCycles = config.GetIntEnv(ENV_HOST_FULL_REFRESH_CYCLES, ambari.DEFAULT_HOST_FULL_REFRESH_CYCLES)
+	ambariSource.AlertChecks = conf.AlertChecks
+	ambariSource.ConfigGroups = conf.UsesHostAttribute(ambari.CONFIG_GROUP_ATTRIBUTE)
+	var source topology.Source = ambariSource
+	if conf.Docker.Enabled {
+		source = topology.MultiSource{ambariSource, createDockerSource(conf.Docker)}
+	}
+	if baseURL := os.Getenv(ENV_CLOUDBREAK_URL); len(baseURL) > 0 {
+		source = createCloudbreakSource(source, baseURL)
+	}
+
+	reg, err := registration.New(registration.Config{
+		Source:               source,
+		Consul:               consulClient,
+		Services:             conf,
+		Poll:                 getPollSettings(conf),
+		StatePath:            getStateFilePath(),
+		RetryQueuePath:       getRetryQueuePath(),
+		OutboxPath:           getOutboxPath(),
+		LeaderElectionKey:    os.Getenv(ENV_LEADER_ELECTION_KEY),
+		ClusterLockPrefix:    os.Getenv(ENV_CLUSTER_LOCK_PREFIX),
+		HeartbeatKey:         os.Getenv(ENV_HEARTBEAT_KEY),
+		Name:                 App,
+		DeregisterOnShutdown: os.Getenv(ENV_DEREGISTER_ON_SHUTDOWN) == "true",
+		Hooks:                exechook.New(conf.Hooks),
+		Backends:             createBackends(conf.Backends),
+		Notifier:             createNotifier(conf.Notifications),
+	})
+	if err != nil {
+		log.Println(err.Error())
+		os.Exit(1)
+	}
+	if address := os.Getenv(ENV_CONTROL_API_ADDRESS); len(address) > 0 {
+		server, err := control.NewServer(address, admin.TLSConfig{
+			CertFile:     os.Getenv(ENV_CONTROL_API_CERT),
+			KeyFile:      os.Getenv(ENV_CONTROL_API_KEY),
+			ClientCAFile: os.Getenv(ENV_CONTROL_API_CLIENT_CA),
+		}, reg)
 		if err != nil {
-			log.Println("Failed to get the services from consul: " + err.Error())
-			continue
+			log.Println("Cannot start the control API: " + err.Error())
+			os.Exit(1)
 		}
-
-		if newComponents := getNewComponents(components, consulServices); len(newComponents) > 0 {
-			registerToConsul(httpClient, newComponents)
+		if conf.APIAuth.IsEnabled() {
+			server.Handler = admin.RequireAuth(server.Handler, conf.APIAuth)
 		}
-
-		if removedServices := getRemovedServices(components, consulServices); len(removedServices) > 0 {
-			deregisterFromConsul(httpClient, removedServices)
+		control.Serve(server)
+		defer server.Close()
+	}
+	listener, err := admin.ActivationListener()
+	if err != nil {
+		log.Println("Cannot use the socket activated listener: " + err.Error())
+	}
+	if address := os.Getenv(ENV_ADMIN_API_ADDRESS); len(address) > 0 || listener != nil {
+		server := admin.NewServer(address, reg)
+		if conf.APIAuth.IsEnabled() {
+			server.Handler = admin.RequireAuth(server.Handler, conf.APIAuth)
+		}
+		tlsConfig := admin.TLSConfig{
+			CertFile:     os.Getenv(ENV_ADMIN_API_CERT),
+			KeyFile:      os.Getenv(ENV_ADMIN_API_KEY),
+			ClientCAFile: os.Getenv(ENV_ADMIN_API_CLIENT_CA),
 		}
+		if tlsConfig.IsEnabled() {
+			if err := admin.EnableTLS(server, tlsConfig); err != nil {
+				log.Println("Cannot start the admin API: " + err.Error())
+				os.Exit(1)
+			}
+		}
+		admin.Serve(server, listener)
+		defer server.Close()
+	}
+	if timeout := config.GetDurationEnv(ENV_CONSUL_STARTUP_TIMEOUT, consul.DEFAULT_STARTUP_TIMEOUT); timeout > 0 {
+		if err := consulClient.WaitReady(ctx, timeout); err != nil {
+			log.Println(err.Error() + ", starting anyway")
+		}
+	}
+	if err := reg.Run(ctx); err != nil {
+		log.Println(err.Error())
+		os.Exit(1)
 	}
 }
 
+// setLogFile sets up the rotated log file. The log goes to the standard error
+// when the path is "-" or the file is not writable, e.g. when running as an
+// unprivileged user with the default path.
 func setLogFile() {
-	logFilePath := "/var/log/" + App + ".log"
+	logFilePath := os.Getenv(ENV_LOG_FILE_PATH)
+	if len(logFilePath) == 0 {
+		logFilePath = "/var/log/" + App + ".log"
+	}
+	if logFilePath == "-" {
+		return
+	}
+	if err := checkWritable(logFilePath); err != nil {
+		log.Println("Cannot write the log file, logging to the standard error: " + err.Error())
+		return
+	}
 	log.SetOutput(&lumberjack.Logger{
 		Filename:   logFilePath,
 		MaxSize:    10,
@@ -189,387 +292,368 @@ func setLogFile() {
 	})
 }
 
-func wait() {
-	var sleep time.Duration
-	sleepEnv := os.Getenv(ENV_SERVICE_CHECK_POLL_INTERVAL)
-	if len(sleepEnv) > 0 {
-		s, _ := time.ParseDuration(sleepEnv)
-		sleep = s
-	} else {
-		sleep = DEFAULT_SERVICE_CHECK_POLL_INTERVAL
-	}
-	log.Printf("Wait %.0f seconds for the next service check", sleep.Seconds())
-	time.Sleep(sleep)
+func checkWritable(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	return file.Close()
 }
 
-func createAmbariConfig() *Ambari {
-	credentialsPath := os.Getenv(ENV_AMBARI_CREDENTIALS_PATH)
-	if len(credentialsPath) == 0 {
-		credentialsPath = DEFAULT_AMBARI_CREDENTIALS_PATH
+func getStateFilePath() string {
+	path := os.Getenv(ENV_STATE_FILE_PATH)
+	if len(path) == 0 {
+		path = "/var/lib/" + App + "/state.json"
 	}
-	log.Print("Ambari credentials path: " + credentialsPath)
-	waitFile(credentialsPath)
-	ambari := readCredentials(credentialsPath)
+	return path
+}
 
-	ambariAddress := os.Getenv(ENV_AMBARI_ADDRESS)
-	if len(ambariAddress) == 0 {
-		ambariAddress = DEFAULT_AMBARI_ADDRESS
+func getRetryQueuePath() string {
+	path := os.Getenv(ENV_RETRY_QUEUE_PATH)
+	if len(path) == 0 {
+		path = "/var/lib/" + App + "/retry-queue.json"
+	}
+	return path
+}
+
+func getOutboxPath() string {
+	path := os.Getenv(ENV_OUTBOX_PATH)
+	if len(path) == 0 {
+		path = "/var/lib/" + App + "/outbox.json"
 	}
-	ambari.Config.Address = ambariAddress
-	return ambari
+	return path
 }
 
-func waitFile(path string) {
-	found := false
-	for !found {
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			log.Println("File not found at location: " + path)
-			time.Sleep(REQUEST_SLEEP_TIME)
+func getConfigPath() string {
+	path := os.Getenv(ENV_CONFIG_PATH)
+	if len(path) == 0 {
+		path = "/etc/" + App + "/config.yml"
+	}
+	return path
+}
+
+// getPollSettings takes the intervals from the environment, then from the
+// config file. Without SERVICE_CHECK_POLL_JITTER the jitter is a tenth of the
+// sleep, a negative value like -1s disables it.
+func getPollSettings(conf *config.Config) reconciler.PollSettings {
+	interval := DEFAULT_SERVICE_CHECK_POLL_INTERVAL
+	if conf.PollInterval > 0 {
+		interval = conf.PollInterval
+	}
+	minInterval := DEFAULT_SERVICE_CHECK_MIN_POLL_INTERVAL
+	if conf.MinPollInterval > 0 {
+		minInterval = conf.MinPollInterval
+	}
+	return reconciler.PollSettings{
+		Interval:    config.GetDurationEnv(ENV_SERVICE_CHECK_POLL_INTERVAL, interval),
+		MinInterval: config.GetDurationEnv(ENV_SERVICE_CHECK_MIN_POLL_INTERVAL, minInterval),
+		MaxInterval: config.GetDurationEnv(ENV_SERVICE_CHECK_MAX_POLL_INTERVAL, conf.MaxPollInterval),
+		MaxBackoff:  config.GetDurationEnv(ENV_SERVICE_CHECK_MAX_BACKOFF, DEFAULT_SERVICE_CHECK_MAX_BACKOFF),
+		Jitter:      config.GetDurationEnv(ENV_SERVICE_CHECK_POLL_JITTER, 0),
+	}
+}
+
+// getProfile takes the config profile from the --profile=name argument, which
+// is removed from the arguments, or from the environment.
+func getProfile() string {
+	profile := os.Getenv(ENV_CONFIG_PROFILE)
+	var args = make([]string, 0, len(os.Args))
+	for _, arg := range os.Args {
+		if strings.HasPrefix(arg, PROFILE_FLAG) {
+			profile = strings.TrimPrefix(arg, PROFILE_FLAG)
 		} else {
-			log.Println("Found file at location: " + path)
-			found = true
+			args = append(args, arg)
 		}
 	}
+	os.Args = args
+	return profile
 }
 
-func readCredentials(path string) *Ambari {
-	var ambari *Ambari = nil
-	for ambari == nil {
-		content, _ := ioutil.ReadFile(path)
-		var temp Ambari
-		err := yaml.Unmarshal(content, &temp)
-		if err != nil {
-			log.Println("Cannot parse file: " + path)
-			os.Exit(1)
-		}
-		if len(temp.Config.Username) > 0 && len(temp.Config.Password) > 0 {
-			ambari = &temp
-			log.Println("Ambari credentials found")
-		} else {
-			log.Println("Ambari credentials are empty, waiting..")
-			time.Sleep(REQUEST_SLEEP_TIME)
+func createConsulClient() *consul.Client {
+	workerPoolSize := config.GetIntEnv(ENV_CONSUL_WORKER_POOL_SIZE, consul.DEFAULT_WORKER_POOL_SIZE)
+	agentTimeout := config.GetDurationEnv(ENV_CONSUL_AGENT_TIMEOUT, consul.DEFAULT_AGENT_TIMEOUT)
+	// every agent has its own connections, and the remote agents a shorter
+	// timeout than the local one, so a slow agent cannot delay the others
+	var client *consul.Client
+	client = consul.NewClient(httpclient.NewIsolated(REQUEST_TIMEOUT, workerPoolSize, func(host string) time.Duration {
+		if baseURL, err := url.Parse(client.BaseURL); err == nil && baseURL.Hostname() == host {
+			return REQUEST_TIMEOUT
 		}
+		return agentTimeout
+	}))
+	setCABundle(client.HTTP, ENV_CONSUL_CA_BUNDLE)
+	client.WorkerPoolSize = workerPoolSize
+	client.RetryBudget = config.GetIntEnv(ENV_CONSUL_RETRY_BUDGET, consul.DEFAULT_RETRY_BUDGET)
+	client.MaxWrites = config.GetIntEnv(ENV_CONSUL_MAX_WRITES, consul.DEFAULT_MAX_WRITES)
+	client.Token = os.Getenv(ENV_CONSUL_TOKEN)
+	client.Datacenter = os.Getenv(ENV_CONSUL_DATACENTER)
+	if path := os.Getenv(ENV_CONSUL_AGENT_TOKENS_FILE); len(path) > 0 {
+		client.AgentTokens = consul.NewAgentTokens(path)
 	}
-	return ambari
+	return client
 }
 
-func readServer(path string) *Ambari {
-	var ambari *Ambari = nil
-	for ambari == nil {
-		content, _ := ioutil.ReadFile(path)
-		var temp Ambari
-		err := yaml.Unmarshal(content, &temp)
+// createNotifier returns the notifier of the enabled channels, or nil if none
+// is enabled.
+func createNotifier(conf config.Notifications) *notify.Notifier {
+	var channels = make([]notify.Channel, 0)
+	if conf.Email.Enabled {
+		password, err := conf.Email.GetPassword()
 		if err != nil {
-			log.Println("Cannot parse file: " + path)
+			log.Println("Cannot read the SMTP password: " + err.Error())
 			os.Exit(1)
 		}
-		if len(temp.Config.Address) > 0 {
-			ambari = &temp
-			log.Println("Ambari server found")
-		} else {
-			log.Println("Ambari server is empty waiting..")
-			time.Sleep(REQUEST_SLEEP_TIME)
+		log.Println("Sending the notifications by email to: " + strings.Join(conf.Email.To, ", "))
+		channels = append(channels, &notify.Email{
+			Server:   conf.Email.Server,
+			Username: conf.Email.Username,
+			Password: password,
+			From:     conf.Email.From,
+			To:       conf.Email.To,
+		})
+	}
+	if conf.PagerDuty.Enabled {
+		routingKey, err := conf.PagerDuty.GetRoutingKey()
+		if err != nil {
+			log.Println("Cannot read the PagerDuty routing key: " + err.Error())
+			os.Exit(1)
 		}
+		httpClient := httpclient.New(REQUEST_TIMEOUT, 1)
+		setCABundle(httpClient, ENV_NOTIFICATIONS_CA_BUNDLE)
+		setProxy(httpClient, ENV_NOTIFICATIONS_PROXY)
+		log.Println("Sending the critical notifications to PagerDuty")
+		channels = append(channels, notify.NewPagerDuty(httpClient, routingKey))
 	}
-	return ambari
+	if len(channels) == 0 {
+		return nil
+	}
+	return notify.NewNotifier(channels)
 }
 
-func createGETRequest(ambari *Ambari, path string) *http.Request {
-	req, _ := http.NewRequest("GET", "http://"+ambari.Config.Address+":8080/api/v1"+path, nil)
-	req.Header.Add("X-Requested-By", "ambari")
-	req.SetBasicAuth(ambari.Config.Username, ambari.Config.Password)
-	return req
+// setCABundle makes the client trust the CA bundle of the endpoint, or the
+// common CA_BUNDLE, besides the system roots. An unusable bundle stops the
+// service registration, since every request of the client would fail.
+func setCABundle(client *http.Client, env string) {
+	path := os.Getenv(env)
+	if len(path) == 0 {
+		path = os.Getenv(ENV_CA_BUNDLE)
+	}
+	if err := httpclient.TrustCABundle(client, path); err != nil {
+		log.Println("Cannot load the CA bundle of " + env + ": " + err.Error())
+		os.Exit(1)
+	}
 }
 
-func getClusterName(client *http.Client, ambari *Ambari) (string, error) {
-	req := createGETRequest(ambari, "/clusters")
-	var clusterName string = ""
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	body, _ := ioutil.ReadAll(resp.Body)
-	log.Println("Clusters resonse: " + string(body))
-	var cresp ClusterResponse
-	decoder := json.NewDecoder(strings.NewReader(string(body)))
-	if err = decoder.Decode(&cresp); err != nil {
-		return "", err
-	}
-	if len(cresp.Items) > 0 && len(cresp.Items[0].Cluster.Name) > 0 {
-		clusterName = cresp.Items[0].Cluster.Name
-		log.Println("Found cluster: " + clusterName)
-	} else {
-		return "", errors.New("Cluster not found, yet")
-	}
-	return clusterName, nil
+// setProxy routes the requests of the client through the proxy of the
+// endpoint, e.g. a SOCKS5 tunnel to a bastion host.
+func setProxy(client *http.Client, env string) {
+	address := os.Getenv(env)
+	if len(address) == 0 {
+		return
+	}
+	if err := httpclient.SetProxy(client, address); err != nil {
+		log.Println("Cannot use the proxy of " + env + ": " + err.Error())
+		os.Exit(1)
+	}
+	if proxyURL, err := url.Parse(address); err == nil {
+		log.Println("Connecting through the proxy of " + env + ": " + proxyURL.Redacted())
+	}
 }
 
-func getHosts(client *http.Client, ambari *Ambari) (map[string]string, error) {
-	req := createGETRequest(ambari, "/hosts?fields=Hosts/ip")
-	var hosts = make(map[string]string)
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+func newBackendHTTPClient(maxIdleConnsPerHost int) *http.Client {
+	client := httpclient.New(REQUEST_TIMEOUT, maxIdleConnsPerHost)
+	setCABundle(client, ENV_BACKENDS_CA_BUNDLE)
+	return client
+}
+
+func createBackends(conf config.Backends) []backend.Backend {
+	var backends = make([]backend.Backend, 0)
+	if conf.Route53.Enabled {
+		client := route53.NewClient(newBackendHTTPClient(2), conf.Route53.HostedZoneID)
+		b := route53.New(client, conf.Route53.Domain)
+		if conf.Route53.TTL > 0 {
+			b.TTL = conf.Route53.TTL
+		}
+		if len(conf.Route53.OwnerID) > 0 {
+			b.OwnerID = conf.Route53.OwnerID
+		}
+		log.Println("Publishing the services to Route 53 hosted zone: " + conf.Route53.HostedZoneID)
+		backends = append(backends, b)
 	}
-	body, _ := ioutil.ReadAll(resp.Body)
-	log.Println("Hosts resonse: " + string(body))
-	var hresp HostsResponse
-	decoder := json.NewDecoder(strings.NewReader(string(body)))
-	if err = decoder.Decode(&hresp); err != nil {
-		return nil, err
+	if conf.AzureDNS.Enabled {
+		httpClient := newBackendHTTPClient(azuredns.DEFAULT_WORKERS)
+		tokens := azuredns.NewTokenProvider(httpClient, conf.AzureDNS.ClientID)
+		b := azuredns.New(azuredns.NewClient(httpClient, conf.AzureDNS.SubscriptionID, conf.AzureDNS.ResourceGroup, conf.AzureDNS.Zone, tokens))
+		if conf.AzureDNS.TTL > 0 {
+			b.TTL = conf.AzureDNS.TTL
+		}
+		if len(conf.AzureDNS.OwnerID) > 0 {
+			b.OwnerID = conf.AzureDNS.OwnerID
+		}
+		log.Println("Publishing the services to Azure private DNS zone: " + conf.AzureDNS.Zone)
+		backends = append(backends, b)
 	}
-	if len(hresp.Items) > 0 {
-		for _, item := range hresp.Items {
-			hosts[item.Host.HostName] = item.Host.IP
+	if conf.CloudDNS.Enabled {
+		httpClient := newBackendHTTPClient(2)
+		tokens := clouddns.NewTokenProvider(httpClient, conf.CloudDNS.CredentialsFile)
+		b := clouddns.New(clouddns.NewClient(httpClient, conf.CloudDNS.Project, conf.CloudDNS.Zone, tokens))
+		b.Domain = conf.CloudDNS.Domain
+		if conf.CloudDNS.TTL > 0 {
+			b.TTL = conf.CloudDNS.TTL
 		}
-		log.Printf("Found hosts: %v", hosts)
-	} else {
-		log.Println("There are not hosts yet")
+		if len(conf.CloudDNS.OwnerID) > 0 {
+			b.OwnerID = conf.CloudDNS.OwnerID
+		}
+		log.Println("Publishing the services to Cloud DNS managed zone: " + conf.CloudDNS.Zone)
+		backends = append(backends, b)
 	}
-	return hosts, nil
-}
-
-func getHostComponents(client *http.Client, ambari *Ambari, clusterName string, hosts map[string]string) ([]HostComponent, error) {
-	var hostComponents = make([]HostComponent, 0)
-	req := createGETRequest(ambari, "/clusters/"+clusterName+"/hosts?fields=host_components/HostRoles/state/*,host_components/HostRoles/maintenance_state")
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	body, _ := ioutil.ReadAll(resp.Body)
-	log.Println("Host component resonse: " + string(body))
-	var hresp HostComponentsResponse
-	decoder := json.NewDecoder(strings.NewReader(string(body)))
-	if err = decoder.Decode(&hresp); err != nil {
-		return nil, err
-	}
-	if len(hresp.Items) > 0 {
-		for _, item := range hresp.Items {
-			ip := hosts[item.Host.HostName]
-			for _, component := range item.HostComponents {
-				state := component.HostRole.State
-				maintenance := component.HostRole.Maintenance
-				if "ON" == maintenance || "IMPLIED_FROM_SERVICE" == maintenance {
-					state = "maintenance"
-				}
-				hc := HostComponent{
-					HostComponent: component.HostRole.ComponentName,
-					Hostname:      item.Host.HostName,
-					IP:            ip,
-					State:         state,
-				}
-				hostComponents = append(hostComponents, hc)
-			}
+	if conf.ZooKeeper.Enabled {
+		conn := zookeeper.NewConn(conf.ZooKeeper.Servers)
+		if conf.ZooKeeper.SessionTimeout > 0 {
+			conn.SessionTimeout = conf.ZooKeeper.SessionTimeout
 		}
-		log.Printf("Generated host components: %v", hostComponents)
-	} else {
-		log.Println("No host components found yet")
+		b := zookeeper.New(conn)
+		if len(conf.ZooKeeper.Root) > 0 {
+			b.Root = conf.ZooKeeper.Root
+		}
+		log.Println("Publishing the services to ZooKeeper under: " + b.Root)
+		backends = append(backends, b)
 	}
-	return hostComponents, nil
-}
-
-func getRootHostComponents(client *http.Client, ambari *Ambari, hosts map[string]string) ([]HostComponent, error) {
-	var hostComponents = make([]HostComponent, 0)
-	req := createGETRequest(ambari, "/services/?fields=components/hostComponents/RootServiceHostComponents/service_name,components/hostComponents/RootServiceHostComponents/component_state")
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	body, _ := ioutil.ReadAll(resp.Body)
-	log.Println("Root host component resonse: " + string(body))
-	var hresp RootHostComponentsResponse
-	decoder := json.NewDecoder(strings.NewReader(string(body)))
-	if err = decoder.Decode(&hresp); err != nil {
-		return nil, err
-	}
-	if len(hresp.Items) > 0 {
-		for _, item := range hresp.Items {
-			for _, component := range item.Components {
-				for _, hostComponent := range component.HostComponents {
-					hc := HostComponent{
-						HostComponent: hostComponent.RootServiceHostComponents.Name,
-						Hostname:      hostComponent.RootServiceHostComponents.Hostname,
-						IP:            hosts[hostComponent.RootServiceHostComponents.Hostname],
-						State:         hostComponent.RootServiceHostComponents.State,
-					}
-					hostComponents = append(hostComponents, hc)
-				}
-			}
+	if conf.Etcd.Enabled {
+		client := etcd.Client{HTTP: newBackendHTTPClient(2), Endpoints: conf.Etcd.Endpoints, Username: conf.Etcd.Username, Password: conf.Etcd.Password}
+		var store etcd.Store = &etcd.V3Store{Client: client}
+		if conf.Etcd.APIVersion == etcd.API_V2 {
+			store = &etcd.V2Store{Client: client}
 		}
-		log.Printf("Generated root host components: %v", hostComponents)
-	} else {
-		log.Println("No root host components found yet")
+		b := etcd.New(store, conf.Etcd.Domain)
+		if len(conf.Etcd.Prefix) > 0 {
+			b.Prefix = conf.Etcd.Prefix
+		}
+		if conf.Etcd.TTL > 0 {
+			b.TTL = conf.Etcd.TTL
+		}
+		if len(conf.Etcd.OwnerID) > 0 {
+			b.OwnerID = conf.Etcd.OwnerID
+		}
+		log.Println("Publishing the services to etcd in the SkyDNS layout: " + strings.Join(conf.Etcd.Endpoints, ", "))
+		backends = append(backends, b)
 	}
-	return hostComponents, nil
-}
-
-func getConsulServices(client *http.Client) ([]ConsulService, error) {
-	var registered = make([]ConsulService, 0)
-
-	req, _ := http.NewRequest("GET", "http://localhost:8500/v1/catalog/services", nil)
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	respBody, _ := ioutil.ReadAll(resp.Body)
-	log.Println("Already registered Consul services: " + string(respBody))
-	var services = make(map[string]interface{})
-	decoder := json.NewDecoder(strings.NewReader(string(respBody)))
-	if err = decoder.Decode(&services); err != nil {
-		return nil, err
-	}
-
-	var wg sync.WaitGroup
-	var errorChannel = make(chan error, len(services))
-	var serviceChannel = make(chan ConsulService)
-
-	for service := range services {
-		wg.Add(1)
-		go func(service string) {
-			defer wg.Done()
-			log.Println("Get service registrations for: " + service)
-			req, _ := http.NewRequest("GET", "http://localhost:8500/v1/catalog/service/"+service, nil)
-			srvResp, err := client.Do(req)
-			if err != nil {
-				errorChannel <- err
-				return
-			}
-			respBody, _ := ioutil.ReadAll(srvResp.Body)
-			var services []ConsulService
-			decoder := json.NewDecoder(strings.NewReader(string(respBody)))
-			if err = decoder.Decode(&services); err != nil {
-				errorChannel <- err
-				return
-			}
-			log.Printf("Retrieved service info: %v", services)
-			for _, s := range services {
-				serviceChannel <- s
-			}
-		}(service)
+	if conf.ZoneFile.Enabled {
+		b := zonefile.New(conf.ZoneFile.File, conf.ZoneFile.Domain)
+		if conf.ZoneFile.TTL > 0 {
+			b.TTL = conf.ZoneFile.TTL
+		}
+		if len(conf.ZoneFile.Nameserver) > 0 {
+			b.Nameserver = conf.ZoneFile.Nameserver
+		}
+		if len(conf.ZoneFile.Hostmaster) > 0 {
+			b.Hostmaster = conf.ZoneFile.Hostmaster
+		}
+		log.Println("Writing the services to the zone file: " + conf.ZoneFile.File)
+		backends = append(backends, b)
 	}
-
-	go func() {
-		wg.Wait()
-		close(serviceChannel)
-		close(errorChannel)
-	}()
-
-	for s := range serviceChannel {
-		registered = append(registered, s)
+	if conf.Traefik.Enabled {
+		b := traefik.New(conf.Traefik.File, conf.Traefik.Services)
+		b.Domain = conf.Traefik.Domain
+		b.EntryPoints = conf.Traefik.EntryPoints
+		if len(conf.Traefik.Scheme) > 0 {
+			b.Scheme = conf.Traefik.Scheme
+		}
+		log.Println("Writing the Traefik configuration to: " + conf.Traefik.File)
+		backends = append(backends, b)
 	}
+	if conf.Envoy.Enabled {
+		b := envoy.New(conf.Envoy.Address)
+		b.Serve()
+		backends = append(backends, b)
+	}
+	return backends
+}
 
-	for e := range errorChannel {
-		return nil, e
+func createDockerSource(conf config.DockerSource) *docker.Source {
+	source := docker.NewSource(conf.Socket)
+	if len(conf.LabelPrefix) > 0 {
+		source.LabelPrefix = conf.LabelPrefix
 	}
+	source.HostIP = conf.HostIP
+	return source
+}
 
-	return registered, nil
+// createCloudbreakSource enriches the components with the stack metadata of
+// the Cloudbreak API at baseURL, e.g. https://cloudbreak/cb/api/v1.
+func createCloudbreakSource(source topology.Source, baseURL string) *cloudbreak.Source {
+	httpClient := httpclient.New(REQUEST_TIMEOUT, 2)
+	setCABundle(httpClient, ENV_CLOUDBREAK_CA_BUNDLE)
+	setProxy(httpClient, ENV_CLOUDBREAK_PROXY)
+	client := cloudbreak.NewClient(httpClient, baseURL, os.Getenv(ENV_CLOUDBREAK_TOKEN))
+	enriched := cloudbreak.NewSource(source, client, os.Getenv(ENV_CLOUDBREAK_STACK_ID))
+	enriched.RefreshInterval = config.GetDurationEnv(ENV_CLOUDBREAK_REFRESH_INTERVAL, cloudbreak.DEFAULT_REFRESH_INTERVAL)
+	return enriched
 }
 
-func getNewComponents(components []HostComponent, consulServices []ConsulService) []HostComponent {
-	var newComponents = make([]HostComponent, 0)
-	for _, component := range components {
-		state := strings.ToLower(component.State)
-		componentName := getDnsReadyComponentName(component.HostComponent)
-		if "unknown" != state {
-			registered := false
-			for _, service := range consulServices {
-				if service.ServiceName == componentName && service.Address == component.IP &&
-					(len(service.ServiceTags) > 0 && service.ServiceTags[0] == state) {
-					log.Printf("Service '%s' is already registered for host: %s and in state: %s", service.ServiceName, component.IP, service.ServiceTags[0])
-					registered = true
-					break
-				}
-			}
-			if !registered {
-				newComponents = append(newComponents, component)
-			}
-		} else {
-			log.Printf("%s's state is unknown, update skipped", componentName)
+// setTrafficMode records the Ambari and Consul traffic to RECORD_DIR, or
+// replays a recording from REPLAY_DIR instead of calling the servers, so an
+// issue can be reproduced offline.
+func setTrafficMode(ambariHTTP *http.Client, consulHTTP *http.Client) error {
+	if dir := os.Getenv(ENV_REPLAY_DIR); len(dir) > 0 {
+		log.Println("Replaying the recorded traffic from: " + dir)
+		if err := httpclient.Replay(ambariHTTP, filepath.Join(dir, "ambari.jsonl")); err != nil {
+			return err
 		}
+		return httpclient.Replay(consulHTTP, filepath.Join(dir, "consul.jsonl"))
 	}
-	return newComponents
-}
-
-func getRemovedServices(components []HostComponent, consulServices []ConsulService) []ConsulService {
-	var removedServices = make([]ConsulService, 0)
-	for _, service := range consulServices {
-		if isAmbariService(service) {
-			active := false
-			for _, component := range components {
-				if service.ServiceName == getDnsReadyComponentName(component.HostComponent) && service.Address == component.IP {
-					active = true
-					break
-				}
-			}
-			if !active {
-				removedServices = append(removedServices, service)
-			}
+	if dir := os.Getenv(ENV_RECORD_DIR); len(dir) > 0 {
+		log.Println("Recording the traffic to: " + dir)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
 		}
+		if err := httpclient.Record(ambariHTTP, filepath.Join(dir, "ambari.jsonl")); err != nil {
+			return err
+		}
+		return httpclient.Record(consulHTTP, filepath.Join(dir, "consul.jsonl"))
 	}
-	return removedServices
+	return nil
 }
 
-func registerToConsul(client *http.Client, components []HostComponent) {
-	var wg sync.WaitGroup
-	for _, comp := range components {
-		wg.Add(1)
-		go func(component HostComponent) {
-			defer wg.Done()
-			componentName := getDnsReadyComponentName(component.HostComponent)
-			shortHostname := component.Hostname[0:strings.Index(component.Hostname, ".")]
-			id := componentName + "." + strings.Replace(shortHostname, "_", "-", 1)
-			service := ConsulService{
-				ID:      id,
-				Name:    componentName,
-				Address: component.IP,
-				Port:    1080,
-				Tags:    []string{strings.ToLower(component.State), AMBARI_CONSUL_SERVICE_TAG},
-			}
-			body := service.Json()
-			log.Printf("Registering service: %v", body)
-			req, _ := http.NewRequest("PUT", "http://"+component.IP+":8500/v1/agent/service/register", bytes.NewBuffer([]byte(body)))
-			req.Header.Add("Content-Type", "application/json")
-			resp, err := client.Do(req)
-			if err != nil {
-				log.Println(err)
-				return
-			}
-			respBody, _ := ioutil.ReadAll(resp.Body)
-			if len(respBody) > 0 {
-				log.Println("Invalid register request: " + string(respBody))
-			}
-		}(comp)
+// createAmbariClient waits for the Ambari credentials, which are not needed
+// when replaying a recording.
+func createAmbariClient() *ambari.Client {
+	var username, password string
+	if len(os.Getenv(ENV_REPLAY_DIR)) == 0 {
+		credentialsPath := getAmbariCredentialsPath()
+		log.Print("Ambari credentials path: " + credentialsPath)
+		ambari.WaitFile(credentialsPath)
+		credentials := ambari.ReadCredentials(credentialsPath)
+		username, password = credentials.Config.Username, credentials.Config.Password
 	}
-	wg.Wait()
+	return newAmbariClient(username, password)
 }
 
-func deregisterFromConsul(client *http.Client, services []ConsulService) {
-	for _, service := range services {
-		go func(service ConsulService) {
-			log.Printf("Deregistering service: %s", service.ServiceID)
-			req, _ := http.NewRequest("GET", "http://"+service.Address+":8500/v1/agent/service/deregister/"+service.ServiceID, nil)
-			resp, err := client.Do(req)
-			if err != nil {
-				log.Println(err)
-				return
-			}
-			respBody, _ := ioutil.ReadAll(resp.Body)
-			if len(respBody) > 0 {
-				log.Println("Invalid deregister request: " + string(respBody))
-			}
-		}(service)
+func getAmbariCredentialsPath() string {
+	path := os.Getenv(ENV_AMBARI_CREDENTIALS_PATH)
+	if len(path) == 0 {
+		path = DEFAULT_AMBARI_CREDENTIALS_PATH
 	}
+	return path
 }
 
-func isAmbariService(service ConsulService) bool {
-	for _, t := range service.ServiceTags {
-		if t == AMBARI_CONSUL_SERVICE_TAG {
-			return true
-		}
+func newAmbariClient(username string, password string) *ambari.Client {
+	ambariAddress := os.Getenv(ENV_AMBARI_ADDRESS)
+	if len(ambariAddress) == 0 {
+		ambariAddress = DEFAULT_AMBARI_ADDRESS
 	}
-	return false
-}
+	timeout := config.GetDurationEnv(ENV_AMBARI_REQUEST_TIMEOUT, REQUEST_TIMEOUT)
+	client := ambari.NewClient(httpclient.New(timeout, AMBARI_MAX_IDLE_CONNS), ambariAddress, username, password)
+	setCABundle(client.HTTP, ENV_AMBARI_CA_BUNDLE)
+	setProxy(client.HTTP, ENV_AMBARI_PROXY)
+	if serverURL := os.Getenv(ENV_AMBARI_SERVER_URL); len(serverURL) > 0 {
+		client.ServerURL = strings.TrimSuffix(serverURL, "/")
+		client.BaseURL = client.ServerURL + "/api/v1"
+	}
+	client.PageSize = config.GetIntEnv(ENV_AMBARI_PAGE_SIZE, ambari.DEFAULT_PAGE_SIZE)
+	return client
 
-func getDnsReadyComponentName(componentName string) string {
-	return strings.Replace(strings.ToLower(componentName), "_", "-", -1)
 }
@@ -0,0 +1,206 @@
+// Package metrics records duration histograms and renders them in the
+// Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AMBARI_REQUEST_DURATION = "service_registration_ambari_request_duration_seconds"
+	CONSUL_REQUEST_DURATION = "service_registration_consul_request_duration_seconds"
+	SYNC_DURATION           = "service_registration_sync_duration_seconds"
+	COMPONENT_STATE         = "ambari_component_state"
+	ERRORS                  = "service_registration_errors_total"
+	RETRY_QUEUE_LENGTH      = "service_registration_retry_queue_length"
+	CONSECUTIVE_FAILURES    = "service_registration_consecutive_failures"
+	DRIFT                   = "service_registration_drift"
+	DRIFT_AGE               = "service_registration_drift_age_seconds"
+)
+
+// The categories of the ERRORS counter.
+const (
+	ERROR_AMBARI_AUTH              = "ambari_auth"
+	ERROR_AMBARI_TIMEOUT           = "ambari_timeout"
+	ERROR_AMBARI_UNREACHABLE       = "ambari_unreachable"
+	ERROR_AMBARI_SERVER            = "ambari_server_error"
+	ERROR_CONSUL_AGENT_UNREACHABLE = "consul_agent_unreachable"
+	ERROR_CONSUL_SERVER            = "consul_server_error"
+	ERROR_DECODE                   = "decode_error"
+	ERROR_REGISTER_REJECTED        = "register_rejected"
+	ERROR_DEREGISTER_REJECTED      = "deregister_rejected"
+)
+
+var DEFAULT_BUCKETS = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Default is the registry the clients and the reconciler record to.
+var Default = NewRegistry()
+
+type Labels map[string]string
+
+func (l Labels) String() string {
+	var keys = make([]string, 0, len(l))
+	for key := range l {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs = make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", key, l[key]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+type Histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func (h *Histogram) observe(value float64) {
+	for i, bucket := range h.buckets {
+		if value <= bucket {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+type Registry struct {
+	lock       sync.Mutex
+	histograms map[string]map[string]*Histogram
+	gauges     map[string]map[string]float64
+	counters   map[string]map[string]float64
+}
+
+// Sample is a gauge value with its labels.
+type Sample struct {
+	Labels Labels
+	Value  float64
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		histograms: make(map[string]map[string]*Histogram),
+		gauges:     make(map[string]map[string]float64),
+		counters:   make(map[string]map[string]float64),
+	}
+}
+
+func (r *Registry) Inc(name string, labels Labels) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	series, ok := r.counters[name]
+	if !ok {
+		series = make(map[string]float64)
+		r.counters[name] = series
+	}
+	series[labels.String()]++
+}
+
+// CountError counts a failure of the category in the default registry.
+func CountError(category string) {
+	Default.Inc(ERRORS, Labels{"category": category})
+}
+
+// SetGauge replaces every series of the gauge with the samples, so the series
+// missing from the samples disappear.
+func (r *Registry) SetGauge(name string, samples []Sample) {
+	var series = make(map[string]float64, len(samples))
+	for _, sample := range samples {
+		series[sample.Labels.String()] = sample.Value
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.gauges[name] = series
+}
+
+func (r *Registry) Observe(name string, labels Labels, d time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	series, ok := r.histograms[name]
+	if !ok {
+		series = make(map[string]*Histogram)
+		r.histograms[name] = series
+	}
+	key := labels.String()
+	h, ok := series[key]
+	if !ok {
+		h = &Histogram{buckets: DEFAULT_BUCKETS, counts: make([]uint64, len(DEFAULT_BUCKETS))}
+		series[key] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// ObserveSince records the time elapsed since start, to be used with defer.
+func (r *Registry) ObserveSince(name string, labels Labels, start time.Time) {
+	r.Observe(name, labels, time.Since(start))
+}
+
+func (r *Registry) WritePrometheus(w io.Writer) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var names = make([]string, 0, len(r.histograms))
+	for name := range r.histograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+		series := r.histograms[name]
+		var keys = make([]string, 0, len(series))
+		for key := range series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			h := series[key]
+			prefix := key
+			if len(prefix) > 0 {
+				prefix += ","
+			}
+			for i, bucket := range h.buckets {
+				fmt.Fprintf(w, "%s_bucket{%sle=\"%g\"} %d\n", name, prefix, bucket, h.counts[i])
+			}
+			fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, prefix, h.count)
+			fmt.Fprintf(w, "%s_sum%s %g\n", name, braces(key), h.sum)
+			fmt.Fprintf(w, "%s_count%s %d\n", name, braces(key), h.count)
+		}
+	}
+	writeSeries(w, "gauge", r.gauges)
+	writeSeries(w, "counter", r.counters)
+}
+
+func writeSeries(w io.Writer, metricType string, metrics map[string]map[string]float64) {
+	var names = make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+		series := metrics[name]
+		var keys = make([]string, 0, len(series))
+		for key := range series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s%s %g\n", name, braces(key), series[key])
+		}
+	}
+}
+
+func braces(labels string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	return "{" + labels + "}"
+}
@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"bytes"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// Email sends the alerts through an SMTP server, authenticated when the
+// Username is set. The PLAIN auth is only used over TLS or to localhost.
+type Email struct {
+	Server   string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (e *Email) Name() string {
+	return "email"
+}
+
+func (e *Email) Send(alert Alert) error {
+	var auth smtp.Auth
+	if len(e.Username) > 0 {
+		host, _, _ := net.SplitHostPort(e.Server)
+		auth = smtp.PlainAuth("", e.Username, e.Password, host)
+	}
+	return smtp.SendMail(e.Server, auth, e.From, e.To, e.newMessage(alert, time.Now()))
+}
+
+func (e *Email) newMessage(alert Alert, now time.Time) []byte {
+	hostname, _ := os.Hostname()
+	subject := "[FIRING] " + alert.Summary
+	if alert.Resolved {
+		subject = "[RESOLVED] " + alert.Summary
+	}
+	var message bytes.Buffer
+	message.WriteString("From: " + e.From + "\r\n")
+	message.WriteString("To: " + strings.Join(e.To, ", ") + "\r\n")
+	message.WriteString("Subject: " + subject + "\r\n")
+	message.WriteString("Date: " + now.Format(time.RFC1123Z) + "\r\n")
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	message.WriteString("\r\n")
+	message.WriteString("Host: " + hostname + "\r\n")
+	message.WriteString("Failing since: " + alert.Since.UTC().Format(time.RFC3339) + "\r\n")
+	if alert.Resolved {
+		message.WriteString("Resolved at: " + now.UTC().Format(time.RFC3339) + "\r\n")
+	}
+	if len(alert.Details) > 0 {
+		message.WriteString("\r\n" + strings.Replace(alert.Details, "\n", "\r\n", -1) + "\r\n")
+	}
+	return message.Bytes()
+}
@@ -0,0 +1,105 @@
+// Package notify sends the persistent failures of the service registration to
+// the notification channels, e.g. email.
+package notify
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	QUEUE_SIZE        = 100
+	SEVERITY_CRITICAL = "critical"
+	SEVERITY_ERROR    = "error"
+)
+
+// Alert is a failure which lasted for its period. The resolved alerts are
+// sent once the failure is over.
+type Alert struct {
+	Key      string
+	Severity string
+	Summary  string
+	Details  string
+	Since    time.Time
+	Resolved bool
+}
+
+// Channel delivers the alerts, e.g. as an email.
+type Channel interface {
+	Name() string
+	Send(alert Alert) error
+}
+
+// Notifier tracks the failure conditions and sends an alert to every channel
+// when a condition lasted for its period, and again when it clears. The
+// alerts are sent in order by a single goroutine, so a slow channel cannot
+// delay the service checks.
+type Notifier struct {
+	Channels []Channel
+	lock     sync.Mutex
+	failing  map[string]time.Time
+	firing   map[string]Alert
+	queue    chan Alert
+}
+
+func NewNotifier(channels []Channel) *Notifier {
+	n := &Notifier{
+		Channels: channels,
+		failing:  make(map[string]time.Time),
+		firing:   make(map[string]Alert),
+		queue:    make(chan Alert, QUEUE_SIZE),
+	}
+	go n.run()
+	return n
+}
+
+// Check updates the condition of the alert with the key. The failing
+// condition fires the alert once it has held for the period since the first
+// failing check, a passing one resolves the fired alert.
+func (n *Notifier) Check(alert Alert, failing bool, period time.Duration, now time.Time) {
+	if n == nil {
+		return
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if !failing {
+		delete(n.failing, alert.Key)
+		if fired, ok := n.firing[alert.Key]; ok {
+			delete(n.firing, alert.Key)
+			fired.Resolved = true
+			fired.Details = alert.Details
+			n.enqueue(fired)
+		}
+		return
+	}
+	since, ok := n.failing[alert.Key]
+	if !ok {
+		since = now
+		n.failing[alert.Key] = since
+	}
+	if _, fired := n.firing[alert.Key]; fired || now.Sub(since) < period {
+		return
+	}
+	alert.Since = since
+	n.firing[alert.Key] = alert
+	n.enqueue(alert)
+}
+
+func (n *Notifier) enqueue(alert Alert) {
+	select {
+	case n.queue <- alert:
+	default:
+		log.Println("Notification queue is full, dropping the alert: " + alert.Summary)
+	}
+}
+
+func (n *Notifier) run() {
+	for alert := range n.queue {
+		for _, channel := range n.Channels {
+			if err := channel.Send(alert); err != nil {
+				log.Printf("Failed to send the alert '%s' to %s: %s", alert.Summary, channel.Name(), err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+)
+
+const PAGERDUTY_EVENTS_URL = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDuty sends the critical alerts as Events API v2 events. A fired alert
+// triggers an incident deduplicated by the alert key and the host, and its
+// resolution resolves the incident.
+type PagerDuty struct {
+	HTTP       *http.Client
+	URL        string
+	RoutingKey string
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Timestamp     string            `json:"timestamp"`
+	Component     string            `json:"component"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+func NewPagerDuty(httpClient *http.Client, routingKey string) *PagerDuty {
+	return &PagerDuty{HTTP: httpClient, URL: PAGERDUTY_EVENTS_URL, RoutingKey: routingKey}
+}
+
+func (p *PagerDuty) Name() string {
+	return "PagerDuty"
+}
+
+// Send ignores the alerts which are not critical.
+func (p *PagerDuty) Send(alert Alert) error {
+	if alert.Severity != SEVERITY_CRITICAL {
+		return nil
+	}
+	hostname, _ := os.Hostname()
+	event := pagerDutyEvent{RoutingKey: p.RoutingKey, EventAction: "trigger", DedupKey: alert.Key + "@" + hostname}
+	if alert.Resolved {
+		event.EventAction = "resolve"
+	} else {
+		event.Payload = &pagerDutyPayload{
+			Summary:   alert.Summary + " on " + hostname,
+			Source:    hostname,
+			Severity:  alert.Severity,
+			Timestamp: alert.Since.UTC().Format(time.RFC3339),
+			Component: "service-registration",
+		}
+		if len(alert.Details) > 0 {
+			event.Payload.CustomDetails = map[string]string{"details": alert.Details}
+		}
+	}
+	content, _ := json.Marshal(event)
+	req, _ := http.NewRequest("POST", p.URL, bytes.NewReader(content))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer httpclient.CloseBody(resp)
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.New("PagerDuty responded with status " + strconv.Itoa(resp.StatusCode) + ": " + string(body))
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/hortonworks/cloudbreak-service-registration/admin"
+	"github.com/hortonworks/cloudbreak-service-registration/config"
+)
+
+// setPaused pauses or resumes the reconciliation of the running service
+// registration through its admin API.
+func setPaused(profile string, paused bool) error {
+	address := os.Getenv(ENV_ADMIN_API_ADDRESS)
+	if len(address) == 0 {
+		return errors.New("The admin API is not enabled, " + ENV_ADMIN_API_ADDRESS + " is not set")
+	}
+	conf, err := config.LoadProfile(getConfigPath(), profile)
+	if err != nil {
+		return err
+	}
+	client, err := admin.NewClient(address, conf.APIAuth, admin.TLSConfig{
+		CertFile:     os.Getenv(ENV_ADMIN_API_CERT),
+		KeyFile:      os.Getenv(ENV_ADMIN_API_KEY),
+		ClientCAFile: os.Getenv(ENV_ADMIN_API_CLIENT_CA),
+	})
+	if err != nil {
+		return err
+	}
+	state, err := client.SetPaused(paused)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Paused: %t\n", state)
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// lockPidFile takes an exclusive lock on the PID file and writes the PID of
+// the process into it, so a second instance refuses to start. The lock is
+// held until the returned file is closed or the process exits.
+func lockPidFile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, errors.New("Another instance is already running, PID file is locked: " + path)
+		}
+		return nil, err
+	}
+	if err := file.Truncate(0); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func unlockPidFile(file *os.File) {
+	os.Remove(file.Name())
+	file.Close()
+}
+
+func getPidFilePath() string {
+	path := os.Getenv(ENV_PID_FILE_PATH)
+	if len(path) == 0 {
+		path = "/var/run/" + App + ".pid"
+	}
+	return path
+}
@@ -0,0 +1,44 @@
+package reconciler
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/backend"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+// publishToBackends publishes the desired instances to the backends when the
+// components changed or the previous publishing failed. Nothing is published
+// in a maintenance window, the backends are brought up to date after it.
+func (r *Reconciler) publishToBackends(ctx context.Context, components []topology.HostComponent, changed bool) {
+	if len(r.Backends) == 0 || (!changed && r.backendsPublished) {
+		return
+	}
+	if windowMode := r.Config.GetActiveWindowMode(time.Now()); len(windowMode) > 0 {
+		r.logger.Println("Maintenance window is active, publishing to the backends is paused")
+		r.backendsPublished = false
+		return
+	}
+	r.backendsPublished = backend.PublishAll(ctx, r.Backends, r.getInstances(components)) == nil
+}
+
+func (r *Reconciler) getInstances(components []topology.HostComponent) []backend.Instance {
+	var instances = make([]backend.Instance, 0, len(components))
+	for _, component := range components {
+		service := r.newService(component)
+		instances = append(instances, backend.Instance{
+			ID:      service.ID,
+			Name:    service.Name,
+			Address: service.Address,
+			Port:    service.Port,
+			Tags:    service.Tags,
+			Meta:    service.Meta,
+			Cluster: component.Cluster,
+			State:   component.State,
+			Healthy: strings.ToUpper(component.State) == "STARTED" && !component.Maintenance,
+		})
+	}
+	return instances
+}
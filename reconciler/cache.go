@@ -0,0 +1,246 @@
+package reconciler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/config"
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+// StateCache keeps the Ambari components and Consul registrations seen in the
+// previous service check, so unchanged parts don't have to be fetched and
+// compared again. A nil cache disables caching. When a path is set the cache
+// is persisted, so a restarted daemon can start diffing from the last snapshot.
+type StateCache struct {
+	components  map[string]topology.HostComponent
+	services    []consul.Service
+	consulIndex string
+	path        string
+	throttled   map[string]throttledComponent
+	states      map[string]observedState
+}
+
+type throttledComponent struct {
+	component topology.HostComponent
+	syncedAt  time.Time
+}
+
+// observedState is the reported state of a component, and the new state
+// waiting for the hysteresis with the number of checks it was observed in.
+type observedState struct {
+	reported  string
+	candidate string
+	observed  int
+}
+
+type stateSnapshot struct {
+	Components  map[string]topology.HostComponent `json:"components"`
+	Services    []consul.Service                  `json:"services"`
+	ConsulIndex string                            `json:"consulIndex"`
+}
+
+func NewStateCache() *StateCache {
+	return &StateCache{
+		components: make(map[string]topology.HostComponent),
+		throttled:  make(map[string]throttledComponent),
+		states:     make(map[string]observedState),
+	}
+}
+
+func LoadStateCache(path string) *StateCache {
+	cache := NewStateCache()
+	cache.path = path
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Cannot read state file: " + err.Error())
+		}
+		return cache
+	}
+	var snapshot stateSnapshot
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		log.Println("Cannot parse state file, starting with an empty state: " + err.Error())
+		return cache
+	}
+	if snapshot.Components != nil {
+		cache.components = snapshot.Components
+	}
+	cache.services = snapshot.Services
+	cache.consulIndex = snapshot.ConsulIndex
+	log.Printf("Loaded state from %s, components: %d, services: %d", path, len(cache.components), len(cache.services))
+	return cache
+}
+
+func (c *StateCache) save() {
+	if c == nil || len(c.path) == 0 {
+		return
+	}
+	content, _ := json.Marshal(stateSnapshot{
+		Components:  c.components,
+		Services:    c.services,
+		ConsulIndex: c.consulIndex,
+	})
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		log.Println("Cannot create state directory: " + err.Error())
+		return
+	}
+	tmp := c.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		log.Println("Cannot write state file: " + err.Error())
+		return
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		log.Println("Cannot write state file: " + err.Error())
+	}
+}
+
+func (c *StateCache) CachedServices(consulIndex string) ([]consul.Service, bool) {
+	if c == nil || len(consulIndex) == 0 || consulIndex != c.consulIndex || c.services == nil {
+		return nil, false
+	}
+	return c.services, true
+}
+
+func (c *StateCache) UpdateServices(consulIndex string, services []consul.Service) {
+	if c == nil {
+		return
+	}
+	c.consulIndex = consulIndex
+	c.services = services
+}
+
+func (c *StateCache) invalidateServices() {
+	c.consulIndex = ""
+	c.services = nil
+}
+
+// updateComponents stores the current component set and returns the components
+// which are new or changed compared to the previous one, and whether anything
+// changed at all including removed components.
+func (c *StateCache) updateComponents(components []topology.HostComponent) ([]topology.HostComponent, bool) {
+	var changedComponents = make([]topology.HostComponent, 0)
+	var current = make(map[string]topology.HostComponent)
+	for _, component := range components {
+		key := component.Key()
+		current[key] = component
+		if previous, ok := c.components[key]; !ok || previous != component {
+			changedComponents = append(changedComponents, component)
+		}
+	}
+	changed := len(changedComponents) > 0 || len(current) != len(c.components)
+	c.components = current
+	return changedComponents, changed
+}
+
+// throttleComponents applies the per component update intervals: every
+// component is listed by the source on every service check, but until its
+// interval elapses it is reported as it was at its last update, so its
+// registration is not updated or removed in between. The components without an
+// interval get the default one, which is set when the service checks are more
+// frequent than the poll interval for a faster component.
+func (c *StateCache) throttleComponents(conf *config.Config, components []topology.HostComponent, defaultInterval time.Duration,
+	now time.Time) []topology.HostComponent {
+	if len(conf.ComponentUpdateIntervals) == 0 {
+		return components
+	}
+	var result = make([]topology.HostComponent, 0, len(components))
+	var seen = make(map[string]bool)
+	for _, component := range components {
+		key := component.Key()
+		seen[key] = true
+		result = append(result, c.throttle(conf, key, &component, defaultInterval, now)...)
+	}
+	for key := range c.throttled {
+		if !seen[key] {
+			result = append(result, c.throttle(conf, key, nil, defaultInterval, now)...)
+		}
+	}
+	return result
+}
+
+func (c *StateCache) throttle(conf *config.Config, key string, component *topology.HostComponent, defaultInterval time.Duration,
+	now time.Time) []topology.HostComponent {
+	previous, synced := c.throttled[key]
+	name := previous.component.HostComponent
+	if component != nil {
+		name = component.HostComponent
+	}
+	interval := conf.GetComponentUpdateInterval(name)
+	if interval == 0 {
+		interval = defaultInterval
+	}
+	if synced && interval > 0 && now.Sub(previous.syncedAt) < interval {
+		return []topology.HostComponent{previous.component}
+	}
+	if component == nil {
+		delete(c.throttled, key)
+		return nil
+	}
+	if interval > 0 {
+		c.throttled[key] = throttledComponent{component: *component, syncedAt: now}
+	}
+	return []topology.HostComponent{*component}
+}
+
+// stabilizeStates applies the state hysteresis: a new state of a component is
+// reported once it was observed in the configured number of consecutive
+// service checks, until then the component keeps its reported state. The
+// first state of a component is reported at once. It returns whether a state
+// change is pending, so the next checks can come sooner.
+func (c *StateCache) stabilizeStates(conf *config.Config, components []topology.HostComponent) ([]topology.HostComponent, bool) {
+	if len(conf.StateHysteresis) == 0 {
+		c.states = nil
+		return components, false
+	}
+	pending := false
+	var states = make(map[string]observedState, len(components))
+	var result = make([]topology.HostComponent, 0, len(components))
+	for _, component := range components {
+		key := component.Key()
+		state, known := c.states[key]
+		cycles := conf.GetStateHysteresis(component.HostComponent)
+		switch {
+		case !known || state.reported == component.State || cycles <= 1:
+			state = observedState{reported: component.State}
+		case state.candidate == component.State:
+			state.observed++
+		default:
+			state.candidate = component.State
+			state.observed = 1
+		}
+		if len(state.candidate) > 0 && state.observed >= cycles {
+			state = observedState{reported: state.candidate}
+		}
+		if len(state.candidate) > 0 {
+			log.Printf("State of %s on %s is %s, keeping %s until it is observed in %d more service checks", component.HostComponent, component.Hostname, state.candidate, state.reported, cycles-state.observed)
+			component.State = state.reported
+			pending = true
+		}
+		states[key] = state
+		result = append(result, component)
+	}
+	c.states = states
+	return result, pending
+}
+
+// withObservedStates returns the components with the states observed in the
+// last service check instead of the ones held back by the state hysteresis.
+func (c *StateCache) withObservedStates(components []topology.HostComponent) []topology.HostComponent {
+	if len(c.states) == 0 {
+		return components
+	}
+	var result = make([]topology.HostComponent, 0, len(components))
+	for _, component := range components {
+		if state, ok := c.states[component.Key()]; ok && len(state.candidate) > 0 {
+			component.State = state.candidate
+		}
+		result = append(result, component)
+	}
+	return result
+}
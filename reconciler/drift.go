@@ -0,0 +1,79 @@
+package reconciler
+
+import (
+	"strings"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/metrics"
+)
+
+// DriftEntry is a component or a registration out of sync since the first
+// service check which found it so.
+type DriftEntry struct {
+	ViewEntry
+	Since time.Time `json:"since"`
+}
+
+// recordDrift compares the components and the registrations of the service
+// check, and keeps the entries which are missing, drifted or orphaned. The
+// entries the reconciler leaves alone are not drift: the components in
+// UNKNOWN state and the pinned services.
+func (r *Reconciler) recordDrift(now time.Time) {
+	r.lock.Lock()
+	previous := r.drift
+	r.lock.Unlock()
+	var since = make(map[string]time.Time, len(previous))
+	for _, entry := range previous {
+		since[getDriftKey(entry.ViewEntry)] = entry.Since
+	}
+
+	var drift = make([]DriftEntry, 0)
+	var counts = map[string]int{STATUS_MISSING: 0, STATUS_DRIFTED: 0, STATUS_ORPHANED: 0}
+	var oldest = make(map[string]time.Time)
+	for _, entry := range r.View() {
+		if entry.Status == STATUS_REGISTERED || strings.ToUpper(entry.State) == "UNKNOWN" {
+			continue
+		}
+		if _, pinned := r.Pins.Get(entry.ServiceID); pinned {
+			continue
+		}
+		first, ok := since[getDriftKey(entry)]
+		if !ok {
+			first = now
+		}
+		drift = append(drift, DriftEntry{ViewEntry: entry, Since: first})
+		counts[entry.Status]++
+		if previous, ok := oldest[entry.Status]; !ok || first.Before(previous) {
+			oldest[entry.Status] = first
+		}
+	}
+
+	var samples = make([]metrics.Sample, 0, len(counts))
+	var ages = make([]metrics.Sample, 0, len(counts))
+	for status, count := range counts {
+		labels := metrics.Labels{"status": status}
+		samples = append(samples, metrics.Sample{Labels: labels, Value: float64(count)})
+		var age float64
+		if first, ok := oldest[status]; ok {
+			age = now.Sub(first).Seconds()
+		}
+		ages = append(ages, metrics.Sample{Labels: labels, Value: age})
+	}
+	metrics.Default.SetGauge(metrics.DRIFT, samples)
+	metrics.Default.SetGauge(metrics.DRIFT_AGE, ages)
+
+	r.lock.Lock()
+	r.drift = drift
+	r.lock.Unlock()
+}
+
+// Drift returns the entries out of sync in the last service check.
+func (r *Reconciler) Drift() []DriftEntry {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.drift
+}
+
+func getDriftKey(entry ViewEntry) string {
+	return entry.Status + "@" + entry.ServiceID + "@" + entry.IP
+}
@@ -0,0 +1,153 @@
+package reconciler
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+	"github.com/hortonworks/cloudbreak-service-registration/metrics"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+// MAX_FAILURE_SERIES bounds the series of the consecutive failures gauge to
+// the top offenders.
+const MAX_FAILURE_SERIES = 20
+
+// ServiceFailures counts the consecutive failed writes of a service, both the
+// writes rejected by its agent and the ones which could not reach it.
+type ServiceFailures struct {
+	ServiceID    string    `json:"service_id"`
+	Service      string    `json:"service"`
+	Address      string    `json:"address"`
+	Operation    string    `json:"operation"`
+	Consecutive  int       `json:"consecutive"`
+	Rejected     bool      `json:"rejected"`
+	LastError    string    `json:"last_error"`
+	FirstFailure time.Time `json:"first_failure"`
+	LastFailure  time.Time `json:"last_failure"`
+}
+
+// FailureTracker keeps the consecutive write failures per service, a
+// successful write of the service resets its count.
+type FailureTracker struct {
+	lock     sync.Mutex
+	services map[string]ServiceFailures
+}
+
+func NewFailureTracker() *FailureTracker {
+	return &FailureTracker{services: make(map[string]ServiceFailures)}
+}
+
+// Record updates the counts with the result of the writes of an operation.
+func (t *FailureTracker) Record(operation string, services []consul.Service, failures []consul.WriteFailure, now time.Time) {
+	if t == nil || len(services) == 0 {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var failedIDs = make(map[string]bool, len(failures))
+	for _, failure := range failures {
+		id := getWriteID(failure.Service)
+		failedIDs[id] = true
+		entry, ok := t.services[id]
+		if !ok || entry.Operation != operation {
+			entry = ServiceFailures{ServiceID: id, Operation: operation, FirstFailure: now}
+		}
+		entry.Service = failure.Service.Name
+		if len(entry.Service) == 0 {
+			entry.Service = failure.Service.ServiceName
+		}
+		entry.Address = failure.Service.Address
+		entry.Consecutive++
+		entry.Rejected = failure.Rejected
+		entry.LastError = failure.Error
+		entry.LastFailure = now
+		t.services[id] = entry
+	}
+	for _, service := range services {
+		if id := getWriteID(service); !failedIDs[id] {
+			delete(t.services, id)
+		}
+	}
+	t.recordTop()
+}
+
+// Remove drops the counts of the services which are no longer written to.
+func (t *FailureTracker) Remove(ids []string) {
+	if t == nil || len(ids) == 0 {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, id := range ids {
+		delete(t.services, id)
+	}
+	t.recordTop()
+}
+
+// Top returns the services with the most consecutive failures first, at most
+// limit of them unless the limit is not positive.
+func (t *FailureTracker) Top(limit int) []ServiceFailures {
+	if t == nil {
+		return nil
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.getTop(limit)
+}
+
+func (t *FailureTracker) getTop(limit int) []ServiceFailures {
+	var top = make([]ServiceFailures, 0, len(t.services))
+	for _, entry := range t.services {
+		top = append(top, entry)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Consecutive != top[j].Consecutive {
+			return top[i].Consecutive > top[j].Consecutive
+		}
+		return top[i].ServiceID < top[j].ServiceID
+	})
+	if limit > 0 && len(top) > limit {
+		top = top[:limit]
+	}
+	return top
+}
+
+func (t *FailureTracker) recordTop() {
+	var samples = make([]metrics.Sample, 0)
+	for _, entry := range t.getTop(MAX_FAILURE_SERIES) {
+		labels := metrics.Labels{
+			"service_id": entry.ServiceID,
+			"address":    entry.Address,
+			"operation":  entry.Operation,
+			"rejected":   strconv.FormatBool(entry.Rejected),
+		}
+		samples = append(samples, metrics.Sample{Labels: labels, Value: float64(entry.Consecutive)})
+	}
+	metrics.Default.SetGauge(metrics.CONSECUTIVE_FAILURES, samples)
+}
+
+// pruneFailures drops the counts of the writes which are no longer needed: the
+// registrations of the services which are not desired anymore and the
+// deregistrations of the services which are gone from the catalog.
+func (r *Reconciler) pruneFailures(components []topology.HostComponent, consulServices []consul.Service) {
+	failures := r.Failures.Top(0)
+	if len(failures) == 0 {
+		return
+	}
+	desired := r.getDesiredServices(components)
+	var registered = make(map[string]bool, len(consulServices))
+	for _, service := range consulServices {
+		registered[service.ServiceID] = true
+	}
+	var obsolete = make([]string, 0)
+	for _, entry := range failures {
+		_, isDesired := desired[entry.ServiceID]
+		if (entry.Operation == OPERATION_REGISTER && !isDesired) || (entry.Operation == OPERATION_DEREGISTER && !registered[entry.ServiceID]) {
+			obsolete = append(obsolete, entry.ServiceID)
+		}
+	}
+	r.Failures.Remove(obsolete)
+}
@@ -0,0 +1,155 @@
+package reconciler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+const (
+	DEFAULT_HISTORY_SIZE      = 50
+	DEFAULT_HISTORY_RETENTION = 24 * time.Hour
+	REMOVED_STATE             = "REMOVED"
+)
+
+type Transition struct {
+	Time time.Time `json:"time"`
+	From string    `json:"from"`
+	To   string    `json:"to"`
+}
+
+// ComponentHistory holds the last state transitions of a component, the
+// oldest first.
+type ComponentHistory struct {
+	Component   string       `json:"component"`
+	Host        string       `json:"host"`
+	Cluster     string       `json:"cluster,omitempty"`
+	State       string       `json:"state"`
+	Transitions []Transition `json:"transitions"`
+}
+
+// History keeps a bounded ring buffer of the state transitions of every
+// component. The history of a removed component is dropped after the
+// retention period.
+type History struct {
+	Size      int
+	Retention time.Duration
+
+	lock       sync.Mutex
+	components map[string]*componentRing
+}
+
+type componentRing struct {
+	component topology.HostComponent
+	state     string
+	ring      []Transition
+	next      int
+	full      bool
+}
+
+func NewHistory(size int) *History {
+	if size <= 0 {
+		size = DEFAULT_HISTORY_SIZE
+	}
+	return &History{Size: size, Retention: DEFAULT_HISTORY_RETENTION, components: make(map[string]*componentRing)}
+}
+
+// Record compares the states of the components with the previous ones and
+// stores the transitions.
+func (h *History) Record(components []topology.HostComponent, now time.Time) {
+	if h == nil {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	var seen = make(map[string]bool)
+	for _, component := range components {
+		key := component.Key()
+		seen[key] = true
+		c, ok := h.components[key]
+		if !ok {
+			c = &componentRing{ring: make([]Transition, h.Size)}
+			h.components[key] = c
+		}
+		c.component = component
+		if !ok || c.state != component.State {
+			c.add(Transition{Time: now, From: c.state, To: component.State})
+			c.state = component.State
+		}
+	}
+	for key, c := range h.components {
+		if seen[key] {
+			continue
+		}
+		if c.state != REMOVED_STATE {
+			c.add(Transition{Time: now, From: c.state, To: REMOVED_STATE})
+			c.state = REMOVED_STATE
+		} else if now.Sub(c.last().Time) > h.Retention {
+			delete(h.components, key)
+		}
+	}
+}
+
+// Get returns the histories with transitions after since, optionally
+// narrowed to a component or a host.
+func (h *History) Get(componentName string, host string, since time.Time) []ComponentHistory {
+	var histories = make([]ComponentHistory, 0)
+	if h == nil {
+		return histories
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, c := range h.components {
+		if (len(componentName) > 0 && componentName != c.component.HostComponent) || (len(host) > 0 && host != c.component.Hostname) {
+			continue
+		}
+		var transitions = make([]Transition, 0)
+		for _, t := range c.transitions() {
+			if !t.Time.Before(since) {
+				transitions = append(transitions, t)
+			}
+		}
+		if len(transitions) > 0 {
+			histories = append(histories, ComponentHistory{
+				Component:   c.component.HostComponent,
+				Host:        c.component.Hostname,
+				Cluster:     c.component.Cluster,
+				State:       c.state,
+				Transitions: transitions,
+			})
+		}
+	}
+	return histories
+}
+
+// LastChange returns the time of the last state transition of the component,
+// or the zero time if it is not known.
+func (h *History) LastChange(component topology.HostComponent) time.Time {
+	if h == nil {
+		return time.Time{}
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if c, ok := h.components[component.Key()]; ok {
+		return c.last().Time
+	}
+	return time.Time{}
+}
+
+func (c *componentRing) add(t Transition) {
+	c.ring[c.next] = t
+	c.next = (c.next + 1) % len(c.ring)
+	c.full = c.full || c.next == 0
+}
+
+func (c *componentRing) last() Transition {
+	return c.ring[(c.next+len(c.ring)-1)%len(c.ring)]
+}
+
+func (c *componentRing) transitions() []Transition {
+	if !c.full {
+		return append([]Transition{}, c.ring[:c.next]...)
+	}
+	return append(append([]Transition{}, c.ring[c.next:]...), c.ring[:c.next]...)
+}
@@ -0,0 +1,49 @@
+package reconciler
+
+import (
+	"context"
+
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+)
+
+// Hooks are called on the lifecycle events of the reconciliation. Every hook
+// is optional. PreDeregister gets the deregistrations of a service check
+// before they are sent, with the number of the registered services.
+// PostRegister and PostDeregister get the successful writes only.
+type Hooks struct {
+	PreSync        func(ctx context.Context)
+	PostRegister   func(services []consul.Service)
+	PreDeregister  func(services []consul.Service, registered int)
+	PostDeregister func(services []consul.Service)
+	PostSync       func(changed bool, err error)
+}
+
+func (h Hooks) preSync(ctx context.Context) {
+	if h.PreSync != nil {
+		h.PreSync(ctx)
+	}
+}
+
+func (h Hooks) postRegister(services []consul.Service) {
+	if h.PostRegister != nil {
+		h.PostRegister(services)
+	}
+}
+
+func (h Hooks) preDeregister(services []consul.Service, registered int) {
+	if h.PreDeregister != nil {
+		h.PreDeregister(services, registered)
+	}
+}
+
+func (h Hooks) postDeregister(services []consul.Service) {
+	if h.PostDeregister != nil {
+		h.PostDeregister(services)
+	}
+}
+
+func (h Hooks) postSync(changed bool, err error) {
+	if h.PostSync != nil {
+		h.PostSync(changed, err)
+	}
+}
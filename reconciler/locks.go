@@ -0,0 +1,46 @@
+package reconciler
+
+import (
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+// filterLockedClusters takes the locks of the clusters having components or
+// registrations, and drops the components and the registrations of the
+// clusters locked by another instance, so they are neither registered nor
+// deregistered by this one.
+func (r *Reconciler) filterLockedClusters(components []topology.HostComponent,
+	services []consul.Service) ([]topology.HostComponent, []consul.Service) {
+	var clusters = make([]string, 0)
+	var seen = make(map[string]bool)
+	addCluster := func(cluster string) {
+		if !seen[cluster] {
+			clusters = append(clusters, cluster)
+			seen[cluster] = true
+		}
+	}
+	for _, component := range components {
+		addCluster(component.Cluster)
+	}
+	for _, service := range services {
+		addCluster(consul.GetCluster(service))
+	}
+	held := r.Locks.Acquire(clusters)
+
+	var filteredComponents = make([]topology.HostComponent, 0, len(components))
+	for _, component := range components {
+		if held[component.Cluster] {
+			filteredComponents = append(filteredComponents, component)
+		}
+	}
+	if skipped := len(components) - len(filteredComponents); skipped > 0 {
+		r.logger.Printf("Skipped %d components of the clusters locked by another instance", skipped)
+	}
+	var filteredServices = make([]consul.Service, 0, len(services))
+	for _, service := range services {
+		if held[consul.GetCluster(service)] {
+			filteredServices = append(filteredServices, service)
+		}
+	}
+	return filteredComponents, filteredServices
+}
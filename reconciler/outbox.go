@@ -0,0 +1,234 @@
+package reconciler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+// OutboxWrite is a register or deregister request of a change set, Done is
+// set once it was sent, whether or not the agent could be written to.
+type OutboxWrite struct {
+	Operation string         `json:"operation"`
+	Service   consul.Service `json:"service"`
+	Done      bool           `json:"done,omitempty"`
+}
+
+// Outbox holds the change set of a cycle. The change set is written to the
+// file before any of it is applied, and the progress is written after each
+// agent, so the writes interrupted by a restart or deferred by the write
+// limit are resumed by the next cycle exactly where they stopped.
+type Outbox struct {
+	Cycle   string        `json:"cycle"`
+	Created time.Time     `json:"created"`
+	Writes  []OutboxWrite `json:"writes"`
+	path    string
+}
+
+func NewOutbox() *Outbox {
+	return &Outbox{Writes: make([]OutboxWrite, 0)}
+}
+
+func LoadOutbox(path string) *Outbox {
+	outbox := NewOutbox()
+	outbox.path = path
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Cannot read outbox file: " + err.Error())
+		}
+		return outbox
+	}
+	if err := json.Unmarshal(content, outbox); err != nil {
+		log.Println("Cannot parse outbox file, starting with an empty outbox: " + err.Error())
+		return NewOutbox()
+	}
+	if pending := len(outbox.pending()); pending > 0 {
+		log.Printf("Loaded the change set of cycle %s, pending writes: %d", outbox.Cycle, pending)
+	}
+	return outbox
+}
+
+// begin replaces the change set with the one of the cycle.
+func (o *Outbox) begin(cycle string, writes []OutboxWrite) {
+	o.Cycle = cycle
+	o.Created = time.Now()
+	o.Writes = writes
+	o.save()
+}
+
+// pending returns the indexes of the writes not sent yet.
+func (o *Outbox) pending() []int {
+	var pending = make([]int, 0)
+	for i, write := range o.Writes {
+		if !write.Done {
+			pending = append(pending, i)
+		}
+	}
+	return pending
+}
+
+func (o *Outbox) save() {
+	if len(o.path) == 0 {
+		return
+	}
+	if len(o.pending()) == 0 {
+		o.Writes = make([]OutboxWrite, 0)
+	}
+	content, _ := json.Marshal(o)
+	if err := os.MkdirAll(filepath.Dir(o.path), 0755); err != nil {
+		log.Println("Cannot create outbox directory: " + err.Error())
+		return
+	}
+	tmp := o.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		log.Println("Cannot write outbox file: " + err.Error())
+		return
+	}
+	if err := os.Rename(tmp, o.path); err != nil {
+		log.Println("Cannot write outbox file: " + err.Error())
+	}
+}
+
+// resumeOutbox sends the pending writes of an earlier cycle which are still
+// needed with the current components, the registrations with the current
+// definition of their service. Nothing is resumed in a maintenance window.
+// It returns whether any write was sent.
+func (r *Reconciler) resumeOutbox(components []topology.HostComponent) bool {
+	pending := r.Outbox.pending()
+	if len(pending) == 0 || len(r.Config.GetActiveWindowMode(time.Now())) > 0 {
+		return false
+	}
+	desired := r.getDesiredServices(components)
+	dropped := 0
+	for _, i := range pending {
+		write := &r.Outbox.Writes[i]
+		service, isDesired := desired[getWriteID(write.Service)]
+		switch {
+		case write.Operation == OPERATION_REGISTER && isDesired:
+			write.Service = service
+		case write.Operation == OPERATION_DEREGISTER && !isDesired:
+		default:
+			write.Done = true
+			dropped++
+		}
+	}
+	r.logger.Printf("Resuming the change set of cycle %s, pending writes: %d, no longer needed: %d", r.Outbox.Cycle, len(pending)-dropped, dropped)
+	return r.applyOutbox() > 0
+}
+
+// applyOutbox sends the pending writes of the outbox agent by agent and
+// records the progress after each agent. The writes of an agent go through
+// the worker pool of the Consul client, so at most its pool size of writes
+// are in flight. At most the remaining write budget of the cycle is sent, the
+// rest is left pending. The writes which failed to reach their agent are
+// queued for retries, and every failure is counted per service. The post
+// hooks get the successful writes only. It returns the number of writes sent.
+func (r *Reconciler) applyOutbox() int {
+	pending := r.Outbox.pending()
+	if r.writeBudget >= 0 && len(pending) > r.writeBudget {
+		r.logger.Printf("Too many writes: %d, deferring %d to the next service check", len(pending), len(pending)-r.writeBudget)
+		pending = pending[:r.writeBudget]
+	}
+	if len(pending) == 0 {
+		return 0
+	}
+	if r.writeBudget >= 0 {
+		r.writeBudget -= len(pending)
+	}
+
+	var agents = make(map[string][]int)
+	for _, i := range pending {
+		address := r.Outbox.Writes[i].Service.Address
+		agents[address] = append(agents[address], i)
+	}
+	var addresses = make([]string, 0, len(agents))
+	for address := range agents {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	var registered = make([]consul.Service, 0)
+	var deregistered = make([]consul.Service, 0)
+	for _, address := range addresses {
+		writes := agents[address]
+		var register = make([]consul.Service, 0)
+		var deregister = make([]consul.Service, 0)
+		for _, i := range writes {
+			if r.Outbox.Writes[i].Operation == OPERATION_REGISTER {
+				register = append(register, r.Outbox.Writes[i].Service)
+			} else {
+				deregister = append(deregister, r.Outbox.Writes[i].Service)
+			}
+		}
+		var failedRegister, failedDeregister []consul.WriteFailure
+		if len(register) > 0 {
+			failedRegister = r.Consul.Register(register)
+		}
+		if len(deregister) > 0 {
+			failedDeregister = r.Consul.Deregister(deregister)
+		}
+
+		for _, i := range writes {
+			r.Outbox.Writes[i].Done = true
+		}
+		r.Outbox.save()
+		now := time.Now()
+		r.Retries.Record(OPERATION_REGISTER, register, consul.GetRetryable(failedRegister), now)
+		r.Retries.Record(OPERATION_DEREGISTER, deregister, consul.GetRetryable(failedDeregister), now)
+		r.Failures.Record(OPERATION_REGISTER, register, failedRegister, now)
+		r.Failures.Record(OPERATION_DEREGISTER, deregister, failedDeregister, now)
+		registered = append(registered, getSucceeded(register, failedRegister)...)
+		deregistered = append(deregistered, getSucceeded(deregister, failedDeregister)...)
+	}
+
+	if len(registered) > 0 {
+		r.Hooks.postRegister(registered)
+	}
+	if len(deregistered) > 0 {
+		r.Hooks.postDeregister(deregistered)
+	}
+	return len(pending)
+}
+
+// getSucceeded returns the services whose write did not fail.
+func getSucceeded(services []consul.Service, failures []consul.WriteFailure) []consul.Service {
+	if len(failures) == 0 {
+		return services
+	}
+	var failedIDs = make(map[string]bool, len(failures))
+	for _, failure := range failures {
+		failedIDs[getWriteID(failure.Service)] = true
+	}
+	var succeeded = make([]consul.Service, 0, len(services))
+	for _, service := range services {
+		if !failedIDs[getWriteID(service)] {
+			succeeded = append(succeeded, service)
+		}
+	}
+	return succeeded
+}
+
+// getDesiredServices returns the services of the components by ID, without
+// the components in UNKNOWN state and the frozen pins.
+func (r *Reconciler) getDesiredServices(components []topology.HostComponent) map[string]consul.Service {
+	var desired = make(map[string]consul.Service)
+	for _, component := range components {
+		if strings.ToUpper(component.State) == "UNKNOWN" {
+			continue
+		}
+		service := r.newService(component)
+		if !r.isFrozen(service.ID) {
+			desired[service.ID] = service
+		}
+	}
+	return desired
+}
@@ -0,0 +1,124 @@
+package reconciler
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hortonworks/cloudbreak-service-registration/config"
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+// Pins holds the pinned registrations by service ID. The pins of the config
+// are loaded at startup and the admin API can add or remove pins at runtime,
+// which makes the next service check a full check.
+type Pins struct {
+	lock    sync.RWMutex
+	pins    map[string]config.Pin
+	changed bool
+}
+
+func NewPins(pins []config.Pin) *Pins {
+	p := &Pins{pins: make(map[string]config.Pin)}
+	for _, pin := range pins {
+		p.pins[pin.ServiceID] = pin
+	}
+	return p
+}
+
+func (p *Pins) Set(pin config.Pin) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	log.Printf("Service %s pinned, address: '%s', tags: %v, state: '%s'", pin.ServiceID, pin.Address, pin.Tags, pin.State)
+	p.pins[pin.ServiceID] = pin
+	p.changed = true
+}
+
+// Remove unpins the service and returns whether it was pinned.
+func (p *Pins) Remove(serviceID string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, ok := p.pins[serviceID]; !ok {
+		return false
+	}
+	log.Printf("Service %s unpinned", serviceID)
+	delete(p.pins, serviceID)
+	p.changed = true
+	return true
+}
+
+func (p *Pins) Get(serviceID string) (config.Pin, bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	pin, ok := p.pins[serviceID]
+	return pin, ok
+}
+
+func (p *Pins) List() []config.Pin {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	var pins = make([]config.Pin, 0, len(p.pins))
+	for _, pin := range p.pins {
+		pins = append(pins, pin)
+	}
+	sort.Slice(pins, func(i, j int) bool { return pins[i].ServiceID < pins[j].ServiceID })
+	return pins
+}
+
+// takeChanged returns whether the pins changed since the previous call.
+func (p *Pins) takeChanged() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	changed := p.changed
+	p.changed = false
+	return changed
+}
+
+// applyPins sets the pinned address and state on the components of the
+// pinned services.
+func (r *Reconciler) applyPins(components []topology.HostComponent) {
+	for i, component := range components {
+		pin, ok := r.Pins.Get(r.Config.GetServiceID(component))
+		if !ok {
+			continue
+		}
+		if len(pin.Address) > 0 {
+			components[i].IP = pin.Address
+		}
+		if len(pin.State) > 0 {
+			components[i].State = pin.State
+		}
+	}
+}
+
+// isFrozen returns whether the registration is pinned without overrides, so
+// it must be left as it is.
+func (r *Reconciler) isFrozen(serviceID string) bool {
+	pin, ok := r.Pins.Get(serviceID)
+	return ok && !pin.HasOverrides()
+}
+
+// getPinnedTags returns the pinned tags of the service, keeping the ownership
+// tags so the registration stays managed.
+func (r *Reconciler) getPinnedTags(service consul.Service) []string {
+	pin, ok := r.Pins.Get(service.ID)
+	if !ok || len(pin.Tags) == 0 {
+		return service.Tags
+	}
+	var tags = make([]string, 0, len(pin.Tags)+2)
+	var seen = make(map[string]bool)
+	for _, tag := range pin.Tags {
+		if !seen[tag] {
+			tags = append(tags, tag)
+			seen[tag] = true
+		}
+	}
+	for _, tag := range service.Tags {
+		if !seen[tag] && (tag == consul.OWNERSHIP_TAG || strings.HasPrefix(tag, consul.OWNERSHIP_TAG+":")) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
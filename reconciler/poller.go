@@ -0,0 +1,127 @@
+package reconciler
+
+import (
+	"log"
+	"math/rand"
+	"time"
+)
+
+// DEFAULT_JITTER_DIVISOR makes the default jitter a tenth of the sleep.
+const DEFAULT_JITTER_DIVISOR = 10
+
+var random = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// PollSettings configures the Poller. A MaxInterval above the Interval enables
+// adaptive polling. Every sleep is extended by a random Jitter, so the service
+// registrations started at the same time don't all poll Ambari together. Zero
+// means a tenth of the sleep, a negative Jitter disables it.
+type PollSettings struct {
+	Interval    time.Duration
+	MinInterval time.Duration
+	MaxInterval time.Duration
+	MaxBackoff  time.Duration
+	Jitter      time.Duration
+}
+
+// Poller decides how long to sleep between two service checks. By default the
+// interval is fixed, but when a maximum poll interval is configured it polls
+// with the minimum interval while the topology is changing and doubles the
+// interval on every stable cycle until the maximum is reached. Consecutive
+// failed cycles back off exponentially up to the maximum backoff.
+type Poller struct {
+	interval    time.Duration
+	minInterval time.Duration
+	maxInterval time.Duration
+	maxBackoff  time.Duration
+	jitter      time.Duration
+	failures    uint
+
+	defaultUpdateInterval time.Duration
+}
+
+// NewPoller creates a Poller which polls at least as often as the fastest
+// component update interval, zero means no component interval. When that is
+// below the poll interval, DefaultUpdateInterval keeps the other components
+// at the poll interval.
+func NewPoller(settings PollSettings, fastest time.Duration) *Poller {
+	p := &Poller{
+		interval:    settings.Interval,
+		minInterval: settings.Interval,
+		maxInterval: settings.Interval,
+		maxBackoff:  settings.MaxBackoff,
+		jitter:      settings.Jitter,
+	}
+	if settings.MaxInterval > settings.Interval {
+		p.minInterval = settings.MinInterval
+		p.maxInterval = settings.MaxInterval
+		log.Printf("Adaptive polling enabled, interval: %s - %s", p.minInterval, p.maxInterval)
+	}
+	if fastest > 0 && fastest < p.maxInterval {
+		log.Printf("Polling every %s for the component update intervals", fastest)
+		p.defaultUpdateInterval = p.minInterval
+		p.interval = minDuration(p.interval, fastest)
+		p.minInterval = minDuration(p.minInterval, fastest)
+		p.maxInterval = fastest
+	}
+	return p
+}
+
+// DefaultUpdateInterval returns the update interval of the components without
+// an interval of their own, zero when the poller doesn't poll faster than the
+// poll interval for them.
+func (p *Poller) DefaultUpdateInterval() time.Duration {
+	return p.defaultUpdateInterval
+}
+
+func (p *Poller) Next() time.Duration {
+	sleep := p.interval
+	if p.failures > 0 {
+		sleep = p.backoff()
+	}
+	jitter := p.jitter
+	if jitter == 0 {
+		jitter = sleep / DEFAULT_JITTER_DIVISOR
+	}
+	if jitter > 0 {
+		sleep += time.Duration(random.Int63n(int64(jitter)))
+	}
+	return sleep
+}
+
+func (p *Poller) backoff() time.Duration {
+	backoff := p.interval
+	for i := uint(0); i < p.failures && backoff < p.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.maxBackoff {
+		backoff = p.maxBackoff
+	}
+	return backoff
+}
+
+func (p *Poller) Fail() {
+	p.failures++
+	log.Printf("Service check failed %d times in a row, backing off", p.failures)
+}
+
+func (p *Poller) Update(changed bool) {
+	if p.failures > 0 {
+		log.Printf("Service check succeeded after %d failures", p.failures)
+		p.failures = 0
+	}
+	if changed {
+		p.interval = p.minInterval
+		return
+	}
+	p.interval *= 2
+	if p.interval > p.maxInterval {
+		p.interval = p.maxInterval
+	}
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,555 @@
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/ambari"
+	"github.com/hortonworks/cloudbreak-service-registration/backend"
+	"github.com/hortonworks/cloudbreak-service-registration/config"
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+	"github.com/hortonworks/cloudbreak-service-registration/httpclient"
+	"github.com/hortonworks/cloudbreak-service-registration/metrics"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+const (
+	CLUSTER_META_KEY              = "ambari-cluster"
+	STACK_META_KEY                = "cloudbreak-stack"
+	BLUEPRINT_META_KEY            = "blueprint"
+	CLOUD_PLATFORM_META_KEY       = "cloud-platform"
+	INSTANCE_GROUP_META_KEY       = "instance-group"
+	MAINTENANCE_META_KEY          = "ambari-maintenance"
+	NODE_META_RACK                = "rack"
+	NODE_META_OS                  = "os"
+	NODE_META_HOST_STATE          = "ambari-host-state"
+	DEFAULT_SERVICE_PORT          = 1080
+	DEFAULT_HEALTH_CHECK_INTERVAL = 10 * time.Second
+	DEFAULT_HEALTH_CHECK_TIMEOUT  = 5 * time.Second
+)
+
+// Reconciler keeps the Consul registrations in sync with the components
+// listed by the source, and publishes them to the Backends.
+type Reconciler struct {
+	Source   topology.Source
+	Consul   *consul.Client
+	Config   *config.Config
+	State    *StateCache
+	Hooks    Hooks
+	History  *History
+	Pins     *Pins
+	Locks    *consul.ClusterLocks
+	Retries  *RetryQueue
+	Outbox   *Outbox
+	Failures *FailureTracker
+	Backends []backend.Backend
+	nodes    map[string]consul.Node
+	logger   *log.Logger
+
+	// DefaultUpdateInterval throttles the components without an update
+	// interval, when the service checks are more frequent for the others.
+	DefaultUpdateInterval time.Duration
+
+	datacenter        string
+	cycle             string
+	writeBudget       int
+	backendsPublished bool
+	lock              sync.Mutex
+	components        []topology.HostComponent
+	registrations     []consul.Service
+	drift             []DriftEntry
+}
+
+func New(source topology.Source, consulClient *consul.Client, conf *config.Config, state *StateCache) *Reconciler {
+	if state == nil {
+		state = NewStateCache()
+	}
+	return &Reconciler{
+		Source:   source,
+		Consul:   consulClient,
+		Config:   conf,
+		State:    state,
+		History:  NewHistory(DEFAULT_HISTORY_SIZE),
+		Pins:     NewPins(conf.Pins),
+		Retries:  NewRetryQueue(),
+		Outbox:   NewOutbox(),
+		Failures: NewFailureTracker(),
+		logger:   log.Default(),
+	}
+}
+
+// Sync runs one service check. It returns whether the topology changed, so
+// the caller can poll faster while the cluster is changing. The requests and
+// the log lines of the check carry the same request ID, the lines through a
+// logger of the check, so the other goroutines logging meanwhile don't get it.
+func (r *Reconciler) Sync(ctx context.Context) (bool, error) {
+	defer metrics.Default.ObserveSince(metrics.SYNC_DURATION, nil, time.Now())
+	requestID := httpclient.NewRequestID()
+	ctx = httpclient.WithRequestID(ctx, requestID)
+	r.Consul.SetRequestID(requestID)
+	r.cycle = requestID
+	r.logger = log.New(log.Writer(), "["+requestID+"] ", log.Flags())
+	r.Hooks.preSync(ctx)
+	changed, err := r.sync(ctx)
+	r.Hooks.postSync(changed, err)
+	return changed, err
+}
+
+func (r *Reconciler) sync(ctx context.Context) (bool, error) {
+	changed := false
+	state := r.State
+
+	components, err := r.Source.ListComponents(ctx)
+	if err != nil {
+		return false, SourceError{err}
+	}
+	components = r.Config.FilterComponents(topology.Deduplicate(components))
+	components = r.Config.ExpandAliases(components)
+	components = append(components, r.Config.GetStaticComponents()...)
+	components = state.throttleComponents(r.Config, components, r.DefaultUpdateInterval, time.Now())
+	components, statePending := state.stabilizeStates(r.Config, components)
+	if statePending {
+		changed = true
+	}
+	r.Config.SetHostnames(components)
+	r.applyPins(components)
+
+	r.writeBudget = -1
+	if r.Consul.MaxWrites > 0 {
+		r.writeBudget = r.Consul.MaxWrites
+	}
+	if r.resumeOutbox(components) {
+		state.invalidateServices()
+		changed = true
+	}
+	if r.Pins.takeChanged() {
+		state.invalidateServices()
+	}
+	r.datacenter = r.Consul.GetDatacenter()
+	previousConsulIndex := state.consulIndex
+	consulServices, err := r.Consul.GetServices(state)
+	if err != nil {
+		return false, errors.New("Failed to get the services from consul: " + err.Error())
+	}
+	consulChanged := len(previousConsulIndex) == 0 || previousConsulIndex != state.consulIndex
+	consulServices = r.dropForeignServices(consulServices)
+	if r.Locks != nil {
+		components, consulServices = r.filterLockedClusters(components, consulServices)
+	}
+	// the snapshot, the metrics and the history show the observed states, the
+	// hysteresis only holds back the registrations
+	observed := state.withObservedStates(components)
+	r.setSnapshot(observed, consulServices)
+	r.recordDrift(time.Now())
+	recordComponentStates(observed)
+	r.History.Record(observed, time.Now())
+	r.pruneFailures(components, consulServices)
+
+	changedComponents, ambariChanged := state.updateComponents(components)
+	if !consulChanged && !ambariChanged {
+		r.logger.Println("No changes in Ambari and Consul since the last service check")
+	} else {
+		candidates := components
+		if !consulChanged {
+			candidates = changedComponents
+		}
+		// the changes skipped in a maintenance window are done by the first
+		// check after it, since the invalidated cache forces a full check
+		windowMode := r.Config.GetActiveWindowMode(time.Now())
+		if len(windowMode) > 0 {
+			r.logger.Println("Maintenance window is active, paused changes: " + windowMode)
+			state.invalidateServices()
+		}
+		var writes = make([]OutboxWrite, 0)
+		if windowMode != config.WINDOW_PAUSE_ALL {
+			for _, component := range r.getNewComponents(candidates, consulServices) {
+				writes = append(writes, OutboxWrite{Operation: OPERATION_REGISTER, Service: r.newService(component)})
+			}
+		}
+		if len(windowMode) == 0 {
+			removed := r.getRemovedServices(components, consulServices)
+			if len(removed) > 0 {
+				r.Hooks.preDeregister(removed, len(consulServices))
+			}
+			for _, service := range removed {
+				writes = append(writes, OutboxWrite{Operation: OPERATION_DEREGISTER, Service: service})
+			}
+		}
+		if len(writes) > 0 {
+			r.Outbox.begin(r.cycle, writes)
+			r.applyOutbox()
+			state.invalidateServices()
+			changed = true
+		}
+		state.save()
+	}
+	if r.Retries.Len() > 0 && r.retryFailedWrites(components, consulServices, r.Config.GetActiveWindowMode(time.Now())) {
+		state.invalidateServices()
+		changed = true
+	}
+	r.publishToBackends(ctx, components, ambariChanged)
+
+	if r.Config.RegisterNodes {
+		r.registerNodes()
+	}
+
+	for _, component := range components {
+		if topology.IsTransitionalState(component.State) {
+			changed = true
+			break
+		}
+	}
+	return changed, nil
+}
+
+// SourceError is the failure of the service check to list the components.
+type SourceError struct {
+	error
+}
+
+func (r *Reconciler) setSnapshot(components []topology.HostComponent, registrations []consul.Service) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.components = components
+	r.registrations = registrations
+}
+
+// Components returns the components of the last service check.
+func (r *Reconciler) Components() []topology.HostComponent {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.components
+}
+
+// Registrations returns the owned catalog entries read in the last service
+// check, before the changes of the check were applied.
+func (r *Reconciler) Registrations() []consul.Service {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.registrations
+}
+
+func (r *Reconciler) getNewComponents(components []topology.HostComponent, consulServices []consul.Service) []topology.HostComponent {
+	var newComponents = make([]topology.HostComponent, 0)
+	var registered = make(map[string][]consul.Service)
+	for _, service := range consulServices {
+		key := service.ServiceName + "@" + service.Address
+		registered[key] = append(registered[key], service)
+	}
+	for _, component := range components {
+		state := r.Config.GetStateTag(component)
+		componentName := r.Config.GetServiceName(component)
+		if r.isFrozen(r.Config.GetServiceID(component)) {
+			r.logger.Printf("Service '%s' is pinned on host: %s, update skipped", componentName, component.IP)
+		} else if "UNKNOWN" != strings.ToUpper(component.State) {
+			desired := r.newService(component)
+			upToDate := false
+			for _, service := range registered[componentName+"@"+component.IP] {
+				if isRegistrationUpToDate(desired, service) {
+					upToDate = true
+					break
+				}
+			}
+			if !upToDate {
+				newComponents = append(newComponents, component)
+			} else if r.Config.IsVerbose() {
+				r.logger.Printf("Service '%s' is already registered for host: %s and in state: %s", componentName, component.IP, state)
+			}
+		} else {
+			r.logger.Printf("%s's state is unknown, update skipped", componentName)
+		}
+	}
+	return newComponents
+}
+
+// registerNodes registers the hosts of the source as catalog nodes, so node
+// meta based queries work. Only the new and changed nodes of the included
+// hosts are registered.
+func (r *Reconciler) registerNodes() {
+	hostSource, ok := r.Source.(topology.HostSource)
+	if !ok {
+		return
+	}
+	var current = make(map[string]consul.Node)
+	var changedNodes = make([]consul.Node, 0)
+	for hostname, host := range hostSource.Hosts() {
+		if len(host.IP) == 0 || !r.Config.IsHostIncluded(hostname, host.IP) {
+			continue
+		}
+		node := newNode(hostname, host)
+		if previous, ok := r.nodes[hostname]; ok && previous.Equals(node) {
+			current[hostname] = previous
+		} else {
+			changedNodes = append(changedNodes, node)
+		}
+	}
+	for _, node := range r.Consul.RegisterNodes(changedNodes) {
+		current[node.Node] = node
+	}
+	r.nodes = current
+}
+
+func newNode(hostname string, host topology.Host) consul.Node {
+	var meta = make(map[string]string)
+	for key, value := range map[string]string{
+		NODE_META_RACK:       host.Rack,
+		NODE_META_OS:         host.OSType,
+		NODE_META_HOST_STATE: host.State,
+	} {
+		if len(value) > 0 {
+			meta[key] = value
+		}
+	}
+	return consul.Node{Node: hostname, Address: host.IP, NodeMeta: meta}
+}
+
+// isRegistrationUpToDate compares the complete desired registration with an
+// existing catalog entry, so a change in any tag, the port, the meta or the
+// weights triggers a new registration.
+func isRegistrationUpToDate(desired consul.Service, existing consul.Service) bool {
+	if desired.Port != existing.ServicePort || len(desired.Tags) != len(existing.ServiceTags) || len(desired.Meta) != len(existing.ServiceMeta) {
+		return false
+	}
+	if desired.Weights != nil && (existing.ServiceWeights == nil || *desired.Weights != *existing.ServiceWeights) {
+		return false
+	}
+	var tags = make(map[string]int)
+	for _, tag := range desired.Tags {
+		tags[tag]++
+	}
+	for _, tag := range existing.ServiceTags {
+		tags[tag]--
+	}
+	for _, count := range tags {
+		if count != 0 {
+			return false
+		}
+	}
+	for key, value := range desired.Meta {
+		if existingValue, ok := existing.ServiceMeta[key]; !ok || existingValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// getRemovedServices returns the owned services which are not desired on
+// their agent. A service is never removed while a registration with the same
+// ID is desired on the same agent, since the removal would delete the new
+// registration. The services of the active components registered with another
+// ID, e.g. after the host was renamed by a repair, are replaced. The pinned
+// services are kept, unless their pinned address replaces them.
+func (r *Reconciler) getRemovedServices(components []topology.HostComponent, consulServices []consul.Service) []consul.Service {
+	var removedServices = make([]consul.Service, 0)
+	var active = make(map[string]bool)
+	var desired = make(map[string]bool)
+	var desiredIDs = make(map[string]bool)
+	for _, component := range components {
+		active[component.Cluster+"@"+r.Config.GetServiceName(component)+"@"+component.IP] = true
+		id := r.newService(component).ID
+		desired[id+"@"+component.IP] = true
+		desiredIDs[id] = true
+	}
+	for _, service := range consulServices {
+		if !consul.IsOwned(service) || desired[service.ServiceID+"@"+service.Address] {
+			continue
+		}
+		if _, pinned := r.Pins.Get(service.ServiceID); pinned && (r.isFrozen(service.ServiceID) || !desiredIDs[service.ServiceID]) {
+			r.logger.Printf("Service %s is pinned, deregistration skipped", service.ServiceID)
+			continue
+		}
+		if active[consul.GetCluster(service)+"@"+service.ServiceName+"@"+service.Address] {
+			r.logger.Printf("Replacing the stale registration %s of %s at %s", service.ServiceID, service.ServiceName, service.Address)
+		}
+		removedServices = append(removedServices, service)
+	}
+	return removedServices
+}
+
+func (r *Reconciler) newService(component topology.HostComponent) consul.Service {
+	if static := r.Config.GetStaticService(component); static != nil {
+		return newStaticService(static)
+	}
+	service := consul.Service{
+		ID:      r.Config.GetServiceID(component),
+		Name:    r.Config.GetServiceName(component),
+		Address: component.IP,
+		Port:    r.getServicePort(component),
+		Tags:    r.Config.GetServiceTags(component),
+		Meta:    r.getServiceMeta(component),
+		Weights: r.Config.GetWeights(component),
+		Check:   r.getServiceCheck(component),
+	}
+	service.Tags = r.getPinnedTags(service)
+	return service
+}
+
+func (r *Reconciler) getServicePort(component topology.HostComponent) int64 {
+	if port, ok := r.Config.Ports[component.HostComponent]; ok {
+		return port
+	}
+	if component.Port > 0 {
+		return component.Port
+	}
+	if component.HostComponent == ambari.SERVER_COMPONENT {
+		return ambari.SERVER_PORT
+	}
+	if r.Config.DefaultPort > 0 {
+		return r.Config.DefaultPort
+	}
+	return DEFAULT_SERVICE_PORT
+}
+
+// getServiceCheck returns a TCP check for the components with a configured or
+// known port, the check of the Ambari alert definition of the component, and a
+// TCP check for the Ambari server, since the default port doesn't belong to any
+// real endpoint.
+func (r *Reconciler) getServiceCheck(component topology.HostComponent) *consul.Check {
+	_, ok := r.Config.Ports[component.HostComponent]
+	if len(component.IP) == 0 {
+		return nil
+	}
+	interval := r.Config.HealthCheckInterval
+	if interval <= 0 {
+		interval = DEFAULT_HEALTH_CHECK_INTERVAL
+	}
+	check := &consul.Check{
+		Interval: interval.String(),
+		Timeout:  DEFAULT_HEALTH_CHECK_TIMEOUT.String(),
+		Notes:    r.getCheckNotes(component),
+	}
+	if !ok && component.Check.Port > 0 {
+		address := net.JoinHostPort(component.IP, strconv.FormatInt(component.Check.Port, 10))
+		if component.Check.Type == ambari.WEB_ALERT {
+			check.HTTP = "http://" + address + "/"
+		} else {
+			check.TCP = address
+		}
+		return check
+	}
+	if !ok && component.Port == 0 && component.HostComponent != ambari.SERVER_COMPONENT {
+		return nil
+	}
+	check.TCP = net.JoinHostPort(component.IP, strconv.FormatInt(r.getServicePort(component), 10))
+	return check
+}
+
+// getCheckNotes describes the Ambari state of the component, so the Consul UI
+// and the watches show why a service is degraded. It goes into the notes
+// instead of the output of the check, since the notes of a check are kept
+// when its status changes and the output is replaced on every run.
+func (r *Reconciler) getCheckNotes(component topology.HostComponent) string {
+	notes := "Ambari state: " + component.State
+	if component.Maintenance {
+		notes += ", maintenance mode"
+		if len(component.MaintenanceReason) > 0 {
+			notes += " of the " + component.MaintenanceReason
+		}
+	}
+	if lastChange := r.History.LastChange(component); !lastChange.IsZero() {
+		notes += ", last state change: " + lastChange.UTC().Format(time.RFC3339)
+	}
+	return notes
+}
+
+// getServiceMeta returns the meta of the component, the mapped host attributes
+// don't override the meta set by the service registration.
+func (r *Reconciler) getServiceMeta(component topology.HostComponent) map[string]string {
+	meta := r.Config.GetHostMeta(component)
+	if len(r.datacenter) > 0 {
+		meta[consul.DATACENTER_META_KEY] = r.datacenter
+	}
+	if len(component.Cluster) > 0 {
+		meta[CLUSTER_META_KEY] = component.Cluster
+	}
+	for key, value := range map[string]string{
+		STACK_META_KEY:          component.Stack.Name,
+		BLUEPRINT_META_KEY:      component.Stack.Blueprint,
+		CLOUD_PLATFORM_META_KEY: component.Stack.CloudPlatform,
+		INSTANCE_GROUP_META_KEY: component.Stack.InstanceGroup,
+		MAINTENANCE_META_KEY:    getMaintenanceMeta(component),
+	} {
+		if len(value) > 0 {
+			meta[key] = value
+		}
+	}
+	return meta
+}
+
+// dropForeignServices drops the owned services registered from other
+// datacenters, which may show up in the catalog of a WAN federated
+// datacenter. They are neither deregistered nor replaced by this instance.
+func (r *Reconciler) dropForeignServices(services []consul.Service) []consul.Service {
+	var local = make([]consul.Service, 0, len(services))
+	for _, service := range services {
+		if consul.IsForeign(service, r.datacenter) {
+			continue
+		}
+		local = append(local, service)
+	}
+	if foreign := len(services) - len(local); foreign > 0 {
+		r.logger.Printf("Skipped %d services registered from other datacenters", foreign)
+	}
+	return local
+}
+
+// recordComponentStates exports the state of every component as a gauge, which
+// is 1 for the current state of the component.
+func recordComponentStates(components []topology.HostComponent) {
+	var samples = make([]metrics.Sample, 0, len(components))
+	for _, component := range components {
+		if len(component.Alias) > 0 || len(component.Static) > 0 {
+			continue
+		}
+		samples = append(samples, metrics.Sample{Labels: metrics.Labels{
+			"component": component.HostComponent,
+			"host":      component.Hostname,
+			"state":     component.State,
+			"cluster":   component.Cluster,
+		}, Value: 1})
+	}
+	metrics.Default.SetGauge(metrics.COMPONENT_STATE, samples)
+}
+
+// getMaintenanceMeta is the origin of the maintenance mode, so a maintenance
+// tag can be told apart as planned work on a host or service.
+func getMaintenanceMeta(component topology.HostComponent) string {
+	if !component.Maintenance {
+		return ""
+	}
+	if len(component.MaintenanceReason) == 0 {
+		return "unknown"
+	}
+	return component.MaintenanceReason
+}
+
+func newStaticService(service *config.StaticService) consul.Service {
+	var tags = make([]string, 0, len(service.Tags)+1)
+	hasOwnershipTag := false
+	for _, tag := range service.Tags {
+		tags = append(tags, tag)
+		hasOwnershipTag = hasOwnershipTag || tag == consul.OWNERSHIP_TAG
+	}
+	if !hasOwnershipTag {
+		tags = append(tags, consul.OWNERSHIP_TAG)
+	}
+	var meta = make(map[string]string)
+	for key, value := range service.Meta {
+		meta[key] = value
+	}
+	return consul.Service{
+		ID:      service.ID,
+		Name:    service.Name,
+		Address: service.Address,
+		Port:    service.Port,
+		Tags:    tags,
+		Meta:    meta,
+		Check:   service.Check,
+	}
+}
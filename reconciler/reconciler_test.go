@@ -0,0 +1,487 @@
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/ambari"
+	"github.com/hortonworks/cloudbreak-service-registration/config"
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+	"github.com/hortonworks/cloudbreak-service-registration/testutil"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+const (
+	TEST_CLUSTER  = "c1"
+	TEST_HOSTNAME = "h1.example.com"
+	TEST_IP       = "10.0.0.1"
+)
+
+type testEnv struct {
+	ambari     *testutil.FakeAmbari
+	consul     *testutil.FakeConsul
+	client     *consul.Client
+	reconciler *Reconciler
+}
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	fa := testutil.NewFakeAmbari(TEST_CLUSTER)
+	fc := testutil.NewFakeConsul()
+	t.Cleanup(fa.Close)
+	t.Cleanup(fc.Close)
+
+	ac := ambari.NewClient(http.DefaultClient, "", "admin", "admin")
+	ac.BaseURL = fa.URL()
+	ac.ServerURL = fa.ServerURL()
+	cc := consul.NewClient(http.DefaultClient)
+	cc.BaseURL = fc.URL()
+	cc.AgentBaseURL = fc.URL()
+	conf := &config.Config{}
+	if err := conf.Init(); err != nil {
+		t.Fatal(err)
+	}
+	// every check is a full refresh, the component states are not part of
+	// the host fingerprint
+	source := ambari.NewSource(ac)
+	source.FullRefreshCycles = 1
+	return &testEnv{ambari: fa, consul: fc, client: cc, reconciler: New(source, cc, conf, nil)}
+}
+
+// setComponents replaces the components of the test host.
+func (e *testEnv) setComponents(components ...topology.HostComponent) {
+	e.ambari.SetHost(TEST_HOSTNAME, topology.Host{IP: TEST_IP})
+	e.ambari.SetComponents(components)
+}
+
+func (e *testEnv) sync(t *testing.T) bool {
+	changed, err := e.reconciler.Sync(context.Background())
+	if err != nil {
+		t.Fatalf("Sync failed: %s", err)
+	}
+	return changed
+}
+
+func newComponent(name string, service string, state string) topology.HostComponent {
+	return topology.HostComponent{Hostname: TEST_HOSTNAME, HostComponent: name, Service: service, State: state, Cluster: TEST_CLUSTER}
+}
+
+func assertRegistered(t *testing.T, services map[string]consul.Service, id string, tags ...string) {
+	t.Helper()
+	service, ok := services[id]
+	if !ok {
+		t.Fatalf("Service %s is not registered, services: %v", id, services)
+	}
+	for _, tag := range tags {
+		if !hasTag(service.Tags, tag) {
+			t.Errorf("Service %s is missing the tag %s, tags: %v", id, tag, service.Tags)
+		}
+	}
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSyncRegistersNewComponents(t *testing.T) {
+	env := newTestEnv(t)
+	env.setComponents(newComponent("DATANODE", "HDFS", "STARTED"), newComponent("NODEMANAGER", "YARN", "INSTALLED"))
+
+	if !env.sync(t) {
+		t.Error("First sync reported no change")
+	}
+
+	services := env.consul.Services()
+	if len(services) != 2 {
+		t.Fatalf("Expected 2 services, got: %v", services)
+	}
+	assertRegistered(t, services, "datanode.h1", "started", "service:HDFS", consul.OWNERSHIP_TAG, consul.ClusterTag(TEST_CLUSTER))
+	assertRegistered(t, services, "nodemanager.h1", "installed", "service:YARN")
+	if services["datanode.h1"].Address != TEST_IP {
+		t.Errorf("Expected address %s, got: %s", TEST_IP, services["datanode.h1"].Address)
+	}
+	if env.sync(t) {
+		t.Error("Sync without changes reported a change")
+	}
+}
+
+func TestSyncUpdatesChangedState(t *testing.T) {
+	env := newTestEnv(t)
+	env.setComponents(newComponent("DATANODE", "HDFS", "STARTED"))
+	env.sync(t)
+
+	env.setComponents(newComponent("DATANODE", "HDFS", "INSTALLED"))
+	if !env.sync(t) {
+		t.Error("State change reported no change")
+	}
+
+	services := env.consul.Services()
+	assertRegistered(t, services, "datanode.h1", "installed")
+	if hasTag(services["datanode.h1"].Tags, "started") {
+		t.Errorf("The previous state tag is kept: %v", services["datanode.h1"].Tags)
+	}
+}
+
+func TestSyncDeregistersRemovedComponents(t *testing.T) {
+	env := newTestEnv(t)
+	env.setComponents(newComponent("DATANODE", "HDFS", "STARTED"), newComponent("NODEMANAGER", "YARN", "STARTED"))
+	env.sync(t)
+
+	env.setComponents(newComponent("DATANODE", "HDFS", "STARTED"))
+	if !env.sync(t) {
+		t.Error("Removal reported no change")
+	}
+
+	services := env.consul.Services()
+	if _, ok := services["nodemanager.h1"]; ok {
+		t.Errorf("Removed component is still registered: %v", services)
+	}
+	assertRegistered(t, services, "datanode.h1", "started")
+}
+
+func TestSyncKeepsForeignServices(t *testing.T) {
+	env := newTestEnv(t)
+	const prefix = "service-registration/locks"
+	other := consul.NewClusterLocks(env.client, prefix, "other")
+	if held := other.Acquire([]string{"c2"}); !held["c2"] {
+		t.Fatal("Failed to lock the cluster of the other instance")
+	}
+	env.reconciler.Locks = consul.NewClusterLocks(env.client, prefix, "test")
+
+	foreign := []consul.Service{
+		// not owned by the service registration
+		{ID: "web.h1", Name: "web", Address: TEST_IP, Tags: []string{"web"}},
+		// registered from another datacenter
+		{ID: "namenode.h2", Name: "namenode", Address: "10.1.0.2", Tags: []string{consul.OWNERSHIP_TAG, consul.ClusterTag(TEST_CLUSTER)},
+			Meta: map[string]string{consul.DATACENTER_META_KEY: "dc2"}},
+		// cluster reconciled by another instance
+		{ID: "resourcemanager.h3", Name: "resourcemanager", Address: "10.0.0.3", Tags: []string{consul.OWNERSHIP_TAG, consul.ClusterTag("c2")}},
+	}
+	for _, service := range foreign {
+		env.consul.Register(service)
+	}
+	env.setComponents(newComponent("DATANODE", "HDFS", "STARTED"))
+	env.sync(t)
+
+	services := env.consul.Services()
+	for _, service := range foreign {
+		if _, ok := services[service.ID]; !ok {
+			t.Errorf("Service %s was deregistered", service.ID)
+		}
+	}
+	assertRegistered(t, services, "datanode.h1", "started")
+}
+
+func TestSyncKeepsLastKnownComponentsOnAmbariFailure(t *testing.T) {
+	env := newTestEnv(t)
+	env.setComponents(newComponent("DATANODE", "HDFS", "STARTED"), newComponent("NODEMANAGER", "YARN", "STARTED"))
+	env.sync(t)
+
+	env.ambari.FailHostComponents(http.StatusTooManyRequests)
+	env.setComponents(newComponent("DATANODE", "HDFS", "INSTALLED"))
+	if env.sync(t) {
+		t.Error("Sync reported a change while the components could not be fetched")
+	}
+
+	services := env.consul.Services()
+	if len(services) != 2 {
+		t.Fatalf("Expected the 2 last known services, got: %v", services)
+	}
+	assertRegistered(t, services, "datanode.h1", "started")
+	assertRegistered(t, services, "nodemanager.h1", "started")
+
+	env.ambari.FailHostComponents(0)
+	env.sync(t)
+	services = env.consul.Services()
+	if _, ok := services["nodemanager.h1"]; ok {
+		t.Errorf("Removed component is still registered after the recovery: %v", services)
+	}
+	assertRegistered(t, services, "datanode.h1", "installed")
+}
+
+func TestSyncDefersWritesAboveMaxWrites(t *testing.T) {
+	env := newTestEnv(t)
+	env.client.MaxWrites = 2
+	env.setComponents(newComponent("DATANODE", "HDFS", "STARTED"), newComponent("NODEMANAGER", "YARN", "STARTED"),
+		newComponent("HBASE_REGIONSERVER", "HBASE", "STARTED"))
+
+	env.sync(t)
+	if services := env.consul.Services(); len(services) != 2 {
+		t.Fatalf("Expected 2 services within the write limit, got: %v", services)
+	}
+	env.sync(t)
+	if services := env.consul.Services(); len(services) != 3 {
+		t.Fatalf("Expected the deferred service to be registered, got: %v", services)
+	}
+}
+
+const (
+	BENCHMARK_HOSTS      = 1000
+	BENCHMARK_COMPONENTS = 10
+)
+
+// newBenchmarkFixture returns the components of a large cluster and their
+// catalog entries, every tenth host has a stale registration too.
+func newBenchmarkFixture(b *testing.B) (*Reconciler, []topology.HostComponent, []consul.Service) {
+	conf := &config.Config{}
+	if err := conf.Init(); err != nil {
+		b.Fatal(err)
+	}
+	r := New(nil, nil, conf, nil)
+	var components = make([]topology.HostComponent, 0, BENCHMARK_HOSTS*BENCHMARK_COMPONENTS)
+	var services = make([]consul.Service, 0, cap(components)+BENCHMARK_HOSTS/10)
+	for h := 0; h < BENCHMARK_HOSTS; h++ {
+		hostname := fmt.Sprintf("host%04d.example.com", h)
+		ip := fmt.Sprintf("10.0.%d.%d", h/250, h%250+1)
+		for c := 0; c < BENCHMARK_COMPONENTS; c++ {
+			components = append(components, topology.HostComponent{Hostname: hostname, IP: ip, Cluster: TEST_CLUSTER,
+				HostComponent: fmt.Sprintf("COMPONENT%d", c), Service: fmt.Sprintf("SERVICE%d", c%3), State: "STARTED"})
+		}
+		if h%10 == 0 {
+			components := []topology.HostComponent{{Hostname: hostname, IP: ip, Cluster: TEST_CLUSTER, HostComponent: "STALE", Service: "SERVICE0", State: "STARTED"}}
+			services = append(services, toCatalogEntry(r.newService(components[0])))
+		}
+	}
+	for _, component := range components {
+		services = append(services, toCatalogEntry(r.newService(component)))
+	}
+	return r, components, services
+}
+
+func toCatalogEntry(service consul.Service) consul.Service {
+	return consul.Service{
+		Address:     service.Address,
+		ServiceName: service.Name,
+		ServiceID:   service.ID,
+		ServiceTags: service.Tags,
+		ServicePort: service.Port,
+		ServiceMeta: service.Meta,
+	}
+}
+
+func BenchmarkGetRemovedServices(b *testing.B) {
+	r, components, services := newBenchmarkFixture(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if removed := r.getRemovedServices(components, services); len(removed) != BENCHMARK_HOSTS/10 {
+			b.Fatalf("Expected %d removed services, got: %d", BENCHMARK_HOSTS/10, len(removed))
+		}
+	}
+}
+
+func BenchmarkIsRegistrationUpToDate(b *testing.B) {
+	r, components, services := newBenchmarkFixture(b)
+	var existing = make(map[string]consul.Service, len(services))
+	for _, service := range services {
+		existing[service.ServiceID] = service
+	}
+	var desired = make([]consul.Service, 0, len(components))
+	for _, component := range components {
+		desired = append(desired, r.newService(component))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, service := range desired {
+			if !isRegistrationUpToDate(service, existing[service.ID]) {
+				b.Fatalf("Service %s is not up to date", service.ID)
+			}
+		}
+	}
+}
+
+func TestComponentUpdateIntervalsThrottleTheWrites(t *testing.T) {
+	conf := &config.Config{ComponentUpdateIntervals: map[string]time.Duration{"*_CLIENT": 5 * time.Minute}}
+	state := NewStateCache()
+	now := time.Now()
+	stateOf := func(components []topology.HostComponent, name string) string {
+		for _, c := range components {
+			if c.HostComponent == name {
+				return c.State
+			}
+		}
+		return ""
+	}
+
+	state.throttleComponents(conf, []topology.HostComponent{
+		newComponent("HDFS_CLIENT", "HDFS", "INSTALLED"), newComponent("NAMENODE", "HDFS", "STARTED")}, 0, now)
+	components := state.throttleComponents(conf, []topology.HostComponent{
+		newComponent("HDFS_CLIENT", "HDFS", "INSTALL_FAILED"), newComponent("NAMENODE", "HDFS", "INSTALLED")}, 0, now.Add(time.Minute))
+	if s := stateOf(components, "HDFS_CLIENT"); s != "INSTALLED" {
+		t.Errorf("Expected the client to be reported as INSTALLED within its interval, got: %s", s)
+	}
+	if s := stateOf(components, "NAMENODE"); s != "INSTALLED" {
+		t.Errorf("Expected the unthrottled NAMENODE to be updated, got: %s", s)
+	}
+	components = state.throttleComponents(conf, []topology.HostComponent{
+		newComponent("HDFS_CLIENT", "HDFS", "INSTALL_FAILED"), newComponent("NAMENODE", "HDFS", "INSTALLED")}, 0, now.Add(6*time.Minute))
+	if s := stateOf(components, "HDFS_CLIENT"); s != "INSTALL_FAILED" {
+		t.Errorf("Expected the client to be updated after its interval, got: %s", s)
+	}
+}
+
+func TestFastComponentUpdateIntervalIsWrittenAtTheShorterInterval(t *testing.T) {
+	conf := &config.Config{ComponentUpdateIntervals: map[string]time.Duration{"NAMENODE": 5 * time.Second}}
+	poller := NewPoller(PollSettings{Interval: 30 * time.Second, MaxBackoff: time.Minute, Jitter: -1}, conf.GetFastestUpdateInterval())
+	if poller.DefaultUpdateInterval() != 30*time.Second {
+		t.Errorf("Expected the other components to keep the 30s poll interval, got: %s", poller.DefaultUpdateInterval())
+	}
+	state := NewStateCache()
+	now := time.Now()
+	var updates = make(map[string]int)
+	var last = make(map[string]string)
+	// the state changes on every check, the reported state only when the
+	// component is written
+	for i := 0; i < 12; i++ {
+		observed := fmt.Sprintf("STATE_%d", i)
+		components := state.throttleComponents(conf, []topology.HostComponent{
+			newComponent("NAMENODE", "HDFS", observed), newComponent("DATANODE", "HDFS", observed)}, poller.DefaultUpdateInterval(), now)
+		for _, c := range components {
+			if c.State != last[c.HostComponent] {
+				updates[c.HostComponent]++
+				last[c.HostComponent] = c.State
+			}
+		}
+		next := poller.Next()
+		if next != 5*time.Second {
+			t.Fatalf("Expected a check every 5s, got: %s", next)
+		}
+		now = now.Add(next)
+		poller.Update(true)
+	}
+	// 12 checks in 60s
+	if updates["NAMENODE"] != 12 {
+		t.Errorf("Expected NAMENODE to be written on every check, got: %d", updates["NAMENODE"])
+	}
+	if updates["DATANODE"] != 2 {
+		t.Errorf("Expected DATANODE to be written every 30s, got: %d", updates["DATANODE"])
+	}
+}
+
+func TestStabilizeStatesRequiresConsecutiveObservations(t *testing.T) {
+	conf := &config.Config{StateHysteresis: map[string]int{"HIVE_SERVER": 3}}
+	state := NewStateCache()
+	stabilize := func(s string) string {
+		components, _ := state.stabilizeStates(conf, []topology.HostComponent{newComponent("HIVE_SERVER", "HIVE", s)})
+		return components[0].State
+	}
+
+	if s := stabilize("STARTED"); s != "STARTED" {
+		t.Errorf("Expected the first state to be reported at once, got: %s", s)
+	}
+	if s := stabilize("INSTALLED"); s != "STARTED" {
+		t.Errorf("Expected STARTED to be kept after 1 observation of INSTALLED, got: %s", s)
+	}
+	if s := stabilize("STARTED"); s != "STARTED" {
+		t.Errorf("Expected the reported state to be kept, got: %s", s)
+	}
+	// the flapping reset the count, INSTALLED needs 3 observations again
+	for i := 1; i < 3; i++ {
+		if s := stabilize("INSTALLED"); s != "STARTED" {
+			t.Errorf("Expected STARTED to be kept after %d observations of INSTALLED, got: %s", i, s)
+		}
+	}
+	if s := stabilize("INSTALLED"); s != "INSTALLED" {
+		t.Errorf("Expected INSTALLED to be reported after 3 observations, got: %s", s)
+	}
+}
+
+func TestSyncRecordsTheObservedStatesDuringTheHysteresis(t *testing.T) {
+	env := newTestEnv(t)
+	env.reconciler.Config.StateHysteresis = map[string]int{"DATANODE": 3}
+	env.setComponents(newComponent("DATANODE", "HDFS", "STARTED"))
+	env.sync(t)
+
+	env.setComponents(newComponent("DATANODE", "HDFS", "INSTALLED"))
+	env.sync(t)
+
+	assertRegistered(t, env.consul.Services(), "datanode.h1", "started")
+	if components := env.reconciler.Components(); len(components) != 1 || components[0].State != "INSTALLED" {
+		t.Errorf("Expected the observed state INSTALLED in the snapshot, got: %v", components)
+	}
+	histories := env.reconciler.History.Get("DATANODE", TEST_HOSTNAME, time.Time{})
+	if len(histories) != 1 || histories[0].State != "INSTALLED" {
+		t.Errorf("Expected the observed state INSTALLED in the history, got: %v", histories)
+	}
+}
+
+func TestPostRegisterHookGetsTheSuccessfulWritesOnly(t *testing.T) {
+	env := newTestEnv(t)
+	var registered []string
+	env.reconciler.Hooks.PostRegister = func(services []consul.Service) {
+		for _, service := range services {
+			registered = append(registered, service.ID)
+		}
+	}
+	env.consul.Reject("nodemanager.h1")
+	env.setComponents(newComponent("DATANODE", "HDFS", "STARTED"), newComponent("NODEMANAGER", "YARN", "STARTED"))
+	env.sync(t)
+
+	if len(registered) != 1 || registered[0] != "datanode.h1" {
+		t.Errorf("Expected the post register hook to get datanode.h1 only, got: %v", registered)
+	}
+}
+
+func TestRetriesInADeregistrationWindowOnlyRetryTheRegistrations(t *testing.T) {
+	env := newTestEnv(t)
+	env.reconciler.writeBudget = -1
+	nodemanager := newComponent("NODEMANAGER", "YARN", "STARTED")
+	nodemanager.IP = TEST_IP
+	past := time.Now().Add(-time.Hour)
+	env.reconciler.Retries.Record(OPERATION_REGISTER, nil, []consul.Service{{ID: "nodemanager.h1"}}, past)
+	env.reconciler.Retries.Record(OPERATION_DEREGISTER, nil, []consul.Service{{ServiceID: "datanode.h1"}}, past)
+	registered := []consul.Service{{ServiceID: "datanode.h1", ServiceName: "datanode", Address: TEST_IP}}
+	components := []topology.HostComponent{nodemanager}
+
+	if env.reconciler.retryFailedWrites(components, registered, config.WINDOW_PAUSE_ALL) {
+		t.Error("Expected no retries while all the changes are paused")
+	}
+	if !env.reconciler.retryFailedWrites(components, registered, config.WINDOW_PAUSE_DEREGISTRATIONS) {
+		t.Fatal("Expected the failed registration to be retried while only the deregistrations are paused")
+	}
+	assertRegistered(t, env.consul.Services(), "nodemanager.h1")
+	due := env.reconciler.Retries.Due(time.Now())
+	if len(due) != 1 || due[0].Operation != OPERATION_DEREGISTER {
+		t.Errorf("Expected the deregistration to stay queued, got: %v", due)
+	}
+
+	if !env.reconciler.retryFailedWrites(components, registered, "") {
+		t.Error("Expected the deregistration to be retried after the window")
+	}
+}
+
+func TestPollerJittersBetweenTheChecksByDefault(t *testing.T) {
+	poller := NewPoller(PollSettings{Interval: 10 * time.Second, MaxBackoff: time.Minute}, 0)
+	jittered := false
+	for i := 0; i < 20; i++ {
+		next := poller.Next()
+		if next < 10*time.Second || next >= 11*time.Second {
+			t.Fatalf("Expected a sleep within a tenth above the interval, got: %s", next)
+		}
+		jittered = jittered || next != 10*time.Second
+	}
+	if !jittered {
+		t.Error("Expected the default jitter to extend the sleeps")
+	}
+
+	poller = NewPoller(PollSettings{Interval: 10 * time.Second, MaxBackoff: time.Minute, Jitter: -1}, 0)
+	if next := poller.Next(); next != 10*time.Second {
+		t.Errorf("Expected no jitter when it is disabled, got: %s", next)
+	}
+}
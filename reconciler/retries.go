@@ -0,0 +1,242 @@
+package reconciler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/config"
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+	"github.com/hortonworks/cloudbreak-service-registration/metrics"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+const (
+	OPERATION_REGISTER    = "register"
+	OPERATION_DEREGISTER  = "deregister"
+	RETRY_INITIAL_BACKOFF = 30 * time.Second
+	RETRY_MAX_BACKOFF     = 10 * time.Minute
+)
+
+// RetryEntry is a failed write of a service, keyed by its service ID.
+type RetryEntry struct {
+	Operation    string         `json:"operation"`
+	Service      consul.Service `json:"service"`
+	Attempts     int            `json:"attempts"`
+	FirstFailure time.Time      `json:"first_failure"`
+	NextAttempt  time.Time      `json:"next_attempt"`
+}
+
+// RetryQueue keeps the writes which failed because the agent of the service
+// was unreachable, so they are retried in the later cycles even when neither
+// Ambari nor Consul changes meanwhile. The retries back off exponentially per
+// service. The queue is written to its file on every change, so it survives
+// restarts.
+type RetryQueue struct {
+	path    string
+	entries map[string]RetryEntry
+}
+
+func NewRetryQueue() *RetryQueue {
+	return &RetryQueue{entries: make(map[string]RetryEntry)}
+}
+
+func LoadRetryQueue(path string) *RetryQueue {
+	queue := NewRetryQueue()
+	queue.path = path
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Cannot read retry queue file: " + err.Error())
+		}
+		return queue
+	}
+	var entries []RetryEntry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		log.Println("Cannot parse retry queue file, starting with an empty queue: " + err.Error())
+		return queue
+	}
+	for _, entry := range entries {
+		queue.entries[getWriteID(entry.Service)] = entry
+	}
+	if len(entries) > 0 {
+		log.Printf("Loaded failed writes to retry: %d", len(entries))
+	}
+	queue.recordLength()
+	return queue
+}
+
+// Record updates the queue with the result of the writes of an operation,
+// the written services are removed and the failed ones are queued.
+func (q *RetryQueue) Record(operation string, services []consul.Service, failed []consul.Service, now time.Time) {
+	if q == nil || (len(services) == 0 && len(failed) == 0) {
+		return
+	}
+	var failedIDs = make(map[string]bool, len(failed))
+	for _, service := range failed {
+		id := getWriteID(service)
+		failedIDs[id] = true
+		entry, queued := q.entries[id]
+		if !queued || entry.Operation != operation {
+			entry = RetryEntry{Operation: operation, FirstFailure: now}
+		}
+		entry.Service = service
+		entry.Attempts++
+		entry.NextAttempt = now.Add(getRetryBackoff(entry.Attempts))
+		q.entries[id] = entry
+	}
+	for _, service := range services {
+		if id := getWriteID(service); !failedIDs[id] {
+			delete(q.entries, id)
+		}
+	}
+	if len(failed) > 0 {
+		log.Printf("Queued failed %s requests to retry: %d", operation, len(failed))
+	}
+	q.save()
+}
+
+// Remove drops the entries which are no longer needed.
+func (q *RetryQueue) Remove(ids []string) {
+	if q == nil || len(ids) == 0 {
+		return
+	}
+	for _, id := range ids {
+		delete(q.entries, id)
+	}
+	q.save()
+}
+
+// Due returns the entries to retry at the time, oldest failure first.
+func (q *RetryQueue) Due(now time.Time) []RetryEntry {
+	var due = make([]RetryEntry, 0)
+	if q == nil {
+		return due
+	}
+	for _, entry := range q.entries {
+		if !now.Before(entry.NextAttempt) {
+			due = append(due, entry)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].FirstFailure.Before(due[j].FirstFailure) })
+	return due
+}
+
+func (q *RetryQueue) Len() int {
+	if q == nil {
+		return 0
+	}
+	return len(q.entries)
+}
+
+func (q *RetryQueue) save() {
+	q.recordLength()
+	if len(q.path) == 0 {
+		return
+	}
+	var entries = make([]RetryEntry, 0, len(q.entries))
+	for _, entry := range q.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return getWriteID(entries[i].Service) < getWriteID(entries[j].Service) })
+	content, _ := json.Marshal(entries)
+	if err := os.MkdirAll(filepath.Dir(q.path), 0755); err != nil {
+		log.Println("Cannot create retry queue directory: " + err.Error())
+		return
+	}
+	tmp := q.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		log.Println("Cannot write retry queue file: " + err.Error())
+		return
+	}
+	if err := os.Rename(tmp, q.path); err != nil {
+		log.Println("Cannot write retry queue file: " + err.Error())
+	}
+}
+
+func (q *RetryQueue) recordLength() {
+	var counts = map[string]int{OPERATION_REGISTER: 0, OPERATION_DEREGISTER: 0}
+	for _, entry := range q.entries {
+		counts[entry.Operation]++
+	}
+	var samples = make([]metrics.Sample, 0, len(counts))
+	for operation, count := range counts {
+		samples = append(samples, metrics.Sample{Labels: metrics.Labels{"operation": operation}, Value: float64(count)})
+	}
+	metrics.Default.SetGauge(metrics.RETRY_QUEUE_LENGTH, samples)
+}
+
+func getRetryBackoff(attempts int) time.Duration {
+	backoff := RETRY_INITIAL_BACKOFF
+	for i := 1; i < attempts && backoff < RETRY_MAX_BACKOFF; i++ {
+		backoff *= 2
+	}
+	if backoff > RETRY_MAX_BACKOFF {
+		backoff = RETRY_MAX_BACKOFF
+	}
+	return backoff
+}
+
+// getWriteID returns the service ID of a registration or of a catalog entry.
+func getWriteID(service consul.Service) string {
+	if len(service.ServiceID) > 0 {
+		return service.ServiceID
+	}
+	return service.ID
+}
+
+// retryFailedWrites retries the due writes of the queue which are still
+// needed: a queued registration is sent again with the current definition of
+// the service while it is desired and not registered yet, a queued
+// deregistration while the service is not desired and is still registered.
+// The retries wait while the outbox has writes deferred to the next cycle, and
+// the writes paused by the active maintenance window mode stay queued until it
+// closes. It returns whether any write was retried.
+func (r *Reconciler) retryFailedWrites(components []topology.HostComponent, consulServices []consul.Service, windowMode string) bool {
+	due := r.Retries.Due(time.Now())
+	if len(due) == 0 || len(r.Outbox.pending()) > 0 || windowMode == config.WINDOW_PAUSE_ALL {
+		return false
+	}
+	desired := r.getDesiredServices(components)
+	var registered = make(map[string]consul.Service, len(consulServices))
+	for _, service := range consulServices {
+		registered[service.ServiceID] = service
+	}
+
+	var register = make([]consul.Service, 0)
+	var deregister = make([]consul.Service, 0)
+	var obsolete = make([]string, 0)
+	for _, entry := range due {
+		id := getWriteID(entry.Service)
+		service, isDesired := desired[id]
+		current, isRegistered := registered[id]
+		switch {
+		case entry.Operation == OPERATION_REGISTER && isDesired && !(isRegistered && isRegistrationUpToDate(service, current)):
+			register = append(register, service)
+		case entry.Operation == OPERATION_DEREGISTER && !isDesired && isRegistered:
+			if len(windowMode) == 0 {
+				deregister = append(deregister, current)
+			}
+		default:
+			obsolete = append(obsolete, id)
+		}
+	}
+	r.Retries.Remove(obsolete)
+	if len(register)+len(deregister) == 0 {
+		return false
+	}
+	r.logger.Printf("Retrying the failed writes, register: %d, deregister: %d", len(register), len(deregister))
+	var writes = make([]OutboxWrite, 0, len(register)+len(deregister))
+	for _, service := range register {
+		writes = append(writes, OutboxWrite{Operation: OPERATION_REGISTER, Service: service})
+	}
+	for _, service := range deregister {
+		writes = append(writes, OutboxWrite{Operation: OPERATION_DEREGISTER, Service: service})
+	}
+	r.Outbox.begin(r.cycle, writes)
+	return r.applyOutbox() > 0
+}
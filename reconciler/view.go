@@ -0,0 +1,101 @@
+package reconciler
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+)
+
+const (
+	STATUS_REGISTERED = "registered"
+	STATUS_MISSING    = "missing"
+	STATUS_DRIFTED    = "drifted"
+	STATUS_ORPHANED   = "orphaned"
+)
+
+// ViewEntry is a component merged with its Consul registration. Orphaned
+// entries are owned registrations without a component, and Drift lists the
+// differences of a drifted registration.
+type ViewEntry struct {
+	Component   string   `json:"component,omitempty"`
+	Service     string   `json:"service,omitempty"`
+	Host        string   `json:"host,omitempty"`
+	IP          string   `json:"ip"`
+	State       string   `json:"state,omitempty"`
+	Cluster     string   `json:"cluster,omitempty"`
+	ServiceName string   `json:"serviceName"`
+	ServiceID   string   `json:"serviceId,omitempty"`
+	Status      string   `json:"status"`
+	Drift       []string `json:"drift,omitempty"`
+}
+
+// View merges the components and the registrations of the last service
+// check.
+func (r *Reconciler) View() []ViewEntry {
+	components := r.Components()
+	registrations := r.Registrations()
+
+	var registered = make(map[string]consul.Service)
+	for _, service := range registrations {
+		registered[service.ServiceID+"@"+service.Address] = service
+	}
+	var view = make([]ViewEntry, 0, len(components))
+	var seen = make(map[string]bool)
+	for _, component := range components {
+		desired := r.newService(component)
+		key := desired.ID + "@" + desired.Address
+		entry := ViewEntry{
+			Component:   component.HostComponent,
+			Service:     component.Service,
+			Host:        component.Hostname,
+			IP:          component.IP,
+			State:       component.State,
+			Cluster:     component.Cluster,
+			ServiceName: desired.Name,
+			ServiceID:   desired.ID,
+		}
+		if existing, ok := registered[key]; !ok {
+			entry.Status = STATUS_MISSING
+		} else if entry.Drift = getDrift(desired, existing); len(entry.Drift) > 0 {
+			entry.Status = STATUS_DRIFTED
+		} else {
+			entry.Status = STATUS_REGISTERED
+		}
+		seen[key] = true
+		view = append(view, entry)
+	}
+	for key, service := range registered {
+		if !seen[key] {
+			view = append(view, ViewEntry{
+				IP:          service.Address,
+				Cluster:     consul.GetCluster(service),
+				ServiceName: service.ServiceName,
+				ServiceID:   service.ServiceID,
+				Status:      STATUS_ORPHANED,
+			})
+		}
+	}
+	sort.Slice(view, func(i, j int) bool {
+		if view[i].ServiceName != view[j].ServiceName {
+			return view[i].ServiceName < view[j].ServiceName
+		}
+		return view[i].IP < view[j].IP
+	})
+	return view
+}
+
+func getDrift(desired consul.Service, existing consul.Service) []string {
+	var drift = make([]string, 0)
+	if desired.Port != existing.ServicePort {
+		drift = append(drift, "port: "+strconv.FormatInt(existing.ServicePort, 10)+" -> "+strconv.FormatInt(desired.Port, 10))
+	}
+	if !isRegistrationUpToDate(consul.Service{Tags: desired.Tags}, consul.Service{ServiceTags: existing.ServiceTags}) {
+		drift = append(drift, "tags: "+strings.Join(existing.ServiceTags, ",")+" -> "+strings.Join(desired.Tags, ","))
+	}
+	if !isRegistrationUpToDate(consul.Service{Meta: desired.Meta}, consul.Service{ServiceMeta: existing.ServiceMeta}) {
+		drift = append(drift, "meta")
+	}
+	return drift
+}
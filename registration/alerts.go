@@ -0,0 +1,94 @@
+package registration
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+	"github.com/hortonworks/cloudbreak-service-registration/notify"
+	"github.com/hortonworks/cloudbreak-service-registration/reconciler"
+)
+
+const (
+	ALERT_AMBARI_UNREACHABLE  = "ambari_unreachable"
+	ALERT_SYNC_FAILING        = "sync_failing"
+	ALERT_CONVERGENCE_STUCK   = "convergence_stuck"
+	ALERT_MASS_DEREGISTRATION = "mass_deregistration"
+	MIN_MASS_DEREGISTRATION   = 5
+	MAX_ALERT_DETAILS         = 20
+)
+
+// checkAlerts updates the failure conditions with the result of the service
+// check: Ambari is unreachable while the components cannot be listed, the
+// service checks are failing while they return an error, and the convergence
+// is stuck while a service is out of sync for longer than the period of the
+// alert. The service registration cannot converge in the last two cases, so
+// their alerts are critical. The mass deregistration alert is resolved by the
+// first check without one.
+func (r *Registration) checkAlerts(err error) {
+	if r.conf.Notifier == nil {
+		return
+	}
+	now := time.Now()
+	notifications := r.conf.Services.Notifications
+
+	if !r.massDeregistration {
+		r.conf.Notifier.Check(notify.Alert{Key: ALERT_MASS_DEREGISTRATION}, false, 0, now)
+	}
+	r.massDeregistration = false
+
+	period := notifications.GetAmbariUnreachable()
+	_, unreachable := err.(reconciler.SourceError)
+	alert := notify.Alert{Key: ALERT_AMBARI_UNREACHABLE, Severity: notify.SEVERITY_ERROR, Summary: "Ambari is unreachable for more than " + period.String()}
+	if unreachable {
+		alert.Details = err.Error()
+	}
+	r.conf.Notifier.Check(alert, unreachable, period, now)
+
+	period = notifications.GetConvergenceStuck()
+	alert = notify.Alert{Key: ALERT_SYNC_FAILING, Severity: notify.SEVERITY_CRITICAL, Summary: "Service checks are failing for more than " + period.String()}
+	if err != nil {
+		alert.Details = err.Error()
+		r.conf.Notifier.Check(alert, true, period, now)
+		return
+	}
+	r.conf.Notifier.Check(alert, false, period, now)
+
+	var stuck = make([]string, 0)
+	for _, entry := range r.reconciler.Drift() {
+		if now.Sub(entry.Since) >= period {
+			stuck = append(stuck, fmt.Sprintf("%s %s at %s since %s", entry.Status, entry.ServiceName, entry.IP, entry.Since.UTC().Format(time.RFC3339)))
+		}
+	}
+	alert = notify.Alert{Key: ALERT_CONVERGENCE_STUCK, Severity: notify.SEVERITY_CRITICAL, Summary: "Services are out of sync for more than " + period.String(), Details: joinDetails(stuck)}
+	r.conf.Notifier.Check(alert, len(stuck) > 0, 0, now)
+}
+
+// checkMassDeregistration fires the alert of the deregistration of a large
+// part of the registered services before it happens, since it breaks the
+// discovery of the cluster workloads if it is not intended.
+func (r *Registration) checkMassDeregistration(services []consul.Service, registered int) {
+	ratio := r.conf.Services.Notifications.GetMassDeregistration()
+	if r.conf.Notifier == nil || len(services) < MIN_MASS_DEREGISTRATION || float64(len(services)) < ratio*float64(registered) {
+		return
+	}
+	r.massDeregistration = true
+	var deregistered = make([]string, 0, len(services))
+	for _, service := range services {
+		deregistered = append(deregistered, fmt.Sprintf("%s at %s", service.ServiceID, service.Address))
+	}
+	r.conf.Notifier.Check(notify.Alert{
+		Key:      ALERT_MASS_DEREGISTRATION,
+		Severity: notify.SEVERITY_CRITICAL,
+		Summary:  fmt.Sprintf("Deregistering %d of the %d registered services", len(services), registered),
+		Details:  joinDetails(deregistered),
+	}, true, 0, time.Now())
+}
+
+func joinDetails(lines []string) string {
+	if len(lines) > MAX_ALERT_DETAILS {
+		return strings.Join(lines[:MAX_ALERT_DETAILS], "\n") + fmt.Sprintf("\n... and %d more", len(lines)-MAX_ALERT_DETAILS)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,58 @@
+package registration
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	HEARTBEAT_SYNCED  = "synced"
+	HEARTBEAT_FAILED  = "failed"
+	HEARTBEAT_PAUSED  = "paused"
+	HEARTBEAT_STANDBY = "standby"
+)
+
+// Heartbeat is written to <HeartbeatKey>/<hostname> in the Consul KV store
+// after every cycle, so a stuck service registration can be alerted on by
+// the age of its heartbeat.
+type Heartbeat struct {
+	Node                string    `json:"node"`
+	Time                time.Time `json:"time"`
+	Cycle               int64     `json:"cycle"`
+	Status              string    `json:"status"`
+	Changed             bool      `json:"changed"`
+	Error               string    `json:"error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+}
+
+func (r *Registration) writeHeartbeat(status string, changed bool, err error) {
+	r.cycle++
+	if err != nil {
+		r.consecutiveFailures++
+	} else if status == HEARTBEAT_SYNCED {
+		r.consecutiveFailures = 0
+	}
+	if len(r.conf.HeartbeatKey) == 0 {
+		return
+	}
+	hostname, _ := os.Hostname()
+	heartbeat := Heartbeat{
+		Node:                hostname,
+		Time:                time.Now().UTC(),
+		Cycle:               r.cycle,
+		Status:              status,
+		Changed:             changed,
+		ConsecutiveFailures: r.consecutiveFailures,
+	}
+	if err != nil {
+		heartbeat.Error = err.Error()
+	}
+	value, _ := json.Marshal(heartbeat)
+	key := strings.TrimSuffix(r.conf.HeartbeatKey, "/") + "/" + hostname
+	if err := r.conf.Consul.PutKV(key, value); err != nil {
+		log.Println("Failed to write the heartbeat to Consul: " + err.Error())
+	}
+}
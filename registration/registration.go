@@ -0,0 +1,310 @@
+// Package registration runs the service registration loop, so it can be
+// embedded in other agents instead of running the standalone binary.
+package registration
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/backend"
+	"github.com/hortonworks/cloudbreak-service-registration/config"
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+	"github.com/hortonworks/cloudbreak-service-registration/notify"
+	"github.com/hortonworks/cloudbreak-service-registration/reconciler"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+const DEFAULT_NAME = "service-registration"
+
+// Config wires the service registration. Source and Consul are required, a
+// nil Services config means the defaults, an empty StatePath disables the
+// state file, an empty RetryQueuePath keeps the failed writes and an empty
+// OutboxPath the change set of the cycle in memory only. The leader election
+// is enabled by the LeaderElectionKey, the per cluster locks by the
+// ClusterLockPrefix and the heartbeat by the HeartbeatKey. The persistent
+// failures are sent to the Notifier, if any.
+type Config struct {
+	Source               topology.Source
+	Consul               *consul.Client
+	Services             *config.Config
+	Poll                 reconciler.PollSettings
+	StatePath            string
+	RetryQueuePath       string
+	OutboxPath           string
+	LeaderElectionKey    string
+	ClusterLockPrefix    string
+	HeartbeatKey         string
+	Name                 string
+	DeregisterOnShutdown bool
+	Hooks                reconciler.Hooks
+	Backends             []backend.Backend
+	Notifier             *notify.Notifier
+}
+
+// Registration is a running service registration, which can be queried and
+// controlled while Run is in progress.
+type Registration struct {
+	conf       Config
+	reconciler *reconciler.Reconciler
+	poller     *reconciler.Poller
+	leader     *consul.LeaderElection
+	trigger    chan struct{}
+	lock       sync.Mutex
+	paused     bool
+
+	cycle               int64
+	consecutiveFailures int
+	massDeregistration  bool
+}
+
+func New(conf Config) (*Registration, error) {
+	if conf.Source == nil || conf.Consul == nil {
+		return nil, errors.New("Source and Consul client must be set")
+	}
+	if conf.Services == nil {
+		conf.Services = &config.Config{}
+		if err := conf.Services.Init(); err != nil {
+			return nil, err
+		}
+	}
+	if len(conf.Name) == 0 {
+		conf.Name = DEFAULT_NAME
+	}
+
+	state := reconciler.NewStateCache()
+	if len(conf.StatePath) > 0 {
+		state = reconciler.LoadStateCache(conf.StatePath)
+	}
+	r := reconciler.New(conf.Source, conf.Consul, conf.Services, state)
+	r.Hooks = conf.Hooks
+	r.Backends = conf.Backends
+	if len(conf.RetryQueuePath) > 0 {
+		r.Retries = reconciler.LoadRetryQueue(conf.RetryQueuePath)
+	}
+	if len(conf.OutboxPath) > 0 {
+		r.Outbox = reconciler.LoadOutbox(conf.OutboxPath)
+	}
+	r.Locks = consul.NewClusterLocks(conf.Consul, conf.ClusterLockPrefix, conf.Name)
+	poller := reconciler.NewPoller(conf.Poll, conf.Services.GetFastestUpdateInterval())
+	r.DefaultUpdateInterval = poller.DefaultUpdateInterval()
+	registration := &Registration{
+		conf:       conf,
+		reconciler: r,
+		poller:     poller,
+		leader:     consul.NewLeaderElection(conf.Consul, conf.LeaderElectionKey, conf.Name),
+		trigger:    make(chan struct{}, 1),
+	}
+	preDeregister := r.Hooks.PreDeregister
+	r.Hooks.PreDeregister = func(services []consul.Service, registered int) {
+		if preDeregister != nil {
+			preDeregister(services, registered)
+		}
+		registration.checkMassDeregistration(services, registered)
+	}
+	return registration, nil
+}
+
+// Run reconciles the services until the context is cancelled.
+func Run(ctx context.Context, conf Config) error {
+	registration, err := New(conf)
+	if err != nil {
+		return err
+	}
+	return registration.Run(ctx)
+}
+
+// Run reconciles the services until the context is cancelled.
+func (r *Registration) Run(ctx context.Context) error {
+	for {
+		if !r.wait(ctx, r.poller.Next()) {
+			log.Println("Shutdown signal received, stopping service registration")
+			if r.conf.DeregisterOnShutdown && r.leader.IsLeader() {
+				r.cleanup()
+			}
+			r.leader.Resign()
+			r.reconciler.Locks.Release()
+			backend.CloseAll(r.conf.Backends)
+			return nil
+		}
+
+		if r.IsPaused() {
+			log.Println("Service registration is paused, skipping the service check")
+			r.writeHeartbeat(HEARTBEAT_PAUSED, false, nil)
+			continue
+		}
+
+		if !r.leader.IsLeader() {
+			log.Println("Another instance holds the leader lock, standing by")
+			r.writeHeartbeat(HEARTBEAT_STANDBY, false, nil)
+			continue
+		}
+
+		changed, err := r.reconciler.Sync(ctx)
+		if err != nil {
+			log.Println(err.Error())
+			r.writeHeartbeat(HEARTBEAT_FAILED, false, err)
+			r.checkAlerts(err)
+			r.poller.Fail()
+			continue
+		}
+		r.writeHeartbeat(HEARTBEAT_SYNCED, changed, nil)
+		r.checkAlerts(nil)
+		r.poller.Update(changed)
+	}
+}
+
+func (r *Registration) wait(ctx context.Context, sleep time.Duration) bool {
+	log.Printf("Wait %.0f seconds for the next service check", sleep.Seconds())
+	select {
+	case <-ctx.Done():
+		return false
+	case <-r.trigger:
+		log.Println("Service check triggered")
+		return true
+	case <-time.After(sleep):
+		return true
+	}
+}
+
+// TriggerSync starts the next service check without waiting for the poll
+// interval to elapse.
+func (r *Registration) TriggerSync() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Registration) SetPaused(paused bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.paused != paused {
+		log.Printf("Service registration paused: %t", paused)
+	}
+	r.paused = paused
+}
+
+func (r *Registration) IsPaused() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.paused
+}
+
+func (r *Registration) Pins() []config.Pin {
+	return r.reconciler.Pins.List()
+}
+
+// Pin pins the registration of a service and starts a service check to apply
+// it.
+func (r *Registration) Pin(pin config.Pin) error {
+	if err := pin.Validate(); err != nil {
+		return err
+	}
+	r.reconciler.Pins.Set(pin)
+	r.TriggerSync()
+	return nil
+}
+
+// Unpin removes the pin of the service and returns whether it was pinned.
+func (r *Registration) Unpin(serviceID string) bool {
+	if !r.reconciler.Pins.Remove(serviceID) {
+		return false
+	}
+	r.TriggerSync()
+	return true
+}
+
+func (r *Registration) Components() []topology.HostComponent {
+	return r.reconciler.Components()
+}
+
+// Hosts returns the hosts of the last listing, if the source knows them.
+func (r *Registration) Hosts() map[string]topology.Host {
+	if hostSource, ok := r.conf.Source.(topology.HostSource); ok {
+		return hostSource.Hosts()
+	}
+	return nil
+}
+
+func (r *Registration) Registrations() []consul.Service {
+	return r.reconciler.Registrations()
+}
+
+func (r *Registration) View() []reconciler.ViewEntry {
+	return r.reconciler.View()
+}
+
+func (r *Registration) Drift() []reconciler.DriftEntry {
+	return r.reconciler.Drift()
+}
+
+func (r *Registration) History(componentName string, host string, since time.Time) []reconciler.ComponentHistory {
+	return r.reconciler.History.Get(componentName, host, since)
+}
+
+func (r *Registration) UnreachableAgents() map[string]time.Time {
+	return r.conf.Consul.UnreachableAgents()
+}
+
+// Failures returns the services with the most consecutive failed writes.
+func (r *Registration) Failures(limit int) []reconciler.ServiceFailures {
+	return r.reconciler.Failures.Top(limit)
+}
+
+// Cleanup deregisters the services owned by the service registration in the
+// clusters, the empty cluster stands for the services without a cluster, e.g.
+// the Ambari server and agents. The services registered from other
+// datacenters are kept. It returns the failed deregistrations.
+func Cleanup(client *consul.Client, clusters []string) ([]consul.WriteFailure, error) {
+	log.Printf("Deregistering the services of the clusters: %v", clusters)
+	consulServices, err := client.GetServices(nil)
+	if err != nil {
+		return nil, errors.New("Failed to get the services from consul: " + err.Error())
+	}
+	datacenter := client.GetDatacenter()
+	var inScope = make(map[string]bool, len(clusters))
+	for _, cluster := range clusters {
+		inScope[cluster] = true
+	}
+	var services = make([]consul.Service, 0)
+	for _, service := range consulServices {
+		if consul.IsOwned(service) && !consul.IsForeign(service, datacenter) && inScope[consul.GetCluster(service)] {
+			services = append(services, service)
+		}
+	}
+	var failures []consul.WriteFailure
+	if len(services) > 0 {
+		failures = client.Deregister(services)
+	}
+	for _, failure := range failures {
+		log.Printf("Failed to deregister %s at %s: %s", failure.Service.ServiceID, failure.Service.Address, failure.Error)
+	}
+	log.Printf("Cleanup finished, deregistered %d services, failed to deregister %d", len(services)-len(failures), len(failures))
+	return failures, nil
+}
+
+// cleanup deregisters the services of the clusters reconciled by this
+// instance, the clusters of the last check and the ones locked by it.
+func (r *Registration) cleanup() {
+	var clusters = r.reconciler.Locks.Held()
+	for _, component := range r.reconciler.Components() {
+		if !contains(clusters, component.Cluster) {
+			clusters = append(clusters, component.Cluster)
+		}
+	}
+	if _, err := Cleanup(r.conf.Consul, clusters); err != nil {
+		log.Println("Cleanup failed: " + err.Error())
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package registration
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+	"github.com/hortonworks/cloudbreak-service-registration/notify"
+	"github.com/hortonworks/cloudbreak-service-registration/testutil"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func TestCleanupDeregistersTheServicesOfTheClusters(t *testing.T) {
+	fc := testutil.NewFakeConsul()
+	defer fc.Close()
+	client := consul.NewClient(http.DefaultClient)
+	client.BaseURL = fc.URL()
+	client.AgentBaseURL = fc.URL()
+
+	owned := func(id string, cluster string) consul.Service {
+		tags := []string{consul.OWNERSHIP_TAG}
+		if len(cluster) > 0 {
+			tags = append(tags, consul.ClusterTag(cluster))
+		}
+		return consul.Service{ID: id, Name: id, Address: "10.0.0.1", Tags: tags}
+	}
+	foreign := owned("foreign", "c1")
+	foreign.Meta = map[string]string{consul.DATACENTER_META_KEY: "dc2"}
+	for _, service := range []consul.Service{
+		owned("datanode", "c1"),
+		owned("ambari", ""),
+		owned("other-cluster", "c2"),
+		foreign,
+		{ID: "web", Name: "web", Address: "10.0.0.1", Tags: []string{"web"}},
+	} {
+		fc.Register(service)
+	}
+
+	failures, err := Cleanup(client, []string{"c1", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) > 0 {
+		t.Errorf("Unexpected failures: %v", failures)
+	}
+	var remaining = make([]string, 0)
+	for id := range fc.Services() {
+		remaining = append(remaining, id)
+	}
+	sort.Strings(remaining)
+	expected := []string{"foreign", "other-cluster", "web"}
+	if len(remaining) != len(expected) {
+		t.Fatalf("Expected the services %v to remain, got: %v", expected, remaining)
+	}
+	for i := range expected {
+		if remaining[i] != expected[i] {
+			t.Fatalf("Expected the services %v to remain, got: %v", expected, remaining)
+		}
+	}
+}
+
+type emptySource struct{}
+
+func (emptySource) ListComponents(ctx context.Context) ([]topology.HostComponent, error) {
+	return nil, nil
+}
+
+// recordingChannel passes the sent alerts to the test.
+type recordingChannel chan notify.Alert
+
+func (c recordingChannel) Name() string {
+	return "recording"
+}
+
+func (c recordingChannel) Send(alert notify.Alert) error {
+	c <- alert
+	return nil
+}
+
+func (c recordingChannel) next(t *testing.T) notify.Alert {
+	t.Helper()
+	select {
+	case alert := <-c:
+		return alert
+	case <-time.After(time.Second):
+		t.Fatal("No alert was sent")
+	}
+	return notify.Alert{}
+}
+
+func TestMassDeregistrationAlertIsResolved(t *testing.T) {
+	channel := make(recordingChannel, 10)
+	reg, err := New(Config{
+		Source:   emptySource{},
+		Consul:   consul.NewClient(http.DefaultClient),
+		Notifier: notify.NewNotifier([]notify.Channel{channel}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var services = make([]consul.Service, 0)
+	for _, id := range []string{"a", "b", "c", "d", "e", "f"} {
+		services = append(services, consul.Service{ServiceID: id, Address: "10.0.0.1"})
+	}
+
+	reg.checkMassDeregistration(services, len(services))
+	reg.checkAlerts(nil)
+	if alert := channel.next(t); alert.Key != ALERT_MASS_DEREGISTRATION || alert.Resolved {
+		t.Fatalf("Expected the mass deregistration alert, got: %+v", alert)
+	}
+
+	reg.checkMassDeregistration(services[:1], len(services))
+	reg.checkAlerts(nil)
+	if alert := channel.next(t); alert.Key != ALERT_MASS_DEREGISTRATION || !alert.Resolved {
+		t.Fatalf("Expected the mass deregistration alert to be resolved, got: %+v", alert)
+	}
+}
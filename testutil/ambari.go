@@ -0,0 +1,228 @@
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hortonworks/cloudbreak-service-registration/ambari"
+	"github.com/hortonworks/cloudbreak-service-registration/topology"
+)
+
+// FakeAmbari serves the subset of the Ambari REST API used by the ambari
+// client from an in-memory topology. Root components are the ones without a
+// cluster. The topology can be changed while the server is running. Every host
+// listing is a new heartbeat of the agents, like on a live server. The API is
+// served at /api/v1 by a 2.7 server until SetVersion changes them.
+type FakeAmbari struct {
+	Server *httptest.Server
+
+	lock        sync.Mutex
+	version     string
+	apiRoot     string
+	clusterName string
+	hosts       map[string]topology.Host
+	components  []topology.HostComponent
+	heartbeat   int64
+	failStatus  int
+}
+
+func NewFakeAmbari(clusterName string) *FakeAmbari {
+	f := &FakeAmbari{version: "2.7.3.0", apiRoot: "/api/v1", clusterName: clusterName, hosts: make(map[string]topology.Host)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+// URL returns the API root to be used as the BaseURL of the ambari client.
+func (f *FakeAmbari) URL() string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.Server.URL + f.apiRoot
+}
+
+// ServerURL returns the address to be used as the ServerURL of the ambari
+// client, which negotiates the API root and the version from it.
+func (f *FakeAmbari) ServerURL() string {
+	return f.Server.URL
+}
+
+// SetVersion changes the version of the server and the root its API is served
+// at. From 3.0 the host components have a service_type, and their
+// service_name is the name of the service instance.
+func (f *FakeAmbari) SetVersion(version string, apiRoot string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.version = version
+	f.apiRoot = apiRoot
+}
+
+func (f *FakeAmbari) Close() {
+	f.Server.Close()
+}
+
+func (f *FakeAmbari) SetHost(hostname string, host topology.Host) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.hosts[hostname] = host
+}
+
+func (f *FakeAmbari) RemoveHost(hostname string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.hosts, hostname)
+}
+
+func (f *FakeAmbari) SetComponents(components []topology.HostComponent) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.components = append([]topology.HostComponent{}, components...)
+}
+
+// FailHostComponents makes the host_components requests respond with the
+// status, zero serves them again.
+func (f *FakeAmbari) FailHostComponents(status int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.failStatus = status
+}
+
+func (f *FakeAmbari) serve(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !strings.HasPrefix(r.URL.Path, f.apiRoot+"/") {
+		http.NotFound(w, r)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, f.apiRoot)
+	query := r.URL.Query()
+	switch {
+	case path == "/services/AMBARI/components/"+ambari.SERVER_COMPONENT:
+		writeJSON(w, map[string]interface{}{"RootServiceComponents": map[string]string{"component_version": f.version}})
+	case path == "/clusters":
+		items := make([]interface{}, 0)
+		if len(f.clusterName) > 0 {
+			items = append(items, map[string]interface{}{"Clusters": map[string]string{"cluster_name": f.clusterName}})
+		}
+		writeJSON(w, map[string]interface{}{"items": items})
+	case path == "/hosts":
+		hostnames := make([]string, 0, len(f.hosts))
+		for hostname := range f.hosts {
+			hostnames = append(hostnames, hostname)
+		}
+		sort.Strings(hostnames)
+		f.heartbeat++
+		items := make([]interface{}, 0)
+		for _, hostname := range page(hostnames, query) {
+			host := f.hosts[hostname]
+			items = append(items, map[string]interface{}{"Hosts": map[string]interface{}{
+				"host_name":           hostname,
+				"ip":                  host.IP,
+				"rack_info":           host.Rack,
+				"os_type":             host.OSType,
+				"host_state":          host.State,
+				"host_status":         host.Fingerprint,
+				"last_heartbeat_time": f.heartbeat,
+				"os_arch":             host.Attributes.Get(ambari.OS_ARCH_ATTRIBUTE),
+				"public_host_name":    host.Attributes.Get(ambari.PUBLIC_HOST_NAME_ATTRIBUTE),
+			}})
+		}
+		writeJSON(w, map[string]interface{}{"items": items})
+	case path == "/clusters/"+f.clusterName+"/host_components" && f.failStatus > 0:
+		http.Error(w, "Injected failure", f.failStatus)
+	case path == "/clusters/"+f.clusterName+"/host_components":
+		hostname := query.Get("HostRoles/host_name")
+		keys := make([]string, 0)
+		for i, c := range f.components {
+			if len(c.Cluster) > 0 && (len(hostname) == 0 || hostname == c.Hostname) {
+				keys = append(keys, strconv.Itoa(i))
+			}
+		}
+		items := make([]interface{}, 0)
+		for _, key := range page(keys, query) {
+			i, _ := strconv.Atoi(key)
+			c := f.components[i]
+			hostRole := map[string]string{
+				"component_name":    c.HostComponent,
+				"service_name":      c.Service,
+				"host_name":         c.Hostname,
+				"state":             c.State,
+				"maintenance_state": getMaintenanceState(c),
+			}
+			if major, _ := strconv.Atoi(strings.Split(f.version, ".")[0]); major >= 3 {
+				hostRole["service_name"] = strings.ToLower(c.Service) + "_1"
+				if strings.Contains(query.Get("fields"), "HostRoles/service_type") {
+					hostRole["service_type"] = c.Service
+				}
+			}
+			items = append(items, map[string]interface{}{"HostRoles": hostRole})
+		}
+		writeJSON(w, map[string]interface{}{"items": items})
+	case path == "/clusters/"+f.clusterName+"/config_groups":
+		groups := make(map[string][]interface{})
+		for hostname, host := range f.hosts {
+			if group := host.Attributes.Get(ambari.CONFIG_GROUP_ATTRIBUTE); len(group) > 0 {
+				groups[group] = append(groups[group], map[string]string{"host_name": hostname})
+			}
+		}
+		items := make([]interface{}, 0)
+		for group, hosts := range groups {
+			items = append(items, map[string]interface{}{"ConfigGroup": map[string]interface{}{"group_name": group, "hosts": hosts}})
+		}
+		writeJSON(w, map[string]interface{}{"items": items})
+	case path == "/services/":
+		hostComponents := make([]interface{}, 0)
+		for _, c := range f.components {
+			if len(c.Cluster) == 0 {
+				hostComponents = append(hostComponents, map[string]interface{}{"RootServiceHostComponents": map[string]string{
+					"component_name":  c.HostComponent,
+					"service_name":    c.Service,
+					"component_state": c.State,
+					"host_name":       c.Hostname,
+				}})
+			}
+		}
+		writeJSON(w, map[string]interface{}{"items": []interface{}{
+			map[string]interface{}{"components": []interface{}{
+				map[string]interface{}{"hostComponents": hostComponents},
+			}},
+		}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func getMaintenanceState(c topology.HostComponent) string {
+	if c.Maintenance {
+		return "ON"
+	}
+	return "OFF"
+}
+
+// page applies the page_size and from query parameters of the Ambari API.
+func page(keys []string, query map[string][]string) []string {
+	from, _ := strconv.Atoi(first(query["from"]))
+	size, err := strconv.Atoi(first(query["page_size"]))
+	if from > len(keys) {
+		from = len(keys)
+	}
+	if err != nil || from+size > len(keys) {
+		return keys[from:]
+	}
+	return keys[from : from+size]
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,189 @@
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hortonworks/cloudbreak-service-registration/consul"
+)
+
+// FakeConsul serves the catalog and the agent endpoints used by the consul
+// client from an in-memory catalog. Every registration and deregistration
+// increments the catalog index, like a real agent would.
+type FakeConsul struct {
+	Server *httptest.Server
+
+	lock     sync.Mutex
+	index    int
+	sessions int
+	services map[string]consul.Service
+	nodes    map[string]consul.Node
+	kv       map[string]string
+	rejected map[string]bool
+}
+
+func NewFakeConsul() *FakeConsul {
+	f := &FakeConsul{index: 1, services: make(map[string]consul.Service), nodes: make(map[string]consul.Node), kv: make(map[string]string),
+		rejected: make(map[string]bool)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+// URL is used both as the BaseURL and the AgentBaseURL of the consul client.
+func (f *FakeConsul) URL() string {
+	return f.Server.URL
+}
+
+func (f *FakeConsul) Close() {
+	f.Server.Close()
+}
+
+// Services returns the registered services keyed by ID, in the agent
+// registration format.
+func (f *FakeConsul) Services() map[string]consul.Service {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var services = make(map[string]consul.Service, len(f.services))
+	for id, service := range f.services {
+		services[id] = service
+	}
+	return services
+}
+
+// Register adds the service to the catalog as if it was registered by
+// another client, in the agent registration format.
+func (f *FakeConsul) Register(service consul.Service) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.services[service.ID] = service
+	f.index++
+
+}
+
+// Reject makes the agent reject the registrations of the service.
+func (f *FakeConsul) Reject(id string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.rejected[id] = true
+}
+
+func (f *FakeConsul) Nodes() map[string]consul.Node {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var nodes = make(map[string]consul.Node, len(f.nodes))
+	for name, node := range f.nodes {
+		nodes[name] = node
+	}
+	return nodes
+
+}
+
+func (f *FakeConsul) Index() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.index
+}
+
+func (f *FakeConsul) serve(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.Itoa(f.index))
+	path := r.URL.Path
+	switch {
+	case path == "/v1/catalog/services":
+		catalog := make(map[string][]string)
+		for _, service := range f.services {
+			catalog[service.Name] = mergeTags(catalog[service.Name], service.Tags)
+		}
+		writeJSON(w, catalog)
+	case strings.HasPrefix(path, "/v1/catalog/service/"):
+		name := strings.TrimPrefix(path, "/v1/catalog/service/")
+		tag := r.URL.Query().Get("tag")
+		entries := make([]consul.Service, 0)
+		for _, service := range f.services {
+			if service.Name == name && (len(tag) == 0 || hasTag(service.Tags, tag)) {
+				entries = append(entries, consul.Service{
+					Address:        service.Address,
+					ServiceName:    service.Name,
+					ServiceID:      service.ID,
+					ServiceTags:    service.Tags,
+					ServicePort:    service.Port,
+					ServiceMeta:    service.Meta,
+					ServiceWeights: service.Weights,
+				})
+			}
+		}
+		writeJSON(w, entries)
+	case path == "/v1/agent/self":
+		writeJSON(w, map[string]interface{}{"Config": map[string]string{"NodeName": "fake", "Datacenter": "dc1"}})
+	case path == "/v1/catalog/register":
+		var node consul.Node
+		if err := json.NewDecoder(r.Body).Decode(&node); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.nodes[node.Node] = node
+		f.index++
+		writeJSON(w, true)
+	case path == "/v1/agent/service/register":
+		var service consul.Service
+		if err := json.NewDecoder(r.Body).Decode(&service); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(service.ID) == 0 {
+			service.ID = service.Name
+		}
+		if f.rejected[service.ID] {
+			http.Error(w, "Invalid service: "+service.ID, http.StatusBadRequest)
+			return
+		}
+		f.services[service.ID] = service
+		f.index++
+	case strings.HasPrefix(path, "/v1/agent/service/deregister/"):
+		delete(f.services, strings.TrimPrefix(path, "/v1/agent/service/deregister/"))
+		f.index++
+	case path == "/v1/session/create":
+		f.sessions++
+		writeJSON(w, map[string]string{"ID": "session-" + strconv.Itoa(f.sessions)})
+	case strings.HasPrefix(path, "/v1/session/"):
+	case strings.HasPrefix(path, "/v1/kv/"):
+		key := strings.TrimPrefix(path, "/v1/kv/")
+		if session := r.URL.Query().Get("acquire"); len(session) > 0 {
+			holder, locked := f.kv[key]
+			if !locked {
+				f.kv[key] = session
+			}
+			writeJSON(w, !locked || holder == session)
+		} else if session := r.URL.Query().Get("release"); len(session) > 0 {
+			if f.kv[key] == session {
+				delete(f.kv, key)
+			}
+			writeJSON(w, true)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func mergeTags(tags []string, others []string) []string {
+	for _, tag := range others {
+		if !hasTag(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package topology
+
+import "net/url"
+
+// Attributes are the key value attributes of a host, e.g. its rack or config
+// group. They are kept encoded with sorted keys, so the components carrying
+// them stay comparable.
+type Attributes string
+
+func NewAttributes(values map[string]string) Attributes {
+	var query = make(url.Values)
+	for key, value := range values {
+		if len(value) > 0 {
+			query.Set(key, value)
+		}
+	}
+	return Attributes(query.Encode())
+}
+
+func (a Attributes) Get(key string) string {
+	query, _ := url.ParseQuery(string(a))
+	return query.Get(key)
+}
+
+// With returns the attributes with the key set to the value, or removed when
+// the value is empty.
+func (a Attributes) With(key string, value string) Attributes {
+	query, _ := url.ParseQuery(string(a))
+	if len(value) > 0 {
+		query.Set(key, value)
+	} else {
+		query.Del(key)
+	}
+	return Attributes(query.Encode())
+}
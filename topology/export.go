@@ -0,0 +1,99 @@
+package topology
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ExportedHost is a host with its components in the topology export.
+type ExportedHost struct {
+	Hostname   string              `json:"hostname"`
+	IP         string              `json:"ip,omitempty"`
+	Rack       string              `json:"rack,omitempty"`
+	OSType     string              `json:"os_type,omitempty"`
+	State      string              `json:"state,omitempty"`
+	Components []ExportedComponent `json:"components"`
+}
+
+type ExportedComponent struct {
+	Component   string `json:"component"`
+	Service     string `json:"service,omitempty"`
+	Cluster     string `json:"cluster,omitempty"`
+	State       string `json:"state"`
+	Maintenance bool   `json:"maintenance,omitempty"`
+}
+
+// Export groups the components by host, sorted by hostname and component.
+// The aliased and static components are left out, they are not discovered.
+func Export(components []HostComponent, hosts map[string]Host) []ExportedHost {
+	var byHost = make(map[string]*ExportedHost)
+	for hostname, host := range hosts {
+		byHost[hostname] = &ExportedHost{Hostname: hostname, IP: host.IP, Rack: host.Rack, OSType: host.OSType, State: host.State}
+	}
+	for _, component := range components {
+		if len(component.Alias) > 0 || len(component.Static) > 0 {
+			continue
+		}
+		host, ok := byHost[component.Hostname]
+		if !ok {
+			host = &ExportedHost{Hostname: component.Hostname, IP: component.IP, Rack: component.Rack}
+			byHost[component.Hostname] = host
+		}
+		host.Components = append(host.Components, ExportedComponent{
+			Component:   component.HostComponent,
+			Service:     component.Service,
+			Cluster:     component.Cluster,
+			State:       component.State,
+			Maintenance: component.Maintenance,
+		})
+	}
+	var exported = make([]ExportedHost, 0, len(byHost))
+	for _, host := range byHost {
+		if host.Components == nil {
+			host.Components = make([]ExportedComponent, 0)
+		}
+		sort.Slice(host.Components, func(i, j int) bool {
+			return host.Components[i].Component < host.Components[j].Component
+		})
+		exported = append(exported, *host)
+	}
+	sort.Slice(exported, func(i, j int) bool {
+		return exported[i].Hostname < exported[j].Hostname
+	})
+	return exported
+}
+
+func WriteJSON(w io.Writer, hosts []ExportedHost) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(hosts)
+}
+
+// WriteDOT renders the topology as a Graphviz graph, with a cluster subgraph
+// per host and a node per component labelled with its state.
+func WriteDOT(w io.Writer, hosts []ExportedHost) error {
+	var b strings.Builder
+	b.WriteString("digraph topology {\n  rankdir=LR;\n  node [shape=box];\n")
+	for i, host := range hosts {
+		fmt.Fprintf(&b, "  subgraph cluster_%d {\n    label=%q;\n", i, host.Hostname+"\n"+host.IP)
+		for _, component := range host.Components {
+			color := "black"
+			if component.Maintenance {
+				color = "gray"
+			} else if strings.ToUpper(component.State) == "STARTED" {
+				color = "darkgreen"
+			} else if !IsTransitionalState(component.State) {
+				color = "red"
+			}
+			fmt.Fprintf(&b, "    %q [label=%q, color=%s];\n", component.Component+"@"+host.Hostname,
+				component.Component+"\n"+component.State, color)
+		}
+		b.WriteString("  }\n")
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,58 @@
+package topology
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Source lists the host components which should be registered as services.
+type Source interface {
+	ListComponents(ctx context.Context) ([]HostComponent, error)
+}
+
+// HostSource is implemented by the sources which know the hosts of the
+// components too. Hosts returns the hosts seen by the last listing.
+type HostSource interface {
+	Hosts() map[string]Host
+}
+
+// MultiSource lists the components of every source concurrently. A failing
+// source fails the whole listing, otherwise the registrations of its
+// components would be removed.
+type MultiSource []Source
+
+func (m MultiSource) ListComponents(ctx context.Context) ([]HostComponent, error) {
+	var wg sync.WaitGroup
+	var results = make([][]HostComponent, len(m))
+	var errs = make([]error, len(m))
+	for i, source := range m {
+		wg.Add(1)
+		go func(i int, source Source) {
+			defer wg.Done()
+			results[i], errs[i] = source.ListComponents(ctx)
+		}(i, source)
+	}
+	wg.Wait()
+
+	var components = make([]HostComponent, 0)
+	for i := range m {
+		if errs[i] != nil {
+			return nil, errors.New("Failed to list the components: " + errs[i].Error())
+		}
+		components = append(components, results[i]...)
+	}
+	return components, nil
+}
+
+func (m MultiSource) Hosts() map[string]Host {
+	var hosts = make(map[string]Host)
+	for _, source := range m {
+		if hostSource, ok := source.(HostSource); ok {
+			for hostname, host := range hostSource.Hosts() {
+				hosts[hostname] = host
+			}
+		}
+	}
+	return hosts
+}
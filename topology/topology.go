@@ -0,0 +1,99 @@
+package topology
+
+import "strings"
+
+// Host is a host known by Ambari. Its fingerprint changes whenever any of the
+// tracked host attributes changes.
+type Host struct {
+	IP          string
+	Rack        string
+	OSType      string
+	State       string
+	Attributes  Attributes
+	Fingerprint string
+}
+
+// HostComponent is a component running on a host which may be registered as
+// a service. Alias and Static are set for the extra registrations of aliased
+// components and for the static services declared in the config file. Port is
+// set by the sources which know the port of the component, Stack by the
+// Cloudbreak enrichment and Check by the sources which know how the component
+// is monitored. MaintenanceReason tells where the maintenance mode comes from.
+// HostAttributes are the attributes of the host, extended with the cluster
+// specific ones like the config group.
+type HostComponent struct {
+	Hostname          string
+	IP                string
+	HostComponent     string
+	Service           string
+	State             string
+	Cluster           string
+	Rack              string
+	Alias             string
+	Static            string
+	Port              int64
+	Stack             StackInfo
+	Check             CheckInfo
+	Maintenance       bool
+	MaintenanceReason string
+	HostAttributes    Attributes
+}
+
+// CheckInfo describes the health check of a component, Type is PORT for a TCP
+// check and WEB for an HTTP check of the port.
+type CheckInfo struct {
+	Type string
+	Port int64
+}
+
+// StackInfo is the Cloudbreak stack metadata of a component.
+type StackInfo struct {
+	Name          string
+	Blueprint     string
+	CloudPlatform string
+	InstanceGroup string
+}
+
+func (c HostComponent) Key() string {
+	if len(c.Static) > 0 {
+		return "static@" + c.Static
+	}
+	if len(c.Alias) > 0 {
+		return c.HostComponent + "@" + c.Hostname + "@" + c.Alias
+	}
+	return c.HostComponent + "@" + c.Hostname
+}
+
+func SetHostInfo(components []HostComponent, hosts map[string]Host) []HostComponent {
+	for i := range components {
+		components[i].IP = hosts[components[i].Hostname].IP
+		components[i].Rack = hosts[components[i].Hostname].Rack
+		components[i].HostAttributes = hosts[components[i].Hostname].Attributes
+	}
+	return components
+}
+
+// Deduplicate collapses the components listed more than once for the same
+// host and IP, e.g. by both the root and a cluster service. The cluster
+// component is kept over the one without cluster, otherwise the first one.
+func Deduplicate(components []HostComponent) []HostComponent {
+	var result = make([]HostComponent, 0, len(components))
+	var index = make(map[string]int)
+	for _, component := range components {
+		key := component.Key() + "@" + component.IP
+		if i, ok := index[key]; ok {
+			if len(result[i].Cluster) == 0 && len(component.Cluster) > 0 {
+				result[i] = component
+			}
+			continue
+		}
+		index[key] = len(result)
+		result = append(result, component)
+	}
+	return result
+}
+
+func IsTransitionalState(state string) bool {
+	state = strings.ToUpper(state)
+	return state == "INIT" || strings.HasSuffix(state, "ING")
+}